@@ -0,0 +1,332 @@
+package ginext
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const AccountDeleteTaskType = "ginext.account.delete"
+
+type AccountPasswordForm struct {
+	Password string `json:"password" binding:"required"`
+}
+
+type AccountDeleteResult struct {
+	DeleteAt time.Time `json:"deleteAt"`
+}
+
+// CheckPassword the raw password of user
+func (um *UserManager) CheckPassword(user *GinExtUser, rawPassword string) bool {
+	return len(user.Password) > 0 && user.Password == um.hashPassword(rawPassword)
+}
+
+// Deactivate disable the account and revoke the tokens, restored by Restore
+func (um *UserManager) Deactivate(user *GinExtUser) error {
+	now := time.Now()
+	vals := map[string]interface{}{
+		"Enabled":       false,
+		"DeactivatedAt": &now,
+	}
+	if err := um.db.Model(user).Updates(vals).Error; err != nil {
+		return err
+	}
+	return um.RevokeTokens(user)
+}
+
+// ScheduleDelete deactivate the account, which is deleted by the worker after AccountDeleteGrace
+func (um *UserManager) ScheduleDelete(ctx context.Context, user *GinExtUser) (time.Time, error) {
+	if err := um.Deactivate(user); err != nil {
+		return time.Time{}, err
+	}
+	deleteAt := time.Now().Add(um.AccountDeleteGrace)
+	if err := um.db.Model(user).Update("DeleteAt", &deleteAt).Error; err != nil {
+		return deleteAt, err
+	}
+
+	wm := um.WorkerManager
+	if wm == nil {
+		wm = DefaultWorkerManager()
+	}
+	if wm == nil {
+		um.ext.Logger.Warn("account deletion without WorkerManager", "user_id", user.ID)
+		return deleteAt, nil
+	}
+	return deleteAt, wm.AddContext(ctx, int64(user.ID), AccountDeleteTaskType, "", um.AccountDeleteGrace)
+}
+
+// Restore the deactivated or deleting account, the account disabled by staff is not restored
+func (um *UserManager) Restore(user *GinExtUser) error {
+	if user.DeactivatedAt == nil {
+		return errors.New("user is not deactivated")
+	}
+	vals := map[string]interface{}{
+		"Enabled":       true,
+		"DeactivatedAt": nil,
+		"DeleteAt":      nil,
+	}
+	return um.db.Model(user).Updates(vals).Error
+}
+
+// DeleteAccount delete the user with the profile, tokens, verify codes, credentials, password history,
+// invitations and memberships, the owned organizations are transferred by transferOwnedOrgs.
+// The data of other modules are deleted by SigUserDelete. The user is anonymised when AccountAnonymize
+func (um *UserManager) DeleteAccount(user *GinExtUser) error {
+	return um.db.Transaction(func(tx *gorm.DB) error {
+		Sig().Emit(SigUserDelete, user, tx)
+
+		if err := um.transferOwnedOrgs(tx, user); err != nil {
+			return err
+		}
+		if err := tx.Where("user_id", user.ID).Delete(&GinProfile{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("owner_id", user.ID).Delete(&GinToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id", user.ID).Delete(&GinCredential{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id", user.ID).Delete(&GinMembership{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id", user.ID).Delete(&GinPasswordHistory{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("inviter_id", user.ID).Or("email", user.Email).Delete(&GinInvitation{}).Error; err != nil {
+			return err
+		}
+		sources := []string{user.Email}
+		if len(user.Phone) > 0 {
+			sources = append(sources, user.Phone)
+		}
+		codes := tx.Where("source IN ?", sources).Or("`key` LIKE ?", fmt.Sprintf("%%-%d", user.ID))
+		if err := codes.Delete(&GinVerifyCode{}).Error; err != nil {
+			return err
+		}
+
+		if !um.AccountAnonymize {
+			return tx.Delete(user).Error
+		}
+		vals := map[string]interface{}{
+			"UserName":    fmt.Sprintf("deleted-%d", user.ID),
+			"Email":       fmt.Sprintf("deleted-%d@deleted.invalid", user.ID),
+			"Phone":       "",
+			"FirstName":   "",
+			"LastName":    "",
+			"Password":    "",
+			"DisplayName": "",
+			"LastLoginIP": "",
+			"WxOpenID":    "",
+			"WxUnionID":   "",
+			"FBAuthID":    "",
+			"GGAuthID":    "",
+			"Enabled":     false,
+			"DeleteAt":    nil,
+		}
+		return tx.Model(user).Updates(vals).Error
+	})
+}
+
+// transferOwnedOrgs the organizations owned by user are transferred to the first admin, or the first member,
+// the organization without other members is deleted with the invitations
+func (um *UserManager) transferOwnedOrgs(tx *gorm.DB, user *GinExtUser) error {
+	var orgs []GinOrganization
+	if err := tx.Where("owner_id", user.ID).Find(&orgs).Error; err != nil {
+		return err
+	}
+	for _, org := range orgs {
+		var members []GinMembership
+		if err := tx.Where("org_id", org.ID).Where("user_id <> ?", user.ID).Order("id").Find(&members).Error; err != nil {
+			return err
+		}
+		if len(members) <= 0 {
+			if err := tx.Where("org_id", org.ID).Delete(&GinInvitation{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("org_id", org.ID).Delete(&GinMembership{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Delete(&org).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		next := members[0]
+		for _, m := range members {
+			if orgRoleRanks[m.Role] > orgRoleRanks[next.Role] {
+				next = m
+			}
+		}
+		if err := tx.Model(&next).UpdateColumn("role", OrgRoleOwner).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&org).UpdateColumn("owner_id", next.UserID).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RegisterWorker handle the account deletion and the audit prune tasks of worker
+func (um *UserManager) RegisterWorker(w *Worker) {
+	w.AddHandle(AccountDeleteTaskType, um.handleAccountDeleteTask)
+	w.AddHandle(AuditPruneTaskType, um.handleAuditPruneTask)
+}
+
+func (um *UserManager) handleAccountDeleteTask(t *GinTask) (string, error) {
+	var user GinExtUser
+	if err := um.db.WithContext(t.Ctx()).Take(&user, t.ObjectID).Error; err != nil {
+		return `{"msg":"user not found"}`, nil
+	}
+	// restored, or deleted again later
+	if user.DeleteAt == nil || time.Until(*user.DeleteAt) > 0 {
+		return `{"msg":"skip"}`, nil
+	}
+	if err := um.DeleteAccount(&user); err != nil {
+		return "", err
+	}
+	return `{"msg":"deleted"}`, nil
+}
+
+// ExportAccount the personal data of user, the data of other modules are added by SigUserExport
+func (um *UserManager) ExportAccount(user *GinExtUser) map[string]interface{} {
+	archive := map[string]interface{}{
+		"exportedAt": time.Now(),
+		"user": gin.H{
+			"id":          user.ID,
+			"createdAt":   user.CreatedAt,
+			"username":    user.UserName,
+			"email":       user.Email,
+			"phone":       user.Phone,
+			"firstName":   user.FirstName,
+			"lastName":    user.LastName,
+			"displayName": user.DisplayName,
+			"lastLogin":   user.LastLogin,
+			"lastLoginIp": user.LastLoginIP,
+			"source":      user.Source,
+		},
+	}
+	if profile, err := GetProfile(um.db, user.ID); err == nil {
+		archive["profile"] = profile
+	}
+
+	var tokens []GinToken
+	um.db.Where("owner_id", user.ID).Order("id").Find(&tokens)
+	tokenVals := make([]gin.H, 0, len(tokens))
+	for _, v := range tokens {
+		// the token is secret
+		tokenVals = append(tokenVals, gin.H{"createdAt": v.CreatedAt, "expiredAt": v.ExpiredAt})
+	}
+	archive["tokens"] = tokenVals
+
+	creds, _ := um.GetCredentials(user)
+	credVals := make([]WebAuthnCredentialResult, 0, len(creds))
+	for _, v := range creds {
+		credVals = append(credVals, v.Result())
+	}
+	archive["credentials"] = credVals
+
+	memberships, _ := um.GetMemberships(user)
+	orgVals := make([]OrgResult, 0, len(memberships))
+	for i := range memberships {
+		orgVals = append(orgVals, newOrgResult(&memberships[i]))
+	}
+	archive["organizations"] = orgVals
+
+	var histories []GinPasswordHistory
+	um.db.Where("user_id", user.ID).Order("id").Find(&histories)
+	historyVals := make([]gin.H, 0, len(histories))
+	for _, v := range histories {
+		// the password hash is secret
+		historyVals = append(historyVals, gin.H{"createdAt": v.CreatedAt})
+	}
+	archive["passwordHistory"] = historyVals
+
+	var invitations []GinInvitation
+	um.db.Where("inviter_id", user.ID).Or("email", user.Email).Order("id").Find(&invitations)
+	invitationVals := make([]gin.H, 0, len(invitations))
+	for _, v := range invitations {
+		// the key is secret
+		invitationVals = append(invitationVals, gin.H{
+			"createdAt": v.CreatedAt,
+			"orgId":     v.OrgID,
+			"inviterId": v.InviterID,
+			"email":     v.Email,
+			"role":      v.Role,
+			"status":    v.Status,
+			"expiredAt": v.ExpiredAt,
+		})
+	}
+	archive["invitations"] = invitationVals
+
+	Sig().Emit(SigUserExport, user, archive)
+	return archive
+}
+
+func (um *UserManager) handleAccountDeactivate(c *gin.Context) {
+	user := CurrentUser(c)
+	form := c.MustGet(RpcFormField).(*AccountPasswordForm)
+	if !um.CheckPassword(user, form.Password) {
+		RpcFail(c, ErrCodeBadPassword, "bad password")
+		return
+	}
+	if err := um.Deactivate(user); err != nil {
+		RpcFail(c, ErrCodeServerError, "deactivate fail")
+		return
+	}
+	Logout(c)
+	RpcOk(c, true)
+}
+
+func (um *UserManager) handleAccountDelete(c *gin.Context) {
+	user := CurrentUser(c)
+	form := c.MustGet(RpcFormField).(*AccountPasswordForm)
+	if !um.CheckPassword(user, form.Password) {
+		RpcFail(c, ErrCodeBadPassword, "bad password")
+		return
+	}
+	deleteAt, err := um.ScheduleDelete(c.Request.Context(), user)
+	if err != nil {
+		RpcFail(c, ErrCodeServerError, "delete fail")
+		return
+	}
+	Logout(c)
+	RpcOk(c, AccountDeleteResult{DeleteAt: deleteAt})
+}
+
+func (um *UserManager) handleAccountRestore(c *gin.Context) {
+	form := c.MustGet(RpcFormField).(*LoginForm)
+	key := strings.ToLower(form.UserName)
+	if len(key) <= 0 {
+		key = strings.ToLower(form.Email)
+	}
+	var user GinExtUser
+	if len(key) <= 0 || um.db.Where("user_name", key).Or("email", key).Take(&user).Error != nil || !um.CheckPassword(&user, form.Password) {
+		RpcAbort(c, ErrBadPassword)
+		return
+	}
+	if err := um.Restore(&user); err != nil {
+		RpcFail(c, ErrCodeNotAllowed, err.Error())
+		return
+	}
+
+	um.ext.Metrics.incLogin(true)
+	Login(c, &user)
+	RpcOk(c, UserInfoResult{
+		UserName:  user.UserName,
+		Email:     user.Email,
+		LastLogin: user.LastLogin,
+	})
+}
+
+func (um *UserManager) handleAccountExport(c *gin.Context) {
+	user := CurrentUser(c)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="account-%d.json"`, user.ID))
+	RpcOk(c, um.ExportAccount(user))
+}
@@ -0,0 +1,207 @@
+package ginext
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountDeleteRestore(t *testing.T) {
+	um, r := NewTestUserManager()
+	wm := NewWorkerManager(um.ext)
+	wm.Init()
+	wm.db.Delete(&GinTask{}, "id > 0")
+	um.WorkerManager = wm
+	um.AccountDeleteGrace = time.Hour
+	um.RegisterHandler("/auth", r)
+	client := NewTestHTTPClient(r)
+	addUser(t, client, r, "bob", "bob@example.org", "hello123")
+
+	login := func() error {
+		var info UserInfoResult
+		return client.Call("/auth/login", LoginForm{UserName: "bob", Password: "hello123"}, &info)
+	}
+	assert.Nil(t, login())
+	var token TokenResult
+	assert.Nil(t, client.Call("/auth/token", LoginForm{UserName: "bob", Password: "hello123"}, &token))
+
+	{
+		var result AccountDeleteResult
+		err := client.Call("/auth/account/delete", AccountPasswordForm{Password: "bad"}, &result)
+		assert.NotNil(t, err)
+
+		err = client.Call("/auth/account/delete", AccountPasswordForm{Password: "hello123"}, &result)
+		assert.Nil(t, err)
+		assert.True(t, time.Until(result.DeleteAt) > 50*time.Minute)
+
+		err = client.Call("/auth/profile", nil, nil)
+		assert.NotNil(t, err)
+		assert.NotNil(t, login())
+		_, err = um.GetUserByToken(token.Token)
+		assert.NotNil(t, err)
+
+		var task GinTask
+		assert.Nil(t, wm.db.Where("task_type", AccountDeleteTaskType).Take(&task).Error)
+		assert.True(t, time.Until(*task.StartTime) > 50*time.Minute)
+	}
+	{
+		var info UserInfoResult
+		err := client.Call("/auth/account/restore", LoginForm{UserName: "bob", Password: "hello123"}, &info)
+		assert.Nil(t, err)
+		var profile UserProfileResult
+		err = client.Call("/auth/profile", nil, &profile)
+		assert.Nil(t, err)
+
+		// the restored account is not deleted by the task
+		var task GinTask
+		wm.db.Where("task_type", AccountDeleteTaskType).Take(&task)
+		w := NewWorker(wm.db, "account")
+		um.RegisterWorker(w)
+		assert.Nil(t, w.DoTask(&task))
+		_, err = um.Get("bob")
+		assert.Nil(t, err)
+	}
+	{
+		// the account disabled by staff is not restored
+		bob, _ := um.Get("bob")
+		um.SetEnabled(bob, false)
+		var info UserInfoResult
+		err := client.Call("/auth/account/restore", LoginForm{UserName: "bob", Password: "hello123"}, &info)
+		assert.NotNil(t, err)
+		um.SetEnabled(bob, true)
+	}
+	{
+		assert.Nil(t, login())
+		ok := false
+		err := client.Call("/auth/account/deactivate", AccountPasswordForm{Password: "hello123"}, &ok)
+		assert.Nil(t, err)
+		assert.NotNil(t, login())
+		var info UserInfoResult
+		err = client.Call("/auth/account/restore", LoginForm{Email: "bob@example.org", Password: "hello123"}, &info)
+		assert.Nil(t, err)
+	}
+}
+
+func TestAccountDeleteTask(t *testing.T) {
+	um, _ := NewTestUserManager()
+	wm := NewWorkerManager(um.ext)
+	wm.Init()
+	wm.db.Delete(&GinTask{}, "id > 0")
+	um.WorkerManager = wm
+	um.AccountDeleteGrace = time.Millisecond
+	w := NewWorker(wm.db, "account")
+	um.RegisterWorker(w)
+
+	var deleted []uint
+	sid := Sig().Connect(SigUserDelete, func(sender interface{}, params ...interface{}) {
+		deleted = append(deleted, sender.(*GinExtUser).ID)
+	})
+	defer Sig().Disconnect(SigUserDelete, sid)
+
+	deleteUser := func(user *GinExtUser) {
+		_, err := um.ScheduleDelete(context.Background(), user)
+		assert.Nil(t, err)
+		time.Sleep(5 * time.Millisecond)
+		var task GinTask
+		assert.Nil(t, wm.db.Where("task_type", AccountDeleteTaskType).Where("object_id", user.ID).Take(&task).Error)
+		assert.Nil(t, w.DoTask(&task))
+	}
+
+	bob, _ := um.Create("bob", "bob@example.org", "hello123")
+	UpdateProfile(um.db, bob.ID, &GinProfile{UserID: bob.ID, Locale: "en"})
+	um.MakeToken(bob)
+	um.genVerifyCode(bob, "bob@example.org")
+	um.db.Create(&GinPasswordHistory{UserID: bob.ID, Password: "old"})
+	carol, _ := um.Create("carol", "carol@example.org", "hello123")
+	dave, _ := um.Create("dave", "dave@example.org", "hello123")
+	shared, _ := um.CreateOrganization(bob, "shared")
+	um.AddMember(shared.OrgID, dave, OrgRoleMember)
+	um.AddMember(shared.OrgID, carol, OrgRoleAdmin)
+	alone, _ := um.CreateOrganization(bob, "alone")
+	um.db.Create(&GinInvitation{OrgID: alone.OrgID, InviterID: bob.ID, Email: "erin@example.org", Key: "alone-key"})
+	deleteUser(bob)
+
+	_, err := um.GetById(bob.ID)
+	assert.NotNil(t, err)
+	var count int64
+	um.db.Model(&GinProfile{}).Where("user_id", bob.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+	um.db.Model(&GinToken{}).Where("owner_id", bob.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+	um.db.Model(&GinVerifyCode{}).Where("source", "bob@example.org").Count(&count)
+	assert.Equal(t, int64(0), count)
+	um.db.Model(&GinPasswordHistory{}).Where("user_id", bob.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+	um.db.Model(&GinInvitation{}).Where("inviter_id", bob.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	// the organization is transferred to the admin, or deleted without other members
+	var org GinOrganization
+	assert.Nil(t, um.db.Take(&org, shared.OrgID).Error)
+	assert.Equal(t, carol.ID, org.OwnerID)
+	var m GinMembership
+	assert.Nil(t, um.db.Where("org_id", shared.OrgID).Where("user_id", carol.ID).Take(&m).Error)
+	assert.Equal(t, OrgRoleOwner, m.Role)
+	assert.NotNil(t, um.db.Take(&org, alone.OrgID).Error)
+	um.db.Model(&GinMembership{}).Where("org_id", alone.OrgID).Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	um.AccountAnonymize = true
+	alice, _ := um.Create("alice", "alice@example.org", "hello123")
+	deleteUser(alice)
+	alice, err = um.GetById(alice.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "", alice.Password)
+	assert.Contains(t, alice.Email, "@deleted.invalid")
+	assert.False(t, alice.Enabled)
+
+	assert.Equal(t, []uint{bob.ID, alice.ID}, deleted)
+}
+
+func TestAccountExport(t *testing.T) {
+	um, r := NewTestUserManager()
+	um.RegisterHandler("/auth", r)
+	client := NewTestHTTPClient(r)
+	addUser(t, client, r, "bob", "bob@example.org", "hello123")
+
+	sid := Sig().Connect(SigUserExport, func(sender interface{}, params ...interface{}) {
+		archive := params[0].(map[string]interface{})
+		archive["orders"] = []string{"order-1"}
+	})
+	defer Sig().Disconnect(SigUserExport, sid)
+
+	bob, _ := um.Get("bob")
+	um.db.Create(&GinPasswordHistory{UserID: bob.ID, Password: "old-password-hash"})
+	um.db.Create(&GinInvitation{OrgID: 1, Email: "bob@example.org", Role: OrgRoleMember, Key: "bob-invite-key"})
+
+	var info UserInfoResult
+	assert.Nil(t, client.Call("/auth/login", LoginForm{UserName: "bob", Password: "hello123"}, &info))
+	var token TokenResult
+	assert.Nil(t, client.Call("/auth/token", LoginForm{UserName: "bob", Password: "hello123"}, &token))
+
+	w := client.Get("/auth/account/export")
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "attachment")
+	var archive struct {
+		User struct {
+			UserName string `json:"username"`
+			Email    string `json:"email"`
+		} `json:"user"`
+		Tokens          []map[string]interface{} `json:"tokens"`
+		PasswordHistory []map[string]interface{} `json:"passwordHistory"`
+		Invitations     []map[string]interface{} `json:"invitations"`
+		Orders          []string                 `json:"orders"`
+	}
+	err := client.Call("/auth/account/export", nil, &archive)
+	assert.Nil(t, err)
+	assert.Equal(t, "bob", archive.User.UserName)
+	assert.Equal(t, 1, len(archive.Tokens))
+	assert.Equal(t, 1, len(archive.PasswordHistory))
+	assert.Equal(t, 1, len(archive.Invitations))
+	assert.NotContains(t, w.Body.String(), "old-password-hash")
+	assert.NotContains(t, w.Body.String(), "bob-invite-key")
+	assert.NotContains(t, w.Body.String(), token.Token)
+	assert.NotContains(t, w.Body.String(), "md5$")
+	assert.Equal(t, []string{"order-1"}, archive.Orders)
+}
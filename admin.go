@@ -0,0 +1,450 @@
+package ginext
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	/admin/users/list
+	/admin/users/edit
+	/admin/users/password/reset
+	/admin/users/logout
+	/admin/users/impersonate
+	/admin/users/impersonate/stop
+	/admin/users/import
+*/
+
+type AdminUserListForm struct {
+	PaginationForm
+	Enabled        *bool      `json:"enabled"`
+	IsStaff        *bool      `json:"isStaff"`
+	Source         *string    `json:"source"`
+	CreatedAfter   *time.Time `json:"createdAfter"`
+	CreatedBefore  *time.Time `json:"createdBefore"`
+	LastLoginAfter *time.Time `json:"lastLoginAfter"`
+	// The users never login are excluded
+	LastLoginBefore *time.Time `json:"lastLoginBefore"`
+}
+
+type AdminUserResult struct {
+	ID            uint       `json:"id"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	UserName      string     `json:"username"`
+	Email         string     `json:"email"`
+	Phone         string     `json:"phone"`
+	FirstName     string     `json:"firstName"`
+	LastName      string     `json:"lastName"`
+	DisplayName   string     `json:"displayName"`
+	IsStaff       bool       `json:"isStaff"`
+	Enabled       bool       `json:"enabled"`
+	Actived       bool       `json:"actived"`
+	LastLogin     *time.Time `json:"lastLogin,omitempty"`
+	LastLoginIP   string     `json:"lastLoginIp"`
+	Source        string     `json:"source"`
+	DeactivatedAt *time.Time `json:"deactivatedAt,omitempty"`
+	DeleteAt      *time.Time `json:"deleteAt,omitempty"`
+}
+
+type AdminUserListResult struct {
+	PaginationResult
+	Items []AdminUserResult `json:"items"`
+}
+
+type AdminUserForm struct {
+	ID uint `json:"id" binding:"required"`
+}
+
+// AdminUserEditForm the nil fields are not changed
+type AdminUserEditForm struct {
+	ID          uint    `json:"id" binding:"required"`
+	UserName    *string `json:"username" binding:"omitempty,username"`
+	Email       *string `json:"email" binding:"omitempty,email"`
+	Phone       *string `json:"phone"`
+	FirstName   *string `json:"firstName"`
+	LastName    *string `json:"lastName"`
+	DisplayName *string `json:"displayName"`
+	IsStaff     *bool   `json:"isStaff"`
+	Enabled     *bool   `json:"enabled"`
+	Actived     *bool   `json:"actived"`
+}
+
+type AdminPasswordResetForm struct {
+	ID       uint   `json:"id" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// AdminUserImportForm the csv with header, the columns: username, email, password, phone,
+// displayName, firstName, lastName, isStaff. The email is required, the empty password is random
+type AdminUserImportForm struct {
+	CSV string `json:"csv" binding:"required"`
+}
+
+type AdminImportError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+type AdminUserImportResult struct {
+	Created int                `json:"created"`
+	Errors  []AdminImportError `json:"errors"`
+}
+
+const docAdminUserList = `List and search the users, staff only`
+const docAdminUserEdit = `Edit the user, staff only`
+const docAdminPasswordReset = `Reset the password of user and force logout, staff only`
+const docAdminUserLogout = `Revoke the tokens and sessions of user, staff only`
+const docAdminImpersonate = `Login as the user, the staff is recorded, staff only`
+const docAdminImpersonateStop = `Stop the impersonation, login as the staff again`
+const docAdminUserImport = `Create the users from csv, staff only`
+
+func NewAdminUserResult(u *GinExtUser) AdminUserResult {
+	return AdminUserResult{
+		ID:            u.ID,
+		CreatedAt:     u.CreatedAt,
+		UserName:      u.UserName,
+		Email:         u.Email,
+		Phone:         u.Phone,
+		FirstName:     u.FirstName,
+		LastName:      u.LastName,
+		DisplayName:   u.DisplayName,
+		IsStaff:       u.IsStaff,
+		Enabled:       u.Enabled,
+		Actived:       u.Actived,
+		LastLogin:     u.LastLogin,
+		LastLoginIP:   u.LastLoginIP,
+		Source:        u.Source,
+		DeactivatedAt: u.DeactivatedAt,
+		DeleteAt:      u.DeleteAt,
+	}
+}
+
+// RegisterAdminHandler the admin rpc of users, require RegisterHandler first
+func (um *UserManager) RegisterAdminHandler(prefix string, r *gin.Engine) {
+	api := um.ext.Registry.Router(r)
+	api.Registry.AddAppLabel("User Admin")
+
+	RpcDefine(api, &RpcContext{
+		StaffRequired: true,
+		OnlyPost:      true,
+		Form:          AdminUserListForm{},
+		Result:        AdminUserListResult{},
+		RelativePath:  prefix + "/list",
+		Handler:       um.handleAdminUserList,
+		Doc:           docAdminUserList,
+	})
+	Rpc(api, prefix+"/edit", um.handleAdminUserEdit, WithStaffRequired(), WithOnlyPost(), WithDoc(docAdminUserEdit))
+	Rpc(api, prefix+"/password/reset", um.handleAdminPasswordReset, WithStaffRequired(), WithOnlyPost(), WithDoc(docAdminPasswordReset))
+	Rpc(api, prefix+"/logout", um.handleAdminUserLogout, WithStaffRequired(), WithOnlyPost(), WithDoc(docAdminUserLogout))
+	Rpc(api, prefix+"/impersonate", um.handleAdminImpersonate, WithStaffRequired(), WithOnlyPost(), WithDoc(docAdminImpersonate))
+	Rpc(api, prefix+"/impersonate/stop", um.handleAdminImpersonateStop, WithAuthRequired(), WithOnlyPost(), WithDoc(docAdminImpersonateStop))
+	Rpc(api, prefix+"/import", um.handleAdminUserImport, WithStaffRequired(), WithOnlyPost(), WithDoc(docAdminUserImport))
+}
+
+func (um *UserManager) handleAdminUserList(c *gin.Context) {
+	form := c.MustGet(RpcFormField).(*AdminUserListForm)
+	tx := um.db.Model(&GinExtUser{})
+	if form.Enabled != nil {
+		tx = tx.Where("enabled", *form.Enabled)
+	}
+	if form.IsStaff != nil {
+		tx = tx.Where("is_staff", *form.IsStaff)
+	}
+	if form.Source != nil {
+		tx = tx.Where("source", *form.Source)
+	}
+	if form.CreatedAfter != nil {
+		tx = tx.Where("created_at >= ?", *form.CreatedAfter)
+	}
+	if form.CreatedBefore != nil {
+		tx = tx.Where("created_at < ?", *form.CreatedBefore)
+	}
+	if form.LastLoginAfter != nil {
+		tx = tx.Where("last_login >= ?", *form.LastLoginAfter)
+	}
+	if form.LastLoginBefore != nil {
+		tx = tx.Where("last_login < ?", *form.LastLoginBefore)
+	}
+	var r AdminUserListResult
+	ListObject(c, tx, &r, &form.PaginationForm, "id", "user_name LIKE ? OR email LIKE ? OR phone LIKE ? OR display_name LIKE ?")
+}
+
+func (um *UserManager) handleAdminUserEdit(c *gin.Context, form *AdminUserEditForm) (r AdminUserResult, err error) {
+	user, err := um.GetById(form.ID)
+	if err != nil {
+		return r, NewRpcErr(ErrCodeInvalidParams, "user not found")
+	}
+	old := NewAdminUserResult(user)
+
+	if form.UserName != nil && strings.ToLower(*form.UserName) != user.UserName {
+		if um.IsExists(*form.UserName) {
+			return r, ErrUsernameExists
+		}
+		um.SetUserName(user, strings.ToLower(*form.UserName))
+	}
+	if form.Email != nil && strings.ToLower(*form.Email) != user.Email {
+		if um.IsExistsByEmail(*form.Email) {
+			return r, ErrEmailExists
+		}
+		um.SetEmail(user, strings.ToLower(*form.Email))
+	}
+	if form.Phone != nil {
+		phone := ""
+		if len(*form.Phone) > 0 {
+			if phone, err = NormalizePhone(*form.Phone, um.PhoneCountryCode); err != nil {
+				return r, NewRpcErr(ErrCodeInvalidParams, err.Error())
+			}
+			if other, err := um.GetByPhone(phone); err == nil && other.ID != user.ID {
+				return r, ErrPhoneExists
+			}
+		}
+		um.SetPhone(user, phone)
+	}
+	if form.FirstName != nil || form.LastName != nil {
+		firstName, lastName := user.FirstName, user.LastName
+		if form.FirstName != nil {
+			firstName = *form.FirstName
+		}
+		if form.LastName != nil {
+			lastName = *form.LastName
+		}
+		um.SetName(user, firstName, lastName)
+	}
+	if form.DisplayName != nil {
+		um.SetDisplayName(user, *form.DisplayName)
+	}
+	if form.IsStaff != nil {
+		um.SetIsStaff(user, *form.IsStaff)
+	}
+	if form.Enabled != nil {
+		um.SetEnabled(user, *form.Enabled)
+		// the disabled user is logged out from the sessions and the tokens
+		if !*form.Enabled {
+			if err := um.ForceLogout(user); err != nil {
+				return r, err
+			}
+		}
+	}
+	if form.Actived != nil {
+		um.SetActived(user, *form.Actived)
+	}
+
+	if user, err = um.GetById(form.ID); err != nil {
+		return r, err
+	}
+	r = NewAdminUserResult(user)
+	um.audit(c, 0, AuditUserEdit, auditID(user.ID), gin.H{"old": old, "new": r})
+	return r, nil
+}
+
+// handleAdminPasswordReset the password of other staff users can not be reset, same as impersonation
+func (um *UserManager) handleAdminPasswordReset(c *gin.Context, form *AdminPasswordResetForm) (bool, error) {
+	staff := CurrentUser(c)
+	user, err := um.GetById(form.ID)
+	if err != nil {
+		return false, NewRpcErr(ErrCodeInvalidParams, "user not found")
+	}
+	if user.IsStaff && user.ID != staff.ID {
+		return false, NewRpcErr(ErrCodeNotAllowed, "password of staff can not be reset")
+	}
+	if err := um.CheckNewPassword(user, form.Password); err != nil {
+		return false, weakPasswordErr(err)
+	}
+	if err := um.changePassword(c, user, form.Password); err != nil {
+		return false, err
+	}
+	um.audit(c, 0, AuditPasswordReset, auditID(user.ID), nil)
+	return true, nil
+}
+
+func (um *UserManager) handleAdminUserLogout(c *gin.Context, form *AdminUserForm) (bool, error) {
+	user, err := um.GetById(form.ID)
+	if err != nil {
+		return false, NewRpcErr(ErrCodeInvalidParams, "user not found")
+	}
+	um.audit(c, 0, AuditForceLogout, auditID(user.ID), nil)
+	return true, um.ForceLogout(user)
+}
+
+// setSessionUser switch the user of session without updating the last login
+func setSessionUser(c *gin.Context, user *GinExtUser, impersonator uint) {
+	c.Set(UserIdField, user)
+	session := sessions.Default(c)
+	session.Set(UserIdField, user.ID)
+	session.Set(SessionVersionField, user.SessionVersion)
+	session.Delete(WebAuthnUserField)
+	if impersonator > 0 {
+		session.Set(ImpersonatorField, impersonator)
+	} else {
+		session.Delete(ImpersonatorField)
+	}
+	session.Delete(OrgIdField)
+	session.Save()
+	c.Set(OrgIdField, nil)
+}
+
+// CurrentImpersonator the staff who is impersonating the current user
+func CurrentImpersonator(c *gin.Context) uint {
+	if CurrentToken(c) != nil {
+		return 0
+	}
+	id, _ := sessions.Default(c).Get(ImpersonatorField).(uint)
+	return id
+}
+
+// handleAdminImpersonate the staff users can not be impersonated
+func (um *UserManager) handleAdminImpersonate(c *gin.Context, form *AdminUserForm) (r UserInfoResult, err error) {
+	staff := CurrentUser(c)
+	user, err := um.GetById(form.ID)
+	if err != nil {
+		return r, NewRpcErr(ErrCodeInvalidParams, "user not found")
+	}
+	if user.IsStaff || !user.Enabled {
+		return r, NewRpcErr(ErrCodeNotAllowed, "user can not be impersonated")
+	}
+
+	CurrentLogger(c).Warn("impersonate user", "staff_id", staff.ID, "staff", staff.UserName, "user_id", user.ID, "user", user.UserName)
+	um.audit(c, staff.ID, AuditImpersonate, auditID(user.ID), nil)
+	Sig().Emit(SigUserImpersonate, staff, user, c)
+	setSessionUser(c, user, staff.ID)
+	return UserInfoResult{
+		UserName:  user.UserName,
+		Email:     user.Email,
+		LastLogin: user.LastLogin,
+	}, nil
+}
+
+func (um *UserManager) handleAdminImpersonateStop(c *gin.Context, form *struct{}) (r UserInfoResult, err error) {
+	staffID := CurrentImpersonator(c)
+	if staffID <= 0 {
+		return r, NewRpcErr(ErrCodeNotAllowed, "not impersonating")
+	}
+	staff, err := um.GetById(staffID)
+	if err != nil || !staff.IsStaff || !staff.Enabled {
+		Logout(c)
+		return r, NewRpcErr(ErrCodeNotAllowed, "staff not allowed")
+	}
+	user := CurrentUser(c)
+	CurrentLogger(c).Warn("stop impersonate user", "staff_id", staff.ID, "user_id", user.ID)
+	um.audit(c, staff.ID, AuditImpersonateStop, auditID(user.ID), nil)
+	setSessionUser(c, staff, 0)
+	return UserInfoResult{
+		UserName:  staff.UserName,
+		Email:     staff.Email,
+		LastLogin: staff.LastLogin,
+	}, nil
+}
+
+func (um *UserManager) handleAdminUserImport(c *gin.Context, form *AdminUserImportForm) (r AdminUserImportResult, err error) {
+	reader := csv.NewReader(strings.NewReader(form.CSV))
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return r, NewRpcErr(ErrCodeInvalidParams, "bad csv header")
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["email"]; !ok {
+		return r, NewRpcErr(ErrCodeInvalidParams, "email column is required")
+	}
+
+	r.Errors = []AdminImportError{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var line int
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			line = parseErr.StartLine
+			// the wrong number of fields is the only error that the reader can go on after
+			if !errors.Is(parseErr.Err, csv.ErrFieldCount) {
+				r.Errors = append(r.Errors, AdminImportError{Line: line, Error: err.Error()})
+				break
+			}
+		} else if err != nil {
+			return r, err
+		} else {
+			line, _ = reader.FieldPos(0)
+		}
+		if err == nil {
+			err = um.importUser(func(name string) string {
+				if idx, ok := columns[strings.ToLower(name)]; ok && idx < len(record) {
+					return strings.TrimSpace(record[idx])
+				}
+				return ""
+			})
+		}
+		if err != nil {
+			r.Errors = append(r.Errors, AdminImportError{Line: line, Error: err.Error()})
+			continue
+		}
+		r.Created++
+	}
+	return r, nil
+}
+
+func (um *UserManager) importUser(get func(name string) string) error {
+	email := strings.ToLower(get("email"))
+	if len(email) <= 0 {
+		return errors.New("email is required")
+	}
+	username := get("username")
+	if len(username) <= 0 {
+		username = email
+	}
+	if um.IsExists(username) {
+		return errors.New("username is exists")
+	}
+	if um.IsExistsByEmail(email) {
+		return errors.New("email is exists")
+	}
+	phone := get("phone")
+	if len(phone) > 0 {
+		var err error
+		if phone, err = NormalizePhone(phone, um.PhoneCountryCode); err != nil {
+			return err
+		}
+	}
+	password := get("password")
+	if len(password) <= 0 {
+		password = RandText(16)
+	} else if err := um.CheckNewPassword(&GinExtUser{UserName: username, Email: email}, password); err != nil {
+		return err
+	}
+
+	user, err := um.Create(username, email, password)
+	if err != nil {
+		return err
+	}
+	vals := map[string]interface{}{}
+	if len(phone) > 0 {
+		vals["Phone"] = phone
+	}
+	if v := get("displayName"); len(v) > 0 {
+		vals["DisplayName"] = v
+	}
+	if v := get("firstName"); len(v) > 0 {
+		vals["FirstName"] = v
+	}
+	if v := get("lastName"); len(v) > 0 {
+		vals["LastName"] = v
+	}
+	if v, _ := strconv.ParseBool(get("isStaff")); v {
+		vals["IsStaff"] = true
+	}
+	if len(vals) > 0 {
+		return um.db.Model(user).Updates(vals).Error
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package ginext
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminUsers(t *testing.T) {
+	um, r := NewTestUserManager()
+	um.PhoneCountryCode = "86"
+	um.RegisterHandler("/auth", r)
+	um.RegisterAdminHandler("/admin/users", r)
+	client := NewTestHTTPClient(r)
+	addUser(t, client, r, "alice", "alice@example.org", "hello123")
+	addUser(t, client, r, "bob", "bob@example.org", "hello123")
+	alice, _ := um.Get("alice")
+	um.SetIsStaff(alice, true)
+	bob, _ := um.Get("bob")
+
+	var info UserInfoResult
+	{
+		err := client.Call("/auth/login", LoginForm{UserName: "bob", Password: "hello123"}, &info)
+		assert.Nil(t, err)
+		w := client.Post("/admin/users/list", map[string]interface{}{})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	}
+
+	staff := NewTestHTTPClient(r)
+	err := staff.Call("/auth/login", LoginForm{UserName: "alice", Password: "hello123"}, &info)
+	assert.Nil(t, err)
+	{
+		var result AdminUserListResult
+		err = staff.Call("/admin/users/list", map[string]interface{}{"isStaff": false}, &result)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, result.TotalCount)
+		assert.Equal(t, "bob", result.Items[0].UserName)
+
+		err = staff.Call("/admin/users/list", map[string]interface{}{"keyword": "example.org", "limit": 1}, &result)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, result.TotalCount)
+		assert.Equal(t, 1, len(result.Items))
+
+		err = staff.Call("/admin/users/list", map[string]interface{}{"createdAfter": time.Now().Add(time.Hour)}, &result)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, result.TotalCount)
+		assert.NotContains(t, staff.Post("/admin/users/list", map[string]interface{}{}).Body.String(), "md5$")
+	}
+	{
+		var result AdminUserResult
+		err = staff.Call("/admin/users/edit", map[string]interface{}{"id": bob.ID, "email": "alice@example.org"}, &result)
+		assert.NotNil(t, err)
+		err = staff.Call("/admin/users/edit", map[string]interface{}{"id": bob.ID, "phone": "138 0013 8000", "displayName": "Bob", "actived": true}, &result)
+		assert.Nil(t, err)
+		assert.Equal(t, "+8613800138000", result.Phone)
+		assert.Equal(t, "Bob", result.DisplayName)
+		assert.True(t, result.Actived)
+		assert.Equal(t, "bob@example.org", result.Email)
+	}
+	{
+		// force logout
+		token, _ := um.MakeToken(bob)
+		ok := false
+		err = staff.Call("/admin/users/logout", AdminUserForm{ID: bob.ID}, &ok)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		err = client.Call("/auth/profile", nil, nil)
+		assert.NotNil(t, err)
+		_, err = um.GetUserByToken(token.Token)
+		assert.NotNil(t, err)
+
+		err = staff.Call("/admin/users/password/reset", AdminPasswordResetForm{ID: bob.ID, Password: "world789"}, &ok)
+		assert.Nil(t, err)
+		err = client.Call("/auth/login", LoginForm{UserName: "bob", Password: "world789"}, &info)
+		assert.Nil(t, err)
+		err = client.Call("/auth/profile", nil, nil)
+		assert.Nil(t, err)
+	}
+	{
+		var logs []*GinExtUser
+		sid := Sig().Connect(SigUserImpersonate, func(sender interface{}, params ...interface{}) {
+			logs = append(logs, sender.(*GinExtUser), params[0].(*GinExtUser))
+		})
+		defer Sig().Disconnect(SigUserImpersonate, sid)
+
+		err = staff.Call("/admin/users/impersonate", AdminUserForm{ID: alice.ID}, &info)
+		assert.NotNil(t, err)
+
+		err = staff.Call("/admin/users/impersonate", AdminUserForm{ID: bob.ID}, &info)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(logs))
+		assert.Equal(t, alice.ID, logs[0].ID)
+		var audit GinAuditLog
+		assert.Nil(t, um.db.Where("action", AuditImpersonate).Take(&audit).Error)
+		assert.Equal(t, alice.ID, audit.ActorID)
+		assert.Equal(t, auditID(bob.ID), audit.TargetID)
+		var profile UserProfileResult
+		err = staff.Call("/auth/profile", nil, &profile)
+		assert.Nil(t, err)
+		assert.Equal(t, "bob", profile.UserName)
+		err = staff.Call("/admin/users/list", nil, nil)
+		assert.NotNil(t, err)
+
+		err = staff.Call("/admin/users/impersonate/stop", nil, &info)
+		assert.Nil(t, err)
+		assert.Equal(t, "alice", info.UserName)
+		err = staff.Call("/admin/users/impersonate/stop", nil, &info)
+		assert.NotNil(t, err)
+	}
+	{
+		csv := "email,username,password,isStaff,phone\n" +
+			"carol@example.org,carol,hello123,false,+86 139 0000 0000\n" +
+			"dave@example.org,,,true,\n" +
+			"bob@example.org,bob2,,,\n" +
+			",nobody,,,\n"
+		var result AdminUserImportResult
+		err = staff.Call("/admin/users/import", AdminUserImportForm{CSV: csv}, &result)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, result.Created)
+		assert.Equal(t, []AdminImportError{{Line: 4, Error: "email is exists"}, {Line: 5, Error: "email is required"}}, result.Errors)
+
+		_, err = um.Auth("carol", "hello123")
+		assert.Nil(t, err)
+		dave, err := um.Get("dave@example.org")
+		assert.Nil(t, err)
+		assert.True(t, dave.IsStaff)
+
+		// the unterminated quote stops the import
+		csv = "email,username\n" +
+			"erin@example.org,erin,extra\n" +
+			"frank@example.org,\"frank\n" +
+			"grace@example.org,grace\n"
+		err = staff.Call("/admin/users/import", AdminUserImportForm{CSV: csv}, &result)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, result.Created)
+		assert.Equal(t, 2, len(result.Errors))
+		assert.Equal(t, 2, result.Errors[0].Line)
+		assert.Equal(t, 3, result.Errors[1].Line)
+
+		// the password of other staff can not be reset
+		ok := false
+		err = staff.Call("/admin/users/password/reset", AdminPasswordResetForm{ID: dave.ID, Password: "world789"}, &ok)
+		assert.NotNil(t, err)
+		var count int64
+		um.db.Model(&GinAuditLog{}).Where("action", AuditPasswordReset).Where("target_id", auditID(dave.ID)).Count(&count)
+		assert.Equal(t, int64(0), count)
+	}
+	{
+		// the disabled user is logged out
+		token, _ := um.MakeToken(bob)
+		var result AdminUserResult
+		err = staff.Call("/admin/users/edit", map[string]interface{}{"id": bob.ID, "enabled": false}, &result)
+		assert.Nil(t, err)
+		assert.False(t, result.Enabled)
+		_, err = um.GetUserByToken(token.Token)
+		assert.NotNil(t, err)
+	}
+}
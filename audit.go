@@ -0,0 +1,280 @@
+package ginext
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+/*
+	/admin/audit/list
+*/
+
+const (
+	AuditLogin           = "user.login"
+	AuditLoginFailed     = "user.login.failed"
+	AuditLogout          = "user.logout"
+	AuditPasswordChange  = "user.password.change"
+	AuditPasswordReset   = "user.password.reset"
+	AuditBindEmail       = "user.email.bind"
+	AuditTokenIssue      = "user.token.issue"
+	AuditTokenRevoke     = "user.token.revoke"
+	AuditForceLogout     = "user.logout.force"
+	AuditImpersonate     = "user.impersonate"
+	AuditImpersonateStop = "user.impersonate.stop"
+	AuditUserEdit        = "user.edit"
+	AuditObjectCreate    = "object.create"
+	AuditObjectEdit      = "object.edit"
+	AuditObjectDelete    = "object.delete"
+)
+
+const AuditTargetUser = "user"
+
+const AuditPruneTaskType = "ginext.audit.prune"
+
+const defaultAuditRetention = 180 * 24 * time.Hour
+const defaultAuditPruneInterval = 24 * time.Hour
+
+var ErrAuditLogReadonly = errors.New("audit log is append-only")
+
+// Auditable the models written by NewObject, EditObject and DeleteObject are audited,
+// the target type of log is AuditTarget
+type Auditable interface {
+	AuditTarget() string
+}
+
+// AuditChange the changed field of object
+type AuditChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+type AuditLogListForm struct {
+	PaginationForm
+	ActorID    *uint      `json:"actorId"`
+	Action     *string    `json:"action"`
+	TargetType *string    `json:"targetType"`
+	TargetID   *string    `json:"targetId"`
+	Since      *time.Time `json:"since"`
+	Until      *time.Time `json:"until"`
+}
+
+type AuditLogListResult struct {
+	PaginationResult
+	Items []GinAuditLog `json:"items"`
+}
+
+const docAuditLogList = `List the audit logs, the latest first, staff only`
+
+// BeforeUpdate the audit log is not changeable
+func (GinAuditLog) BeforeUpdate(tx *gorm.DB) error {
+	return ErrAuditLogReadonly
+}
+
+// BeforeDelete the audit log is only deleted by PruneAuditLogs
+func (GinAuditLog) BeforeDelete(tx *gorm.DB) error {
+	return ErrAuditLogReadonly
+}
+
+// auditActor the current user and the impersonator of request
+func auditActor(c *gin.Context) (actorID, impersonatorID uint) {
+	if c == nil {
+		return 0, 0
+	}
+	_, hasSession := c.Get(sessions.DefaultKey)
+	if obj, ok := c.Get(UserIdField); ok && obj != nil {
+		actorID = obj.(*GinExtUser).ID
+	} else if _, ok := c.Get(UserMangerField); ok && hasSession {
+		if user := CurrentUser(c); user != nil {
+			actorID = user.ID
+		}
+	}
+	if hasSession {
+		impersonatorID = CurrentImpersonator(c)
+	}
+	return actorID, impersonatorID
+}
+
+// WriteAuditLog append the log with the diff encoded as json,
+// the actor, ip and user agent are filled by the request when empty
+func WriteAuditLog(c *gin.Context, db *gorm.DB, obj *GinAuditLog, diff interface{}) error {
+	actorID, impersonatorID := auditActor(c)
+	if obj.ActorID <= 0 {
+		obj.ActorID = actorID
+	}
+	if obj.ImpersonatorID <= 0 {
+		obj.ImpersonatorID = impersonatorID
+	}
+	if c != nil && c.Request != nil {
+		if len(obj.IP) <= 0 {
+			obj.IP = c.ClientIP()
+		}
+		if len(obj.UserAgent) <= 0 {
+			obj.UserAgent = c.Request.UserAgent()
+		}
+	}
+	if len(obj.UserAgent) > 200 {
+		obj.UserAgent = obj.UserAgent[:200]
+	}
+	if diff != nil {
+		data, err := json.Marshal(diff)
+		if err != nil {
+			return err
+		}
+		obj.Diff = string(data)
+	}
+	obj.ID = 0
+	obj.CreatedAt = time.Now()
+	return requestDB(c, db).Create(obj).Error
+}
+
+// PruneAuditLogs delete the logs created before the time
+func PruneAuditLogs(db *gorm.DB, before time.Time) (int64, error) {
+	tx := db.Session(&gorm.Session{SkipHooks: true})
+	result := tx.Where("created_at < ?", before).Delete(&GinAuditLog{})
+	return result.RowsAffected, result.Error
+}
+
+func auditID(ID uint) string {
+	return strconv.FormatUint(uint64(ID), 10)
+}
+
+// audit the event of user, the error is only logged
+func (um *UserManager) audit(c *gin.Context, actorID uint, action, targetID string, diff interface{}) {
+	obj := GinAuditLog{
+		ActorID:    actorID,
+		Action:     action,
+		TargetType: AuditTargetUser,
+		TargetID:   targetID,
+	}
+	if err := WriteAuditLog(c, um.db, &obj, diff); err != nil {
+		um.ext.Logger.Error("write audit log fail", "action", action, "error", err)
+	}
+}
+
+// loginFailed count and audit the failed login, name is the username, email or phone tried
+func (um *UserManager) loginFailed(c *gin.Context, name, reason string) {
+	um.ext.Metrics.incLogin(false)
+	um.audit(c, 0, AuditLoginFailed, name, gin.H{"reason": reason})
+}
+
+// auditObject the audit of crud, only the Auditable models are audited
+func auditObject(c *gin.Context, db *gorm.DB, modPtr interface{}, action string, ID uint, diff interface{}) {
+	a, ok := modPtr.(Auditable)
+	if !ok {
+		return
+	}
+	obj := GinAuditLog{
+		Action:     action,
+		TargetType: a.AuditTarget(),
+		TargetID:   auditID(ID),
+	}
+	if err := WriteAuditLog(c, db, &obj, diff); err != nil {
+		CurrentLogger(c).Error("write audit log fail", "action", action, "error", err)
+	}
+}
+
+// auditChanges the old and new values of vals, the keys are the field or column names
+func auditChanges(db *gorm.DB, modPtr interface{}, vals map[string]interface{}) map[string]AuditChange {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(modPtr); err != nil {
+		return nil
+	}
+	rv := reflect.ValueOf(modPtr)
+	changes := map[string]AuditChange{}
+	for k, v := range vals {
+		var old interface{}
+		if field := stmt.Schema.LookUpField(k); field != nil {
+			old, _ = field.ValueOf(rv)
+		}
+		if reflect.DeepEqual(old, v) {
+			continue
+		}
+		changes[k] = AuditChange{Old: old, New: v}
+	}
+	return changes
+}
+
+// RegisterAuditHandler the query rpc of audit logs
+func (um *UserManager) RegisterAuditHandler(prefix string, r *gin.Engine) {
+	api := um.ext.Registry.Router(r)
+	RpcDefine(api, &RpcContext{
+		StaffRequired: true,
+		OnlyPost:      true,
+		Form:          AuditLogListForm{},
+		Result:        AuditLogListResult{},
+		RelativePath:  prefix + "/list",
+		Handler:       um.handleAuditLogList,
+		Doc:           docAuditLogList,
+	})
+}
+
+func (um *UserManager) handleAuditLogList(c *gin.Context) {
+	form := c.MustGet(RpcFormField).(*AuditLogListForm)
+	tx := um.db.Model(&GinAuditLog{})
+	if form.ActorID != nil {
+		tx = tx.Where("actor_id", *form.ActorID)
+	}
+	if form.Action != nil {
+		tx = tx.Where("action", *form.Action)
+	}
+	if form.TargetType != nil {
+		tx = tx.Where("target_type", *form.TargetType)
+	}
+	if form.TargetID != nil {
+		tx = tx.Where("target_id", *form.TargetID)
+	}
+	if form.Since != nil {
+		tx = tx.Where("created_at >= ?", *form.Since)
+	}
+	if form.Until != nil {
+		tx = tx.Where("created_at < ?", *form.Until)
+	}
+	var r AuditLogListResult
+	ListObject(c, tx, &r, &form.PaginationForm, "id DESC", "action LIKE ? OR target_id LIKE ? OR ip LIKE ?")
+}
+
+// ScheduleAuditPrune queue the prune task when not queued, the task is queued again after AuditPruneInterval
+func (um *UserManager) ScheduleAuditPrune(ctx context.Context) error {
+	wm := um.WorkerManager
+	if wm == nil {
+		wm = DefaultWorkerManager()
+	}
+	if wm == nil {
+		return errors.New("audit prune without WorkerManager")
+	}
+	var count int64
+	wm.db.Model(&GinTask{}).Where("task_type", AuditPruneTaskType).Where("done", false).Count(&count)
+	if count > 0 {
+		return nil
+	}
+	return wm.AddContext(ctx, 0, AuditPruneTaskType, "", 0)
+}
+
+func (um *UserManager) handleAuditPruneTask(t *GinTask) (string, error) {
+	if um.AuditRetention <= 0 {
+		return `{"msg":"disabled"}`, nil
+	}
+	count, err := PruneAuditLogs(um.db.WithContext(t.Ctx()), time.Now().Add(-um.AuditRetention))
+	if err != nil {
+		return "", err
+	}
+
+	wm := um.WorkerManager
+	if wm == nil {
+		wm = DefaultWorkerManager()
+	}
+	if wm != nil && um.AuditPruneInterval > 0 {
+		if err := wm.AddContext(t.Ctx(), 0, AuditPruneTaskType, "", um.AuditPruneInterval); err != nil {
+			um.ext.Logger.Error("queue audit prune fail", "error", err)
+		}
+	}
+	return `{"deleted":` + strconv.FormatInt(count, 10) + `}`, nil
+}
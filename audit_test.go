@@ -0,0 +1,204 @@
+package ginext
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type auditObj struct {
+	ID    uint `gorm:"primarykey"`
+	Title string
+}
+
+func (auditObj) AuditTarget() string {
+	return "audit.obj"
+}
+
+func TestAuditAuthEvents(t *testing.T) {
+	um, r := NewTestUserManager()
+	um.RegisterHandler("/auth", r)
+	um.RegisterAuditHandler("/admin/audit", r)
+	client := NewTestHTTPClient(r)
+	addUser(t, client, r, "alice", "alice@example.org", "hello123")
+	addUser(t, client, r, "bob", "bob@example.org", "hello123")
+	alice, _ := um.Get("alice")
+	um.SetIsStaff(alice, true)
+	bob, _ := um.Get("bob")
+
+	var logout []*GinExtUser
+	sid := Sig().Connect(SigUserLogout, func(sender interface{}, params ...interface{}) {
+		logout = append(logout, sender.(*GinExtUser))
+	})
+	defer Sig().Disconnect(SigUserLogout, sid)
+	// the old listeners get SigUserLogin with the nil user on logout
+	var nilLogins int
+	lid := Sig().Connect(SigUserLogin, func(sender interface{}, params ...interface{}) {
+		if sender == nil {
+			nilLogins++
+		}
+	})
+	defer Sig().Disconnect(SigUserLogin, lid)
+
+	var info UserInfoResult
+	err := client.Call("/auth/login", LoginForm{UserName: "bob", Password: "bad"}, &info)
+	assert.NotNil(t, err)
+	err = client.Call("/auth/login", LoginForm{UserName: "bob", Password: "hello123"}, &info)
+	assert.Nil(t, err)
+	err = client.Call("/auth/password/change", PasswordChangeForm{OldPassword: "hello123", Password: "world789"}, nil)
+	assert.Nil(t, err)
+	var token TokenResult
+	err = client.Call("/auth/token", LoginForm{UserName: "bob", Password: "world789"}, &token)
+	assert.Nil(t, err)
+	err = client.Call("/auth/logout", nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(logout))
+	assert.Equal(t, bob.ID, logout[0].ID)
+	assert.Equal(t, 1, nilLogins)
+	assert.Nil(t, um.RevokeTokens(bob))
+
+	staff := NewTestHTTPClient(r)
+	err = staff.Call("/auth/login", LoginForm{UserName: "alice", Password: "hello123"}, &info)
+	assert.Nil(t, err)
+	{
+		err = client.Call("/admin/audit/list", nil, nil)
+		assert.NotNil(t, err)
+
+		var result AuditLogListResult
+		err = staff.Call("/admin/audit/list", map[string]interface{}{"targetId": auditID(bob.ID), "limit": 100}, &result)
+		assert.Nil(t, err)
+		actions := []string{}
+		for _, v := range result.Items {
+			actions = append(actions, v.Action)
+			assert.Equal(t, bob.ID, v.ActorID)
+		}
+		// the latest first
+		assert.Equal(t, []string{AuditTokenRevoke, AuditLogout, AuditLogin, AuditTokenIssue, AuditPasswordChange, AuditLogin}, actions)
+
+		err = staff.Call("/admin/audit/list", map[string]interface{}{"action": AuditLoginFailed}, &result)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, result.TotalCount)
+		assert.Equal(t, "bob", result.Items[0].TargetID)
+		assert.Equal(t, uint(0), result.Items[0].ActorID)
+		assert.Contains(t, result.Items[0].Diff, "reason")
+	}
+}
+
+func TestAuditTokenRequest(t *testing.T) {
+	um, r := NewTestUserManager()
+	um.RegisterHandler("/auth", r)
+	client := NewTestHTTPClient(r)
+	addUser(t, client, r, "bob", "bob@example.org", "hello123")
+	var token TokenResult
+	err := client.Call("/auth/token", LoginForm{UserName: "bob", Password: "hello123"}, &token)
+	assert.Nil(t, err)
+
+	var logins []*GinExtUser
+	sid := Sig().Connect(SigUserLogin, func(sender interface{}, params ...interface{}) {
+		logins = append(logins, sender.(*GinExtUser))
+	})
+	defer Sig().Disconnect(SigUserLogin, sid)
+
+	r.GET("/current", func(c *gin.Context) {
+		c.String(http.StatusOK, CurrentUser(c).UserName)
+	})
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest("GET", "/current", nil)
+		req.Header.Set("Authorization", "Bearer "+token.Token)
+		w := NewTestHTTPClient(r).SendReq("/current", req)
+		assert.Equal(t, "bob", w.Body.String())
+	}
+	assert.Equal(t, 0, len(logins))
+
+	var count int64
+	um.db.Model(&GinAuditLog{}).Where("action", AuditLogin).Count(&count)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestAuditAppendOnly(t *testing.T) {
+	um, _ := NewTestUserManager()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+	c.Request.Header.Set("User-Agent", "audit-test")
+	obj := GinAuditLog{Action: "test", TargetType: "test", TargetID: "1"}
+	assert.Nil(t, WriteAuditLog(c, um.db, &obj, gin.H{"key": "val"}))
+	assert.Equal(t, "192.0.2.1", obj.IP)
+	assert.Equal(t, "audit-test", obj.UserAgent)
+	assert.Equal(t, `{"key":"val"}`, obj.Diff)
+
+	err := um.db.Model(&obj).Update("Action", "changed").Error
+	assert.Equal(t, ErrAuditLogReadonly, err)
+	err = um.db.Delete(&obj).Error
+	assert.Equal(t, ErrAuditLogReadonly, err)
+
+	count, err := PruneAuditLogs(um.db, obj.CreatedAt)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), count)
+	count, err = PruneAuditLogs(um.db, time.Now().Add(time.Second))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestAuditObject(t *testing.T) {
+	um, _ := NewTestUserManager()
+	um.db.AutoMigrate(&auditObj{}, &mockObj{})
+
+	obj := auditObj{Title: "hello"}
+	NewObject(nil, um.db, &obj)
+	EditObject(nil, um.db, &auditObj{}, obj.ID, map[string]interface{}{"Title": "world"})
+	DeleteObject(nil, um.db, &auditObj{}, obj.ID, false)
+	NewObject(nil, um.db, &mockObj{Title: "not audited"})
+
+	var logs []GinAuditLog
+	um.db.Where("target_type", "audit.obj").Order("id").Find(&logs)
+	assert.Equal(t, 3, len(logs))
+	assert.Equal(t, AuditObjectCreate, logs[0].Action)
+	assert.Equal(t, auditID(obj.ID), logs[0].TargetID)
+
+	assert.Equal(t, AuditObjectEdit, logs[1].Action)
+	var changes map[string]AuditChange
+	assert.Nil(t, json.Unmarshal([]byte(logs[1].Diff), &changes))
+	assert.Equal(t, AuditChange{Old: "hello", New: "world"}, changes["Title"])
+
+	assert.Equal(t, AuditObjectDelete, logs[2].Action)
+	assert.Contains(t, logs[2].Diff, "world")
+
+	var count int64
+	um.db.Model(&GinAuditLog{}).Count(&count)
+	assert.Equal(t, int64(3), count)
+}
+
+func TestAuditPruneTask(t *testing.T) {
+	um, _ := NewTestUserManager()
+	wm := NewWorkerManager(um.ext)
+	wm.Init()
+	wm.db.Delete(&GinTask{}, "id > 0")
+	um.WorkerManager = wm
+	um.AuditRetention = time.Millisecond
+	w := NewWorker(wm.db, "audit")
+	um.RegisterWorker(w)
+
+	WriteAuditLog(nil, um.db, &GinAuditLog{Action: "test"}, nil)
+	time.Sleep(5 * time.Millisecond)
+
+	assert.Nil(t, um.ScheduleAuditPrune(context.Background()))
+	assert.Nil(t, um.ScheduleAuditPrune(context.Background()))
+	var tasks []GinTask
+	wm.db.Where("task_type", AuditPruneTaskType).Find(&tasks)
+	assert.Equal(t, 1, len(tasks))
+	assert.Nil(t, w.DoTask(&tasks[0]))
+
+	var count int64
+	um.db.Model(&GinAuditLog{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	// queued again
+	wm.db.Model(&GinTask{}).Where("task_type", AuditPruneTaskType).Where("done", false).Count(&count)
+	assert.Equal(t, int64(1), count)
+}
@@ -1,12 +1,13 @@
 package ginext
 
 import (
+	"math"
 	"net/http"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 )
 
@@ -17,17 +18,33 @@ import (
 	/auth/logout
 	/auth/password/lost
 	/auth/password/reset
+	/auth/password/renew
+	/auth/verifyphone
+	/auth/bindphone
+	/auth/login/code
+	/auth/login/link
+	/auth/login/link/verify
+	/auth/webauthn/register/begin
+	/auth/webauthn/register/finish
+	/auth/webauthn/login/begin
+	/auth/webauthn/login/finish
+	/auth/webauthn/credentials
+	/auth/webauthn/credentials/delete
+	/auth/account/deactivate
+	/auth/account/delete
+	/auth/account/restore
+	/auth/account/export
 */
 
 type RegisterUserForm struct {
 	Email       string `json:"email" binding:"required"`
 	Password    string `json:"password" binding:"required"`
-	UserName    string `json:"username"`
+	UserName    string `json:"username" binding:"omitempty,username"`
 	DisplayName string `json:"displayName"`
 	FirstName   string `json:"firstName"`
 	LastName    string `json:"lastName"`
 	Locale      string `json:"locale"`
-	Timezone    string `json:"timezone"`
+	Timezone    string `json:"timezone" binding:"omitempty,tzname"`
 	Code        string `json:"code"`
 	Key         string `json:"key"`
 	Source      string `json:"source"`
@@ -49,7 +66,16 @@ type PasswordLostForm struct {
 }
 
 type PasswordChangeForm struct {
-	Password string `json:"password" binding:"required"`
+	OldPassword string `json:"oldPassword" binding:"required"`
+	Password    string `json:"password" binding:"required"`
+}
+
+// PasswordRenewForm change the expired password with the old password
+type PasswordRenewForm struct {
+	UserName    string `json:"username"`
+	Email       string `json:"email"`
+	Password    string `json:"password" binding:"required"`
+	NewPassword string `json:"newPassword" binding:"required"`
 }
 
 type PasswordResetForm struct {
@@ -62,6 +88,19 @@ type PasswordResetForm struct {
 type BindEmailForm PasswordResetForm
 type VerifyEmailForm PasswordLostForm
 
+type VerifyPhoneForm struct {
+	Phone  string `json:"phone" binding:"required"`
+	Locale string `json:"locale"`
+}
+
+type BindPhoneForm struct {
+	Phone string `json:"phone" binding:"required"`
+	Key   string `json:"key" binding:"required"`
+	Code  string `json:"code" binding:"required"`
+}
+
+type LoginCodeForm BindPhoneForm
+
 type UserInfoResult struct {
 	UserName  string     `json:"username"`
 	Email     string     `json:"email"`
@@ -84,7 +123,7 @@ type UserProfileResult struct {
 	City     string `json:"city"`
 	Country  string `json:"country"`
 	Locale   string `json:"locale"`
-	Timezone string `json:"timezone"`
+	Timezone string `json:"timezone" binding:"omitempty,tzname"`
 }
 
 type TokenResult struct {
@@ -103,15 +142,14 @@ func (um *UserManager) loadUMWithGin() gin.HandlerFunc {
 				return
 			}
 			obj, err := um.GetUserByToken(vals[1])
-			if err == nil && obj != nil {
-				session := sessions.Default(c)
-				userId := session.Get(UserIdField)
-				if userId != obj.OwnerID {
-					um.TouchToken(obj.ID)
-					Login(c, &obj.Owner)
-					c.Set(TokenField, obj)
-				}
+			// the disabled user and the token issued before ForceLogout are rejected, same as CurrentUser
+			if err == nil && obj != nil && obj.Owner.Enabled && obj.SessionVersion == obj.Owner.SessionVersion {
+				um.TouchToken(obj.ID)
+				// the accesstoken request is not a login, and the cookie session is left untouched
+				c.Set(UserIdField, &obj.Owner)
+				c.Set(TokenField, obj)
 			} else {
+				um.ext.Metrics.incLogin(false)
 				RpcFail(c, http.StatusBadRequest, "invalid accesstoken")
 				return
 			}
@@ -130,17 +168,39 @@ const docLogout = `User logout`
 const docPasswordLost = `Find the password`
 const docPasswordChange = `Change the password`
 const docPasswordResetDone = `Reset the password`
+const docPasswordRenew = `Change the expired password and login`
 const docBindEmail = `Bind email with password`
 const docVerifyEmail = `Send verify code via email`
+const docVerifyPhone = `Send verify code via SMS`
+const docBindPhone = `Bind phone with verify code`
+const docLoginCode = `User login with the verify code of phone`
+const docLoginLink = `Send the magic link of login via email`
+const docLoginLinkVerify = `User login with the token of magic link, the session is set or the accesstoken is returned when type is token.
+The email is actived by the link, the login is not verified by WebAuthn, WebAuthnRequired still asks the second factor`
+
+const docWebAuthnRegisterBegin = `The options of navigator.credentials.create for registering a passkey`
+const docWebAuthnRegisterFinish = `Register the passkey with the credential of navigator.credentials.create`
+const docWebAuthnLoginBegin = `The options of navigator.credentials.get, for the current user as the second factor or for passwordless login`
+const docWebAuthnLoginFinish = `User login with the credential of navigator.credentials.get`
+const docWebAuthnCredentials = `List the passkeys of user`
+const docWebAuthnCredentialDelete = `Delete the passkey of user`
+
+const docAccountDeactivate = `Deactivate the account, restored by /account/restore`
+const docAccountDelete = `Delete the account after the grace period, restored by /account/restore before that`
+const docAccountRestore = `Restore the deactivated or deleting account, the user is login`
+const docAccountExport = `Export the personal data of user`
+
+const defaultVerifyPhoneText = `Your verification code is {{.Code}}, please do not share it with others.`
 
 func (um *UserManager) RegisterHandler(prefix string, r *gin.Engine) {
+	api := um.ext.Registry.Router(r)
 	// Require session
 	//
 	r.Use(um.loadUMWithGin())
 
-	AddDocAppLabel("User Auth")
+	api.Registry.AddAppLabel("User Auth")
 
-	RpcDefine(r, &RpcContext{
+	RpcDefine(api, &RpcContext{
 		Form:         RegisterUserForm{},
 		Result:       UserInfoResult{},
 		OnlyPost:     true,
@@ -148,7 +208,7 @@ func (um *UserManager) RegisterHandler(prefix string, r *gin.Engine) {
 		Handler:      um.handleRegister,
 		Doc:          docRegister,
 	})
-	RpcDefine(r, &RpcContext{
+	RpcDefine(api, &RpcContext{
 		Form:         LoginForm{},
 		Result:       UserInfoResult{},
 		OnlyPost:     true,
@@ -156,14 +216,14 @@ func (um *UserManager) RegisterHandler(prefix string, r *gin.Engine) {
 		Handler:      um.handleLogin,
 		Doc:          docLogin,
 	})
-	RpcDefine(r, &RpcContext{
+	RpcDefine(api, &RpcContext{
 		AuthRequired: true,
 		Result:       UserProfileResult{},
 		RelativePath: filepath.Join(prefix, "/profile"),
 		Handler:      um.handleProfile,
 		Doc:          docProfile,
 	})
-	RpcDefine(r, &RpcContext{
+	RpcDefine(api, &RpcContext{
 		Form:         LoginForm{},
 		Result:       TokenResult{},
 		OnlyPost:     true,
@@ -171,20 +231,20 @@ func (um *UserManager) RegisterHandler(prefix string, r *gin.Engine) {
 		Handler:      um.handleToken,
 		Doc:          docToken,
 	})
-	RpcDefine(r, &RpcContext{
+	RpcDefine(api, &RpcContext{
 		Form:         TokenRefreshForm{},
 		OnlyPost:     true,
 		RelativePath: filepath.Join(prefix, "/refresh"),
 		Handler:      um.handleRefresh,
 		Doc:          docRefresh,
 	})
-	RpcDefine(r, &RpcContext{
+	RpcDefine(api, &RpcContext{
 		RelativePath: filepath.Join(prefix, "/logout"),
 		Handler:      um.handleLogout,
 		Doc:          docLogout,
 	})
 
-	RpcDefine(r, &RpcContext{
+	RpcDefine(api, &RpcContext{
 		OnlyPost:     true,
 		AuthRequired: true,
 		Form:         VerifyEmailForm{},
@@ -194,7 +254,7 @@ func (um *UserManager) RegisterHandler(prefix string, r *gin.Engine) {
 		Doc:          docVerifyEmail,
 	})
 
-	RpcDefine(r, &RpcContext{
+	RpcDefine(api, &RpcContext{
 		OnlyPost:     true,
 		Form:         VerifyEmailForm{},
 		Result:       "",
@@ -203,7 +263,7 @@ func (um *UserManager) RegisterHandler(prefix string, r *gin.Engine) {
 		Doc:          docVerifyEmail,
 	})
 
-	RpcDefine(r, &RpcContext{
+	RpcDefine(api, &RpcContext{
 		OnlyPost:     true,
 		AuthRequired: true,
 		Form:         BindEmailForm{},
@@ -213,7 +273,7 @@ func (um *UserManager) RegisterHandler(prefix string, r *gin.Engine) {
 		Doc:          docBindEmail,
 	})
 
-	RpcDefine(r, &RpcContext{
+	RpcDefine(api, &RpcContext{
 		OnlyPost:     true,
 		AuthRequired: true,
 		Form:         PasswordChangeForm{},
@@ -222,7 +282,7 @@ func (um *UserManager) RegisterHandler(prefix string, r *gin.Engine) {
 		Handler:      um.handlePasswordChange,
 		Doc:          docPasswordChange,
 	})
-	RpcDefine(r, &RpcContext{
+	RpcDefine(api, &RpcContext{
 		OnlyPost:     true,
 		Form:         PasswordLostForm{},
 		Result:       "",
@@ -230,7 +290,7 @@ func (um *UserManager) RegisterHandler(prefix string, r *gin.Engine) {
 		Handler:      um.handlePasswordLost,
 		Doc:          docPasswordLost,
 	})
-	RpcDefine(r, &RpcContext{
+	RpcDefine(api, &RpcContext{
 		OnlyPost:     true,
 		Form:         PasswordResetForm{},
 		Result:       true,
@@ -238,6 +298,143 @@ func (um *UserManager) RegisterHandler(prefix string, r *gin.Engine) {
 		Handler:      um.handlePasswordReset,
 		Doc:          docPasswordResetDone,
 	})
+	RpcDefine(api, &RpcContext{
+		OnlyPost:     true,
+		Form:         PasswordRenewForm{},
+		Result:       UserInfoResult{},
+		RelativePath: filepath.Join(prefix, "/password/renew"),
+		Handler:      um.handlePasswordRenew,
+		Doc:          docPasswordRenew,
+	})
+
+	RpcDefine(api, &RpcContext{
+		OnlyPost:     true,
+		Form:         VerifyPhoneForm{},
+		Result:       "",
+		RelativePath: filepath.Join(prefix, "/verifyphone"),
+		Handler:      um.handleVerifyPhone,
+		Doc:          docVerifyPhone,
+	})
+	RpcDefine(api, &RpcContext{
+		OnlyPost:     true,
+		AuthRequired: true,
+		Form:         BindPhoneForm{},
+		Result:       true,
+		RelativePath: filepath.Join(prefix, "/bindphone"),
+		Handler:      um.handleBindPhone,
+		Doc:          docBindPhone,
+	})
+	RpcDefine(api, &RpcContext{
+		OnlyPost:     true,
+		Form:         LoginCodeForm{},
+		Result:       UserInfoResult{},
+		RelativePath: filepath.Join(prefix, "/login/code"),
+		Handler:      um.handleLoginCode,
+		Doc:          docLoginCode,
+	})
+	RpcDefine(api, &RpcContext{
+		OnlyPost:     true,
+		Form:         MagicLinkForm{},
+		Result:       true,
+		RelativePath: filepath.Join(prefix, "/login/link"),
+		Handler:      um.handleLoginLink,
+		Doc:          docLoginLink,
+	})
+	// the link is opened from the mail without the csrf token, it is protected by the signed single-use token
+	RpcDefine(api, &RpcContext{
+		Methods:      []string{http.MethodPost, http.MethodGet},
+		Form:         MagicLinkVerifyForm{},
+		Result:       UserInfoResult{},
+		RelativePath: filepath.Join(prefix, "/login/link/verify"),
+		Handler:      um.handleLoginLinkVerify,
+		Doc:          docLoginLinkVerify,
+	})
+
+	RpcDefine(api, &RpcContext{
+		OnlyPost:     true,
+		AuthRequired: true,
+		Result:       WebAuthnCreationOptions{},
+		RelativePath: filepath.Join(prefix, "/webauthn/register/begin"),
+		Handler:      um.handleWebAuthnRegisterBegin,
+		Doc:          docWebAuthnRegisterBegin,
+	})
+	RpcDefine(api, &RpcContext{
+		OnlyPost:     true,
+		AuthRequired: true,
+		Form:         WebAuthnCredentialForm{},
+		Result:       WebAuthnCredentialResult{},
+		RelativePath: filepath.Join(prefix, "/webauthn/register/finish"),
+		Handler:      um.handleWebAuthnRegisterFinish,
+		Doc:          docWebAuthnRegisterFinish,
+	})
+	RpcDefine(api, &RpcContext{
+		OnlyPost:     true,
+		Form:         WebAuthnLoginForm{},
+		Result:       WebAuthnRequestOptions{},
+		RelativePath: filepath.Join(prefix, "/webauthn/login/begin"),
+		Handler:      um.handleWebAuthnLoginBegin,
+		Doc:          docWebAuthnLoginBegin,
+	})
+	RpcDefine(api, &RpcContext{
+		OnlyPost:     true,
+		Form:         WebAuthnCredentialForm{},
+		Result:       UserInfoResult{},
+		RelativePath: filepath.Join(prefix, "/webauthn/login/finish"),
+		Handler:      um.handleWebAuthnLoginFinish,
+		Doc:          docWebAuthnLoginFinish,
+	})
+	RpcDefine(api, &RpcContext{
+		AuthRequired: true,
+		Result:       []WebAuthnCredentialResult{},
+		RelativePath: filepath.Join(prefix, "/webauthn/credentials"),
+		Handler:      um.handleWebAuthnCredentials,
+		Doc:          docWebAuthnCredentials,
+	})
+	RpcDefine(api, &RpcContext{
+		OnlyPost:     true,
+		AuthRequired: true,
+		Form:         WebAuthnCredentialDeleteForm{},
+		Result:       true,
+		RelativePath: filepath.Join(prefix, "/webauthn/credentials/delete"),
+		Handler:      um.handleWebAuthnCredentialDelete,
+		Doc:          docWebAuthnCredentialDelete,
+	})
+
+	RpcDefine(api, &RpcContext{
+		OnlyPost:     true,
+		AuthRequired: true,
+		Form:         AccountPasswordForm{},
+		Result:       true,
+		RelativePath: filepath.Join(prefix, "/account/deactivate"),
+		Handler:      um.handleAccountDeactivate,
+		Doc:          docAccountDeactivate,
+	})
+	RpcDefine(api, &RpcContext{
+		OnlyPost:     true,
+		AuthRequired: true,
+		Form:         AccountPasswordForm{},
+		Result:       AccountDeleteResult{},
+		RelativePath: filepath.Join(prefix, "/account/delete"),
+		Handler:      um.handleAccountDelete,
+		Doc:          docAccountDelete,
+	})
+	RpcDefine(api, &RpcContext{
+		OnlyPost:     true,
+		Form:         LoginForm{},
+		Result:       UserInfoResult{},
+		RelativePath: filepath.Join(prefix, "/account/restore"),
+		Handler:      um.handleAccountRestore,
+		Doc:          docAccountRestore,
+	})
+	// the archive is downloaded by the browser without the csrf token, GET is read-only
+	RpcDefine(api, &RpcContext{
+		Methods:      []string{http.MethodPost, http.MethodGet},
+		AuthRequired: true,
+		Result:       map[string]interface{}{},
+		RelativePath: filepath.Join(prefix, "/account/export"),
+		Handler:      um.handleAccountExport,
+		Doc:          docAccountExport,
+	})
 }
 
 //handleRegister User Register
@@ -248,19 +445,24 @@ func (um *UserManager) handleRegister(c *gin.Context) {
 	}
 
 	if um.IsExists(form.UserName) {
-		RpcFail(c, errUsernameExists, "username is exists")
+		RpcAbort(c, ErrUsernameExists)
 		return
 	}
 
 	if um.IsExistsByEmail(form.Email) {
-		RpcFail(c, errEmailExists, "email is exists")
+		RpcAbort(c, ErrEmailExists)
+		return
+	}
+
+	if err := um.CheckNewPassword(&GinExtUser{UserName: form.UserName, Email: form.Email}, form.Password); err != nil {
+		abortWeakPassword(c, err)
 		return
 	}
 
 	vals := map[string]interface{}{}
 	if um.hasVerifyCode(form.Email) {
 		if !um.verifyCode(form.Key, form.Email, form.Code) {
-			RpcFail(c, errBadVerifyCode, "bad verifycode")
+			RpcAbort(c, ErrBadVerifyCode)
 			return
 		}
 		vals["Actived"] = true
@@ -268,7 +470,7 @@ func (um *UserManager) handleRegister(c *gin.Context) {
 
 	user, err := um.Create(form.UserName, form.Email, form.Password)
 	if err != nil {
-		RpcFail(c, errServerError, "create user fail")
+		RpcFail(c, ErrCodeServerError, "create user fail")
 		return
 	}
 	if len(form.DisplayName) > 0 {
@@ -311,7 +513,7 @@ func (um *UserManager) handleRegister(c *gin.Context) {
 func (um *UserManager) handleLogin(c *gin.Context) {
 	form := c.MustGet(RpcFormField).(*LoginForm)
 	if len(form.Email) <= 0 && len(form.UserName) <= 0 {
-		RpcFail(c, errInvalidParams, "bad username or password")
+		RpcFail(c, ErrCodeInvalidParams, "bad username or password")
 		return
 	}
 	key := form.UserName
@@ -320,12 +522,18 @@ func (um *UserManager) handleLogin(c *gin.Context) {
 	}
 	user, err := um.Auth(key, form.Password)
 	if err != nil {
-		RpcFail(c, errInvalidParams, err.Error())
+		um.loginFailed(c, key, err.Error())
+		RpcFail(c, ErrCodeInvalidParams, err.Error())
+		return
+	}
+	if um.IsPasswordExpired(user) {
+		RpcAbort(c, ErrPasswordExpired)
 		return
 	}
 
 	// Login ..
 	//
+	um.ext.Metrics.incLogin(true)
 	Login(c, user)
 	RpcOk(c, UserInfoResult{
 		UserName:  user.UserName,
@@ -363,7 +571,7 @@ func (um *UserManager) handleProfile(c *gin.Context) {
 func (um *UserManager) handleToken(c *gin.Context) {
 	form := c.MustGet(RpcFormField).(*LoginForm)
 	if len(form.Email) <= 0 && len(form.UserName) <= 0 {
-		RpcFail(c, errInvalidParams, "bad username or password")
+		RpcFail(c, ErrCodeInvalidParams, "bad username or password")
 		return
 	}
 	key := form.UserName
@@ -372,18 +580,24 @@ func (um *UserManager) handleToken(c *gin.Context) {
 	}
 	user, err := um.Auth(key, form.Password)
 	if err != nil {
-		RpcFail(c, errInvalidParams, err.Error())
+		um.loginFailed(c, key, err.Error())
+		RpcFail(c, ErrCodeInvalidParams, err.Error())
+		return
+	}
+	if um.IsPasswordExpired(user) {
+		RpcAbort(c, ErrPasswordExpired)
 		return
 	}
 
 	token, err := um.MakeToken(user)
 	if err != nil {
-		RpcFail(c, errInvalidParams, "token build fail")
+		RpcFail(c, ErrCodeInvalidParams, "token build fail")
 		return
 	}
 
 	// Login ..
 	//
+	um.ext.Metrics.incLogin(true)
 	Login(c, user)
 	RpcOk(c, TokenResult{
 		Token:     token.Token,
@@ -401,13 +615,13 @@ func (um *UserManager) handleRefresh(c *gin.Context) {
 	form := c.MustGet(RpcFormField).(*TokenRefreshForm)
 	userToken, err := um.GetUserByToken(form.Token)
 	if err != nil {
-		RpcFail(c, errInvalidParams, err.Error())
+		RpcFail(c, ErrCodeInvalidParams, err.Error())
 		return
 	}
 
 	expire, err := um.TouchToken(userToken.ID)
 	if err != nil {
-		RpcFail(c, errInvalidParams, err.Error())
+		RpcFail(c, ErrCodeInvalidParams, err.Error())
 		return
 	}
 
@@ -417,17 +631,56 @@ func (um *UserManager) handleRefresh(c *gin.Context) {
 	})
 }
 
+// abortSendRate abort with 429 when the codes sent to the target, or by the client ip, exceed the rate limits
+func (um *UserManager) abortSendRate(c *gin.Context, target string, interval time.Duration, maxPerDay, maxPerIP int) bool {
+	wait := um.checkSendRate("source", target, interval, maxPerDay)
+	if ip := c.ClientIP(); maxPerIP > 0 && len(ip) > 0 {
+		// one client sends to the different targets
+		if v := um.checkSendRate("ip", ip, 0, maxPerIP); v > wait {
+			wait = v
+		}
+	}
+	if wait <= 0 {
+		return false
+	}
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	RpcAbort(c, NewRpcErr(ErrCodeTooManyRequests, "too many requests").WithDetails(gin.H{"retryAfter": retryAfter}).WithStatus(http.StatusTooManyRequests))
+	return true
+}
+
+// sendEmailCode create the verify code of email with the rate limits, aborted when fail
+func (um *UserManager) sendEmailCode(c *gin.Context, user *GinExtUser, email string) (string, string, bool) {
+	if um.abortSendRate(c, email, um.EmailSendInterval, um.EmailMaxSendPerDay, um.EmailMaxSendPerIP) {
+		return "", "", false
+	}
+	key, code := um.createVerifyCode(user, &GinVerifyCode{
+		Source:    email,
+		IP:        c.ClientIP(),
+		Code:      RandNumberText(um.VerifyCodeLength),
+		ExpiredAt: time.Now().Add(um.VerifyCodeExpired),
+	})
+	if len(key) <= 0 {
+		RpcFail(c, ErrCodeServerError, "create verify code fail")
+		return "", "", false
+	}
+	return key, code, true
+}
+
 func (um *UserManager) handleVerifyEmailNewUser(c *gin.Context) {
 	form := c.MustGet(RpcFormField).(*VerifyEmailForm)
 	_, err := um.GetByEmail(form.Email)
 	if err == nil {
-		RpcFail(c, errEmailExists, "Email has already exists")
+		RpcAbort(c, ErrEmailExists)
 		return
 	}
 
-	key, code := um.genVerifyCode(nil, form.Email)
+	key, code, ok := um.sendEmailCode(c, nil, form.Email)
+	if !ok {
+		return
+	}
 
-	Sig().Emit(SigUserVerifyEmail, nil, form.Email, code, form.Locale)
+	Sig().Emit(SigUserVerifyEmail, nil, form.Email, code, CurrentLocale(c), c)
 	RpcOk(c, key)
 }
 
@@ -441,29 +694,50 @@ func (um *UserManager) handleVerifyEmail(c *gin.Context) {
 		return
 	}
 
-	key, code := um.genVerifyCode(user, form.Email)
+	key, code, ok := um.sendEmailCode(c, user, form.Email)
+	if !ok {
+		return
+	}
 
-	Sig().Emit(SigUserVerifyEmail, user, form.Email, code, form.Locale)
+	Sig().Emit(SigUserVerifyEmail, user, form.Email, code, CurrentLocale(c), c)
 	RpcOk(c, key)
 }
 
 func (um *UserManager) handleBindEmail(c *gin.Context) {
 	user := CurrentUser(c)
 	form := c.MustGet(RpcFormField).(*BindEmailForm)
+	if err := um.CheckNewPassword(&GinExtUser{ID: user.ID, UserName: user.UserName, Email: form.Email, Password: user.Password}, form.Password); err != nil {
+		abortWeakPassword(c, err)
+		return
+	}
 	if !um.verifyCode(form.Key, form.Email, form.Code) {
 		RpcOk(c, false)
 		return
 	}
+	oldEmail := user.Email
 	um.SetPassword(user, form.Password)
 	um.SetEmail(user, form.Email)
 	um.SetActived(user, true)
+	um.audit(c, user.ID, AuditBindEmail, auditID(user.ID), gin.H{"email": AuditChange{Old: oldEmail, New: form.Email}})
 	RpcOk(c, true)
 }
 
 func (um *UserManager) handlePasswordChange(c *gin.Context) {
 	user := CurrentUser(c)
 	form := c.MustGet(RpcFormField).(*PasswordChangeForm)
-	um.SetPassword(user, form.Password)
+	if u, err := um.Auth(user.UserName, form.OldPassword); err != nil || u.ID != user.ID {
+		RpcFail(c, ErrCodeBadPassword, "bad password")
+		return
+	}
+	if err := um.CheckNewPassword(user, form.Password); err != nil {
+		abortWeakPassword(c, err)
+		return
+	}
+	if err := um.changePassword(c, user, form.Password); err != nil {
+		RpcFail(c, ErrCodeServerError, "change password fail")
+		return
+	}
+	um.audit(c, user.ID, AuditPasswordChange, auditID(user.ID), nil)
 	RpcOk(c, true)
 }
 
@@ -475,8 +749,11 @@ func (um *UserManager) handlePasswordLost(c *gin.Context) {
 		return
 	}
 
-	key, code := um.genVerifyCode(user, form.Email)
-	Sig().Emit(SigUserResetpassword, user, form.Email, code, form.Locale)
+	key, code, ok := um.sendEmailCode(c, user, form.Email)
+	if !ok {
+		return
+	}
+	Sig().Emit(SigUserResetpassword, user, form.Email, code, CurrentLocale(c), c)
 	RpcOk(c, key)
 }
 
@@ -487,10 +764,147 @@ func (um *UserManager) handlePasswordReset(c *gin.Context) {
 		RpcOk(c, false)
 		return
 	}
+	if err := um.CheckNewPassword(user, form.Password); err != nil {
+		abortWeakPassword(c, err)
+		return
+	}
 	if !um.verifyCode(form.Key, form.Email, form.Code) {
 		RpcOk(c, false)
 		return
 	}
-	um.SetPassword(user, form.Password)
+	if err := um.changePassword(c, user, form.Password); err != nil {
+		RpcFail(c, ErrCodeServerError, "reset password fail")
+		return
+	}
+	um.audit(c, user.ID, AuditPasswordReset, auditID(user.ID), nil)
+	RpcOk(c, true)
+}
+
+// handlePasswordRenew the expired password is not allowed to login, changed by the old password
+func (um *UserManager) handlePasswordRenew(c *gin.Context) {
+	form := c.MustGet(RpcFormField).(*PasswordRenewForm)
+	key := form.UserName
+	if len(key) <= 0 {
+		key = form.Email
+	}
+	user, err := um.Auth(key, form.Password)
+	if err != nil {
+		um.loginFailed(c, key, err.Error())
+		RpcAbort(c, ErrBadPassword)
+		return
+	}
+	if err := um.CheckNewPassword(user, form.NewPassword); err != nil {
+		abortWeakPassword(c, err)
+		return
+	}
+	if err := um.changePassword(c, user, form.NewPassword); err != nil {
+		RpcFail(c, ErrCodeServerError, "change password fail")
+		return
+	}
+	um.audit(c, user.ID, AuditPasswordChange, auditID(user.ID), nil)
+
+	um.ext.Metrics.incLogin(true)
+	Login(c, user)
+	RpcOk(c, UserInfoResult{
+		UserName:  user.UserName,
+		Email:     user.Email,
+		LastLogin: user.LastLogin,
+	})
+}
+
+func (um *UserManager) handleVerifyPhone(c *gin.Context) {
+	form := c.MustGet(RpcFormField).(*VerifyPhoneForm)
+	phone, err := NormalizePhone(form.Phone, um.PhoneCountryCode)
+	if err != nil {
+		RpcFail(c, ErrCodeInvalidParams, err.Error())
+		return
+	}
+
+	if um.abortSendRate(c, phone, um.PhoneSendInterval, um.PhoneMaxSendPerDay, um.PhoneMaxSendPerIP) {
+		return
+	}
+
+	user := CurrentUser(c)
+	key, code := um.createVerifyCode(user, &GinVerifyCode{
+		Source:    phone,
+		IP:        c.ClientIP(),
+		Code:      RandNumberText(um.VerifyCodeLength),
+		ExpiredAt: time.Now().Add(um.VerifyCodeExpired),
+	})
+	if len(key) <= 0 {
+		RpcFail(c, ErrCodeServerError, "create verify code fail")
+		return
+	}
+
+	if um.SMSSender != nil {
+		ctx := map[string]string{"Code": code, "SiteName": um.ext.GetValue(Key_SITE_NAME)}
+		text := FormatData(Translate(c, "sms.verify_phone", ""), ctx, defaultVerifyPhoneText)
+		if err := um.SMSSender.Send(c.Request.Context(), phone, text); err != nil {
+			CurrentLogger(c).Error("send sms fail", "phone", phone, "error", err)
+			RpcFail(c, ErrCodeServerError, "send sms fail")
+			return
+		}
+	}
+	Sig().Emit(SigUserVerifyPhone, user, phone, code, CurrentLocale(c), c)
+	RpcOk(c, key)
+}
+
+func (um *UserManager) handleBindPhone(c *gin.Context) {
+	user := CurrentUser(c)
+	form := c.MustGet(RpcFormField).(*BindPhoneForm)
+	phone, err := NormalizePhone(form.Phone, um.PhoneCountryCode)
+	if err != nil {
+		RpcFail(c, ErrCodeInvalidParams, err.Error())
+		return
+	}
+	if !um.verifyCode(form.Key, phone, form.Code) {
+		RpcAbort(c, ErrBadVerifyCode)
+		return
+	}
+	if other, err := um.GetByPhone(phone); err == nil && other.ID != user.ID {
+		RpcAbort(c, ErrPhoneExists)
+		return
+	}
+	um.SetPhone(user, phone)
 	RpcOk(c, true)
 }
+
+// handleLoginCode login without password, the phone must be bound
+func (um *UserManager) handleLoginCode(c *gin.Context) {
+	form := c.MustGet(RpcFormField).(*LoginCodeForm)
+	phone, err := NormalizePhone(form.Phone, um.PhoneCountryCode)
+	if err != nil {
+		RpcFail(c, ErrCodeInvalidParams, err.Error())
+		return
+	}
+	if !um.verifyCode(form.Key, phone, form.Code) {
+		um.loginFailed(c, phone, "bad verifycode")
+		RpcAbort(c, ErrBadVerifyCode)
+		return
+	}
+
+	user, err := um.GetByPhone(phone)
+	if err != nil {
+		um.loginFailed(c, phone, "phone is not registered")
+		RpcFail(c, ErrCodeInvalidParams, "phone is not registered")
+		return
+	}
+	if !user.Enabled {
+		um.loginFailed(c, phone, "user is not allow login")
+		RpcAbort(c, ErrUserNotAllowed)
+		return
+	}
+	if !um.CheckForceActived(user) {
+		um.loginFailed(c, phone, "user need actived first")
+		RpcAbort(c, ErrActiveRequired)
+		return
+	}
+
+	um.ext.Metrics.incLogin(true)
+	Login(c, user)
+	RpcOk(c, UserInfoResult{
+		UserName:  user.UserName,
+		Email:     user.Email,
+		LastLogin: user.LastLogin,
+	})
+}
@@ -2,6 +2,8 @@ package ginext
 
 import (
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -25,12 +27,12 @@ func TestUserRegister(t *testing.T) {
 	um, r := NewTestUserManager()
 	um.RegisterHandler("/auth", r)
 	client := NewTestHTTPClient(r)
-	addUser(t, client, r, "bob", "bob@example.org", "123456")
+	addUser(t, client, r, "bob", "bob@example.org", "hello123")
 	{
 		form := RegisterUserForm{
 			UserName: "bob",
 			Email:    "bob@example.org",
-			Password: "123456",
+			Password: "hello123",
 		}
 
 		var info UserInfoResult
@@ -42,11 +44,11 @@ func TestUserRegister(t *testing.T) {
 		form := RegisterUserForm{
 			UserName:    "alice",
 			Email:       "alice@example.org",
-			Password:    "123456",
+			Password:    "hello123",
 			DisplayName: "AliceD",
 			FirstName:   "AliceF",
 			LastName:    "AliceL",
-			Timezone:    "-480",
+			Timezone:    "Asia/Shanghai",
 			Locale:      "zh-CN",
 			Source:      "unittest",
 		}
@@ -56,7 +58,7 @@ func TestUserRegister(t *testing.T) {
 
 		loginForm := LoginForm{
 			UserName: "alice",
-			Password: "123456",
+			Password: "hello123",
 		}
 		var loginR UserInfoResult
 		err = client.Call("/auth/login", &loginForm, &loginR)
@@ -81,12 +83,12 @@ func TestUserLogin(t *testing.T) {
 	um.RegisterHandler("/auth", r)
 
 	client := NewTestHTTPClient(r)
-	addUser(t, client, r, "bob", "bob@example.org", "123456")
+	addUser(t, client, r, "bob", "bob@example.org", "hello123")
 
 	{
 		form := LoginForm{
 			UserName: "bob",
-			Password: "123456",
+			Password: "hello123",
 		}
 		var loginR UserInfoResult
 		err := client.Call("/auth/login", &form, &loginR)
@@ -110,12 +112,12 @@ func TestLoginSession(t *testing.T) {
 	um.RegisterHandler("/auth", r)
 
 	client := NewTestHTTPClient(r)
-	addUser(t, client, r, "bob", "bob@example.org", "123456")
+	addUser(t, client, r, "bob", "bob@example.org", "hello123")
 
 	{
 		form := LoginForm{
 			UserName: "bob",
-			Password: "123456",
+			Password: "hello123",
 		}
 		var loginR UserInfoResult
 		err := client.Call("/auth/login", &form, &loginR)
@@ -164,12 +166,12 @@ func TestLoginToken(t *testing.T) {
 	um.RegisterHandler("/auth", r)
 
 	client := NewTestHTTPClient(r)
-	addUser(t, client, r, "bob", "bob@example.org", "123456")
+	addUser(t, client, r, "bob", "bob@example.org", "hello123")
 	var token string
 	{
 		data := map[string]interface{}{
 			"username": "bob",
-			"password": "123456",
+			"password": "hello123",
 		}
 		w := client.Post("/auth/token", data)
 		resp := client.CheckResponse(t, w)
@@ -221,12 +223,12 @@ func TestActivedLogin(t *testing.T) {
 	um.RegisterHandler("/auth", r)
 
 	client := NewTestHTTPClient(r)
-	addUser(t, client, r, "bob", "bob@example.org", "123456")
+	addUser(t, client, r, "bob", "bob@example.org", "hello123")
 	um.ext.SetValue(key_ACTIVE_REQUIRED, "true")
 	{
 		loginForm := LoginForm{
 			UserName: "bob",
-			Password: "123456",
+			Password: "hello123",
 		}
 		var loginResult UserInfoResult
 		err := client.Call("/auth/login", loginForm, &loginResult)
@@ -236,7 +238,7 @@ func TestActivedLogin(t *testing.T) {
 	{
 		loginForm := LoginForm{
 			UserName: "bob",
-			Password: "123456",
+			Password: "hello123",
 		}
 		var tokenResult TokenResult
 		err := client.Call("/auth/token", loginForm, &tokenResult)
@@ -249,7 +251,7 @@ func TestVerifyRegisterEmail(t *testing.T) {
 	um.RegisterHandler("/auth", r)
 
 	client := NewTestHTTPClient(r)
-	addUser(t, client, r, "bob", "bademail@unittest", "123456")
+	addUser(t, client, r, "bob", "bademail@unittest", "hello123")
 	{
 		form := VerifyEmailForm{
 			Email: "alice@example.org",
@@ -261,7 +263,7 @@ func TestVerifyRegisterEmail(t *testing.T) {
 	{
 		form := RegisterUserForm{
 			Email:    "alice@example.org",
-			Password: "password",
+			Password: "hello123",
 		}
 		var info UserInfoResult
 		err := client.Call("/auth/register", &form, &info)
@@ -274,7 +276,7 @@ func TestVerifyBindEmail(t *testing.T) {
 	um.RegisterHandler("/auth", r)
 
 	client := NewTestHTTPClient(r)
-	addUser(t, client, r, "bob", "bademail@unittest", "123456")
+	addUser(t, client, r, "bob", "bademail@unittest", "hello123")
 	code := ""
 	Sig().Connect(SigUserVerifyEmail, func(sender interface{}, params ...interface{}) {
 		code = params[1].(string)
@@ -292,7 +294,7 @@ func TestVerifyBindEmail(t *testing.T) {
 	{
 		form := LoginForm{
 			UserName: "bob",
-			Password: "123456",
+			Password: "hello123",
 		}
 		var loginR UserInfoResult
 		err := client.Call("/auth/login", &form, &loginR)
@@ -309,7 +311,7 @@ func TestVerifyBindEmail(t *testing.T) {
 
 		bform := BindEmailForm{
 			Email:    "bob@example.org",
-			Password: "778899",
+			Password: "world789",
 			Key:      key,
 			Code:     code,
 		}
@@ -321,13 +323,13 @@ func TestVerifyBindEmail(t *testing.T) {
 	{
 		form := LoginForm{
 			UserName: "bob",
-			Password: "123456",
+			Password: "hello123",
 		}
 		var loginR UserInfoResult
 		err := client.Call("/auth/login", &form, &loginR)
 		assert.NotNil(t, err)
 
-		form.Password = "778899"
+		form.Password = "world789"
 		err = client.Call("/auth/login", &form, &loginR)
 		assert.Nil(t, err)
 	}
@@ -338,7 +340,7 @@ func TestResetpassword(t *testing.T) {
 	um.RegisterHandler("/auth", r)
 
 	client := NewTestHTTPClient(r)
-	addUser(t, client, r, "bob", "bob@example.org", "123456")
+	addUser(t, client, r, "bob", "bob@example.org", "hello123")
 	code := ""
 	Sig().Connect(SigUserResetpassword, func(sender interface{}, params ...interface{}) {
 		code = params[1].(string)
@@ -354,7 +356,7 @@ func TestResetpassword(t *testing.T) {
 
 		bform := PasswordResetForm{
 			Email:    "bob@example.org",
-			Password: "778899",
+			Password: "world789",
 			Key:      key,
 			Code:     code,
 		}
@@ -366,13 +368,13 @@ func TestResetpassword(t *testing.T) {
 	{
 		form := LoginForm{
 			UserName: "bob",
-			Password: "123456",
+			Password: "hello123",
 		}
 		var loginR UserInfoResult
 		err := client.Call("/auth/login", &form, &loginR)
 		assert.NotNil(t, err)
 
-		form.Password = "778899"
+		form.Password = "world789"
 		err = client.Call("/auth/login", &form, &loginR)
 		assert.Nil(t, err)
 	}
@@ -382,37 +384,225 @@ func TestChangepassword(t *testing.T) {
 	um, r := NewTestUserManager()
 	um.RegisterHandler("/auth", r)
 	client := NewTestHTTPClient(r)
-	addUser(t, client, r, "bob", "bob@example.org", "123456")
+	addUser(t, client, r, "bob", "bob@example.org", "hello123")
 
 	{
 		form := LoginForm{
 			UserName: "bob",
-			Password: "123456",
+			Password: "hello123",
 		}
 		var loginR UserInfoResult
 		err := client.Call("/auth/login", &form, &loginR)
 		assert.Nil(t, err)
 	}
+	other := NewTestHTTPClient(r)
+	var token TokenResult
+	err := other.Call("/auth/token", LoginForm{UserName: "bob", Password: "hello123"}, &token)
+	assert.Nil(t, err)
+
+	var changed []*GinExtUser
+	sid := Sig().Connect(SigUserPasswordChanged, func(sender interface{}, params ...interface{}) {
+		changed = append(changed, sender.(*GinExtUser))
+	})
+	defer Sig().Disconnect(SigUserPasswordChanged, sid)
 	{
 		form := PasswordChangeForm{
-			Password: "778899",
+			OldPassword: "bad",
+			Password:    "world789",
 		}
 		r := false
 		err := client.Call("/auth/password/change", &form, &r)
+		assert.NotNil(t, err)
+		assert.Equal(t, 0, len(changed))
+
+		form.OldPassword = "hello123"
+		err = client.Call("/auth/password/change", &form, &r)
 		assert.Nil(t, err)
 		assert.True(t, r)
+		assert.Equal(t, 1, len(changed))
+	}
+	{
+		// the current session is kept, the other sessions and tokens are revoked
+		err := client.Call("/auth/profile", nil, nil)
+		assert.Nil(t, err)
+		err = other.Call("/auth/profile", nil, nil)
+		assert.NotNil(t, err)
+		_, err = um.GetUserByToken(token.Token)
+		assert.NotNil(t, err)
 	}
 	{
 		form := LoginForm{
 			UserName: "bob",
-			Password: "123456",
+			Password: "hello123",
 		}
 		var loginR UserInfoResult
 		err := client.Call("/auth/login", &form, &loginR)
 		assert.NotNil(t, err)
 
-		form.Password = "778899"
+		form.Password = "world789"
 		err = client.Call("/auth/login", &form, &loginR)
 		assert.Nil(t, err)
 	}
 }
+
+func TestVerifyPhoneLogin(t *testing.T) {
+	um, r := NewTestUserManager()
+	um.db.Delete(&GinVerifyCode{}, "id > 0")
+	sender := &MemorySMSSender{}
+	um.SMSSender = sender
+	um.PhoneCountryCode = "86"
+	um.RegisterHandler("/auth", r)
+	client := NewTestHTTPClient(r)
+	addUser(t, client, r, "bob", "bob@example.org", "hello123")
+
+	sendCode := func() (key, code string) {
+		sender.Reset()
+		err := client.Call("/auth/verifyphone", VerifyPhoneForm{Phone: "138 0013 8000"}, &key)
+		assert.Nil(t, err)
+		assert.NotEmpty(t, key)
+		msgs := sender.Messages()
+		assert.Equal(t, 1, len(msgs))
+		assert.Equal(t, "+8613800138000", msgs[0].Phone)
+		var v GinVerifyCode
+		um.db.Where("key", key).Take(&v)
+		assert.Contains(t, msgs[0].Text, v.Code)
+		return key, v.Code
+	}
+
+	key, code := sendCode()
+	{
+		// the phone not bound
+		var info UserInfoResult
+		err := client.Call("/auth/login/code", LoginCodeForm{Phone: "+8613800138000", Key: key, Code: code}, &info)
+		assert.NotNil(t, err)
+		assert.Equal(t, "phone is not registered", err.Error())
+	}
+	{
+		// rate limit
+		w := client.Post("/auth/verifyphone", map[string]interface{}{"phone": "13800138000"})
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.NotEmpty(t, w.Header().Get("Retry-After"))
+		assert.Contains(t, w.Body.String(), `"key":"too_many_requests"`)
+	}
+
+	um.PhoneSendInterval = 0
+	{
+		var info UserInfoResult
+		err := client.Call("/auth/login", LoginForm{UserName: "bob", Password: "hello123"}, &info)
+		assert.Nil(t, err)
+
+		key, code = sendCode()
+		ok := false
+		err = client.Call("/auth/bindphone", BindPhoneForm{Phone: "13800138000", Key: key, Code: "bad"}, &ok)
+		assert.NotNil(t, err)
+		err = client.Call("/auth/bindphone", BindPhoneForm{Phone: "13800138000", Key: key, Code: code}, &ok)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		bob, _ := um.Get("bob")
+		assert.Equal(t, "+8613800138000", bob.Phone)
+
+		err = client.Call("/auth/logout", nil, nil)
+		assert.Nil(t, err)
+	}
+	{
+		key, code = sendCode()
+		var info UserInfoResult
+		err := client.Call("/auth/login/code", LoginCodeForm{Phone: "+86 13800138000", Key: key, Code: code}, &info)
+		assert.Nil(t, err)
+		assert.Equal(t, "bob", info.UserName)
+
+		var profile UserProfileResult
+		err = client.Call("/auth/profile", nil, &profile)
+		assert.Nil(t, err)
+		assert.Equal(t, "bob", profile.UserName)
+	}
+	{
+		um.PhoneMaxSendPerDay = 3
+		w := client.Post("/auth/verifyphone", map[string]interface{}{"phone": "13800138000"})
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	}
+}
+
+func TestVerifyPhoneIPRate(t *testing.T) {
+	um, r := NewTestUserManager()
+	um.SMSSender = &MemorySMSSender{}
+	um.PhoneCountryCode = "86"
+	um.PhoneMaxSendPerIP = 2
+	um.RegisterHandler("/auth", r)
+	client := NewTestHTTPClient(r)
+
+	send := func(phone, remoteAddr string) int {
+		req, _ := http.NewRequest("POST", "/auth/verifyphone", strings.NewReader(`{"phone":"`+phone+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = remoteAddr
+		return client.SendReq("/auth/verifyphone", req).Code
+	}
+	// the different phones from one ip
+	assert.Equal(t, http.StatusOK, send("13800138001", "10.0.0.1:1234"))
+	assert.Equal(t, http.StatusOK, send("13800138002", "10.0.0.1:1234"))
+	assert.Equal(t, http.StatusTooManyRequests, send("13800138003", "10.0.0.1:1234"))
+	assert.Equal(t, http.StatusOK, send("13800138003", "10.0.0.2:1234"))
+
+	// the expired codes out of the window are pruned
+	old := time.Now().Add(-2 * verifyCodeRetention)
+	um.db.Model(&GinVerifyCode{}).Where("ip", "10.0.0.1").UpdateColumns(map[string]interface{}{"created_at": old, "expired_at": old})
+	um.verifyCodePruneAt = time.Time{}
+	assert.Equal(t, http.StatusOK, send("13800138004", "10.0.0.1:1234"))
+	var count int64
+	um.db.Model(&GinVerifyCode{}).Where("ip", "10.0.0.1").Count(&count)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestVerifyEmailRate(t *testing.T) {
+	um, r := NewTestUserManager()
+	um.EmailMaxSendPerIP = 2
+	um.RegisterHandler("/auth", r)
+	client := NewTestHTTPClient(r)
+
+	send := func(email, remoteAddr string) int {
+		req, _ := http.NewRequest("POST", "/auth/verifyemail/new", strings.NewReader(`{"email":"`+email+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = remoteAddr
+		return client.SendReq("/auth/verifyemail/new", req).Code
+	}
+	// the same email in the interval
+	assert.Equal(t, http.StatusOK, send("bob@example.org", "10.0.0.1:1234"))
+	assert.Equal(t, http.StatusTooManyRequests, send("bob@example.org", "10.0.0.2:1234"))
+	// the different emails from one ip
+	assert.Equal(t, http.StatusOK, send("alice@example.org", "10.0.0.1:1234"))
+	assert.Equal(t, http.StatusTooManyRequests, send("carol@example.org", "10.0.0.1:1234"))
+	assert.Equal(t, http.StatusOK, send("carol@example.org", "10.0.0.3:1234"))
+}
+
+func TestTokenRevokedUser(t *testing.T) {
+	um, r := NewTestUserManager()
+	um.RegisterHandler("/auth", r)
+	client := NewTestHTTPClient(r)
+	addUser(t, client, r, "bob", "bob@example.org", "hello123")
+	var token TokenResult
+	err := client.Call("/auth/token", LoginForm{UserName: "bob", Password: "hello123"}, &token)
+	assert.Nil(t, err)
+	bob, _ := um.Get("bob")
+
+	r.GET("/current", func(c *gin.Context) {
+		c.String(http.StatusOK, CurrentUser(c).UserName)
+	})
+	current := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/current", nil)
+		req.Header.Set("Authorization", "Bearer "+token.Token)
+		return NewTestHTTPClient(r).SendReq("/current", req)
+	}
+	w := current()
+	assert.Equal(t, "bob", w.Body.String())
+	// the token request does not write the cookie session
+	assert.Empty(t, w.Header().Get("Set-Cookie"))
+
+	um.SetEnabled(bob, false)
+	assert.Contains(t, current().Body.String(), "invalid accesstoken")
+	um.SetEnabled(bob, true)
+	assert.Equal(t, "bob", current().Body.String())
+
+	// the session version is bumped without deleting the token
+	um.db.Model(bob).UpdateColumn("session_version", bob.SessionVersion+1)
+	assert.Contains(t, current().Body.String(), "invalid accesstoken")
+}
@@ -0,0 +1,124 @@
+package ginext
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+var errCborUnsupported = errors.New("unsupported cbor")
+
+// cborMaxDepth the nesting of arrays, maps and tags, the attestation of WebAuthn is not deeper than it
+const cborMaxDepth = 16
+
+// cborDecode decode the first CBOR item of data, only the definite length items of WebAuthn are supported.
+// The integers are int64, the maps are map[interface{}]interface{}
+func cborDecode(data []byte) (v interface{}, rest []byte, err error) {
+	return cborDecodeDepth(data, 0)
+}
+
+func cborDecodeDepth(data []byte, depth int) (v interface{}, rest []byte, err error) {
+	if depth > cborMaxDepth {
+		return nil, nil, errors.New("cbor nested too deep")
+	}
+	if len(data) <= 0 {
+		return nil, nil, errors.New("unexpected end of cbor")
+	}
+	major, info := data[0]>>5, data[0]&0x1f
+	data = data[1:]
+
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, data, nil
+		case 21:
+			return true, data, nil
+		case 22, 23:
+			return nil, data, nil
+		case 25:
+			// float16 is not used by WebAuthn
+			return nil, nil, errCborUnsupported
+		case 26:
+			if len(data) < 4 {
+				return nil, nil, errors.New("unexpected end of cbor")
+			}
+			return float64(math.Float32frombits(binary.BigEndian.Uint32(data))), data[4:], nil
+		case 27:
+			if len(data) < 8 {
+				return nil, nil, errors.New("unexpected end of cbor")
+			}
+			return math.Float64frombits(binary.BigEndian.Uint64(data)), data[8:], nil
+		}
+		return nil, nil, errCborUnsupported
+	}
+
+	var n uint64
+	switch {
+	case info < 24:
+		n = uint64(info)
+	case info <= 27:
+		size := 1 << (info - 24)
+		if len(data) < size {
+			return nil, nil, errors.New("unexpected end of cbor")
+		}
+		for _, b := range data[:size] {
+			n = n<<8 | uint64(b)
+		}
+		data = data[size:]
+	default:
+		return nil, nil, errCborUnsupported
+	}
+
+	switch major {
+	case 0:
+		if n > math.MaxInt64 {
+			return nil, nil, errCborUnsupported
+		}
+		return int64(n), data, nil
+	case 1:
+		if n > math.MaxInt64 {
+			return nil, nil, errCborUnsupported
+		}
+		return -1 - int64(n), data, nil
+	case 2, 3:
+		if uint64(len(data)) < n {
+			return nil, nil, errors.New("unexpected end of cbor")
+		}
+		if major == 2 {
+			return append([]byte{}, data[:n]...), data[n:], nil
+		}
+		return string(data[:n]), data[n:], nil
+	case 4:
+		items := []interface{}{}
+		for i := uint64(0); i < n; i++ {
+			var item interface{}
+			if item, data, err = cborDecodeDepth(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, data, nil
+	case 5:
+		m := map[interface{}]interface{}{}
+		for i := uint64(0); i < n; i++ {
+			var key, val interface{}
+			if key, data, err = cborDecodeDepth(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			if val, data, err = cborDecodeDepth(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+				m[key] = val
+			default:
+				return nil, nil, errCborUnsupported
+			}
+		}
+		return m, data, nil
+	case 6:
+		// the tag is ignored
+		return cborDecodeDepth(data, depth+1)
+	}
+	return nil, nil, errCborUnsupported
+}
@@ -0,0 +1,704 @@
+package ginext
+
+import (
+	"fmt"
+	"go/format"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const codegenHeader = "// Code generated by ginext. DO NOT EDIT.\n"
+
+var tsIdentPattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// clientMethod the http method of generated client, POST is preferred,
+// the form of GET is bound from the query so the rpc only by GET is not generated
+func (doc RpcDoc) clientMethod() string {
+	method := ""
+	for _, m := range doc.methods() {
+		switch m {
+		case http.MethodPost:
+			return m
+		case http.MethodPut, http.MethodPatch, http.MethodDelete:
+			if len(method) <= 0 {
+				method = m
+			}
+		}
+	}
+	return method
+}
+
+type rpcPathParam struct {
+	// The param of path, e.g. id of /items/:id
+	Name     string
+	Wildcard bool
+	// The form field with `uri:"id"`
+	FieldName string
+	JSONName  string
+}
+
+// clientPathParams the form fields of path params, false if a param is not bound by the form
+func (doc RpcDoc) clientPathParams() ([]rpcPathParam, bool) {
+	var params []rpcPathParam
+	for _, part := range strings.Split(doc.RelativePath, "/") {
+		if !strings.HasPrefix(part, ":") && !strings.HasPrefix(part, "*") {
+			continue
+		}
+		if doc.formType == nil {
+			return nil, false
+		}
+		f, ok := uriField(derefType(doc.formType), part[1:])
+		if !ok {
+			return nil, false
+		}
+		jsonName := strings.Split(f.Tag.Get("json"), ",")[0]
+		if len(jsonName) <= 0 || jsonName == "-" {
+			return nil, false
+		}
+		params = append(params, rpcPathParam{
+			Name:      part[1:],
+			Wildcard:  part[0] == '*',
+			FieldName: f.Name,
+			JSONName:  jsonName,
+		})
+	}
+	return params, true
+}
+
+func uriField(rt reflect.Type, name string) (reflect.StructField, bool) {
+	if rt.Kind() != reflect.Struct {
+		return reflect.StructField{}, false
+	}
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			if v, ok := uriField(f.Type, name); ok {
+				return v, true
+			}
+			continue
+		}
+		if f.PkgPath == "" && strings.Split(f.Tag.Get("uri"), ",")[0] == name {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// clientSkipped log the rpc can't be called by the generated client, e.g. only by GET
+func clientSkipped(doc RpcDoc) bool {
+	if len(doc.clientMethod()) <= 0 {
+		slog.Warn("codegen skip the rpc without POST, PUT, PATCH or DELETE", "path", doc.RelativePath, "methods", doc.methods())
+		return true
+	}
+	if _, ok := doc.clientPathParams(); !ok {
+		slog.Warn("codegen skip the rpc, the path param is not the form field with uri and json tag", "path", doc.RelativePath)
+		return true
+	}
+	return false
+}
+
+// clientPath split the path by params, e.g. /items/:id/tags => "/items/" + id + "/tags"
+func clientPath(relativePath string, params []rpcPathParam, quote func(string) string, param func(rpcPathParam) string) string {
+	var exprs []string
+	var sb strings.Builder
+	i := 0
+	for idx, part := range strings.Split(relativePath, "/") {
+		if idx > 0 {
+			sb.WriteString("/")
+		}
+		if !strings.HasPrefix(part, ":") && !strings.HasPrefix(part, "*") {
+			sb.WriteString(part)
+			continue
+		}
+		exprs = append(exprs, quote(sb.String()), param(params[i]))
+		sb.Reset()
+		i++
+	}
+	if sb.Len() > 0 || len(exprs) <= 0 {
+		exprs = append(exprs, quote(sb.String()))
+	}
+	return strings.Join(exprs, " + ")
+}
+
+// rpcMethodName e.g. /auth/login => AuthLogin
+func rpcMethodName(relativePath string) string {
+	words := strings.FieldsFunc(relativePath, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var sb strings.Builder
+	for _, w := range words {
+		sb.WriteString(strings.ToUpper(w[:1]) + w[1:])
+	}
+	return sb.String()
+}
+
+func lowerFirst(s string) string {
+	if len(s) <= 0 {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+func derefType(rt reflect.Type) reflect.Type {
+	for rt != nil && rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+	}
+	return rt
+}
+
+func docComment(prefix, doc string) string {
+	var sb strings.Builder
+	for _, line := range strings.Split(strings.TrimSpace(doc), "\n") {
+		sb.WriteString(strings.TrimRight(prefix+line, " ") + "\n")
+	}
+	return sb.String()
+}
+
+/*
+	TypeScript
+*/
+
+type tsGenerator struct {
+	sb    strings.Builder
+	names map[reflect.Type]string
+	used  map[string]bool
+	decls strings.Builder
+}
+
+// checkMethodName the different paths must not have the same method name, e.g. /auth/login and /auth-login
+func checkMethodName(names map[string]string, name, relativePath string) error {
+	if other, ok := names[name]; ok && other != relativePath {
+		return fmt.Errorf("rpc %s and %s have the same method name %s", other, relativePath, name)
+	}
+	names[name] = relativePath
+	return nil
+}
+
+// GenerateTypeScriptClient write the interfaces of forms and results, and a Client
+// unwrap the {code,data,msg}, the fail code is thrown as RpcError.
+// The client call by POST, or PUT, PATCH and DELETE with the json body, the path params are
+// the form fields with `uri` tag. The rpc only by GET, or the path param not in form are skipped with a warning
+func GenerateTypeScriptClient(w io.Writer, docs []RpcDoc) error {
+	return GenerateTypeScriptClientWithCodes(w, docs, RpcErrCodes())
+}
+
+// GenerateTypeScriptClientWithCodes the RpcErrorCodes of client are the codes instead of the registered codes
+func GenerateTypeScriptClientWithCodes(w io.Writer, docs []RpcDoc, codes []RpcErrCode) error {
+	g := &tsGenerator{
+		names: map[reflect.Type]string{},
+		used:  map[string]bool{},
+	}
+
+	var methods strings.Builder
+	methodNames := map[string]string{}
+	for _, doc := range docs {
+		if doc.IsGroup || clientSkipped(doc) {
+			continue
+		}
+		name := rpcMethodName(doc.RelativePath)
+		if err := checkMethodName(methodNames, name, doc.RelativePath); err != nil {
+			return err
+		}
+		formName := ""
+		if doc.formType != nil {
+			formName = g.declare(doc.formType, name+"Form", RpcFieldType{Type: "object", Fields: doc.Fields}, true)
+		}
+		resultName := "unknown"
+		if doc.resultType != nil {
+			resultName = g.declare(doc.resultType, name+"Result", doc.ResultType, false)
+		}
+
+		methods.WriteString("\n")
+		if len(doc.DocString) > 0 {
+			methods.WriteString("  /**\n" + docComment("   * ", strings.ReplaceAll(doc.DocString, "*/", "*\\/")) + "   */\n")
+		}
+		params, _ := doc.clientPathParams()
+		uri := clientPath(doc.RelativePath, params, tsQuote, tsPathParam)
+		if len(formName) > 0 {
+			fmt.Fprintf(&methods, "  %s(form: %s): Promise<%s> {\n", lowerFirst(name), formName, resultName)
+			fmt.Fprintf(&methods, "    return this.call<%s>('%s', %s, form, %v)\n", resultName, doc.clientMethod(), uri, doc.reduceDataField)
+		} else {
+			fmt.Fprintf(&methods, "  %s(): Promise<%s> {\n", lowerFirst(name), resultName)
+			fmt.Fprintf(&methods, "    return this.call<%s>('%s', %s, undefined, %v)\n", resultName, doc.clientMethod(), uri, doc.reduceDataField)
+		}
+		methods.WriteString("  }\n")
+	}
+
+	g.sb.WriteString(codegenHeader)
+	g.sb.WriteString(tsPrelude)
+	g.sb.WriteString(tsErrorCodes(codes))
+	g.sb.WriteString(g.decls.String())
+	g.sb.WriteString(tsClientHead)
+	g.sb.WriteString(methods.String())
+	g.sb.WriteString("}\n")
+
+	_, err := io.WriteString(w, g.sb.String())
+	return err
+}
+
+func tsQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "\\'") + "'"
+}
+
+// tsPathParam the wildcard param keep the slashes
+func tsPathParam(p rpcPathParam) string {
+	field := "form." + p.JSONName
+	if !tsIdentPattern.MatchString(p.JSONName) {
+		field = "form[" + tsQuote(p.JSONName) + "]"
+	}
+	if p.Wildcard {
+		return "encodeURI(String(" + field + "))"
+	}
+	return "encodeURIComponent(String(" + field + "))"
+}
+
+func (g *tsGenerator) declare(rt reflect.Type, fallback string, field RpcFieldType, isForm bool) string {
+	rt = derefType(rt)
+	if name, ok := g.names[rt]; ok {
+		return name
+	}
+	// the builtin types, e.g. bool
+	if len(rt.Name()) > 0 && len(rt.PkgPath()) <= 0 {
+		return tsType(field, isForm, "")
+	}
+	name := rt.Name()
+	if len(name) <= 0 {
+		name = fallback
+	}
+	for i := 2; g.used[name]; i++ {
+		name = fmt.Sprintf("%s%d", strings.TrimRight(name, "0123456789"), i)
+	}
+	g.names[rt] = name
+	g.used[name] = true
+
+	g.decls.WriteString("\n")
+	if field.Type == "object" && len(field.Fields) > 0 {
+		fmt.Fprintf(&g.decls, "export interface %s %s\n", name, tsObject(field.Fields, isForm, ""))
+	} else {
+		fmt.Fprintf(&g.decls, "export type %s = %s\n", name, tsType(field, isForm, ""))
+	}
+	return name
+}
+
+func tsObject(fields []RpcFieldType, isForm bool, indent string) string {
+	var sb strings.Builder
+	sb.WriteString("{\n")
+	for _, f := range fields {
+		name := f.Name
+		if !tsIdentPattern.MatchString(name) {
+			name = "'" + name + "'"
+		}
+		if (isForm && !f.Required) || f.Optional {
+			name += "?"
+		}
+		if len(f.Desc) > 0 {
+			fmt.Fprintf(&sb, "%s  /** %s */\n", indent, strings.ReplaceAll(f.Desc, "*/", "*\\/"))
+		}
+		fmt.Fprintf(&sb, "%s  %s: %s\n", indent, name, tsType(f, isForm, indent+"  "))
+	}
+	sb.WriteString(indent + "}")
+	return sb.String()
+}
+
+func tsType(f RpcFieldType, isForm bool, indent string) (val string) {
+	switch {
+	case f.Type == "string" || f.Type == "Date":
+		val = "string"
+	case f.Type == "boolean":
+		val = "boolean"
+	case f.Type == "Integer" || f.Type == "Number":
+		val = "number"
+	case f.Type == "object":
+		if len(f.Fields) > 0 {
+			val = tsObject(f.Fields, isForm, indent)
+		} else {
+			val = "Record<string, any>"
+		}
+	case strings.HasPrefix(f.Type, "[]"):
+		val = "Array<" + tsType(f.ElemType(), isForm, indent) + ">"
+	case strings.HasPrefix(f.Type, "map["):
+		val = "Record<string, " + tsType(f.ElemType(), isForm, indent) + ">"
+	default:
+		val = "any"
+	}
+	if len(f.Enum) > 0 {
+		vals := make([]string, 0, len(f.Enum))
+		for _, v := range f.Enum {
+			vals = append(vals, "'"+v+"'")
+		}
+		val = strings.Join(vals, " | ")
+	}
+	if f.CanNull {
+		val += " | null"
+	}
+	return val
+}
+
+func tsErrorCodes(codes []RpcErrCode) string {
+	var sb strings.Builder
+	sb.WriteString("\nexport const RpcErrorCodes: Record<number, string> = {\n")
+	for _, v := range codes {
+		fmt.Fprintf(&sb, "  %d: %q,\n", v.Code, v.Desc)
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+const tsPrelude = `
+export class RpcError extends Error {
+  code: number
+  key?: string
+  details?: unknown
+  fields?: Record<string, string[]>
+
+  constructor(code: number, msg: string, body: { key?: string, details?: unknown, fields?: Record<string, string[]> } = {}) {
+    super(msg)
+    this.name = 'RpcError'
+    this.code = code
+    this.key = body.key
+    this.details = body.details
+    this.fields = body.fields
+  }
+}
+`
+
+const tsClientHead = `
+export interface ClientOptions {
+  baseUrl?: string
+  token?: string
+  headers?: Record<string, string>
+  fetch?: typeof fetch
+}
+
+export class Client {
+  constructor(public options: ClientOptions = {}) {}
+
+  async call<T>(method: string, path: string, form: unknown, reduce: boolean): Promise<T> {
+    const headers: Record<string, string> = { 'Content-Type': 'application/json', ...this.options.headers }
+    if (this.options.token) {
+      headers['Authorization'] = 'Bearer ' + this.options.token
+    }
+    const doFetch = this.options.fetch ?? fetch
+    const resp = await doFetch((this.options.baseUrl ?? '') + path, {
+      method,
+      headers,
+      credentials: 'include',
+      body: JSON.stringify(form ?? {}),
+    })
+    const body = await resp.json().catch(() => ({}))
+    if (!resp.ok) {
+      throw new RpcError(body.code ?? resp.status, body.msg ?? resp.statusText, body)
+    }
+    if (reduce) {
+      return body as T
+    }
+    if (body.code !== 200) {
+      throw new RpcError(body.code, body.msg, body)
+    }
+    return body.data as T
+  }
+`
+
+/*
+	Go
+*/
+
+type goGenerator struct {
+	pkgPath string
+	imports map[string]string
+}
+
+// GenerateGoClient write the Go client into package pkgPath, the forms and results
+// are referenced by their packages. The unexported types are decoded as json.RawMessage.
+// Same as the TypeScript client, the rpc only by GET or the path param not in form are skipped
+func GenerateGoClient(w io.Writer, pkgPath string, docs []RpcDoc) error {
+	g := &goGenerator{
+		pkgPath: pkgPath,
+		imports: map[string]string{},
+	}
+
+	var methods strings.Builder
+	methodNames := map[string]string{}
+	for _, doc := range docs {
+		if doc.IsGroup || clientSkipped(doc) {
+			continue
+		}
+		if params, _ := doc.clientPathParams(); len(params) > 0 {
+			if _, ok := g.typeExpr(derefType(doc.formType)); !ok {
+				slog.Warn("codegen skip the rpc, the form of path params is not exported", "path", doc.RelativePath)
+				continue
+			}
+		}
+		if err := checkMethodName(methodNames, rpcMethodName(doc.RelativePath), doc.RelativePath); err != nil {
+			return err
+		}
+		g.writeMethod(&methods, doc)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(codegenHeader)
+	fmt.Fprintf(&sb, "\npackage %s\n\n", goPackageName(pkgPath))
+	sb.WriteString(g.importDecl())
+	sb.WriteString(goClientHead)
+	sb.WriteString(methods.String())
+
+	src, err := format.Source([]byte(sb.String()))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+func (g *goGenerator) writeMethod(sb *strings.Builder, doc RpcDoc) {
+	name := rpcMethodName(doc.RelativePath)
+	sb.WriteString("\n")
+	if len(doc.DocString) > 0 {
+		sb.WriteString(docComment("// ", name+" "+doc.DocString))
+	}
+
+	params := "ctx context.Context"
+	formArg := "nil"
+	if doc.formType != nil {
+		formType := g.paramType(doc.formType)
+		params += ", form " + formType
+		formArg = "form"
+	}
+
+	pathParams, _ := doc.clientPathParams()
+	method := doc.clientMethod()
+	// e.g. http.MethodPost, "/items/" + pathParam(form.ID)
+	callArgs := "http.Method" + method[:1] + strings.ToLower(method[1:]) + ", " + clientPath(doc.RelativePath, pathParams, strconv.Quote, goPathParam)
+
+	if doc.resultType == nil {
+		fmt.Fprintf(sb, "func (c *Client) %s(%s) error {\n", name, params)
+		fmt.Fprintf(sb, "\treturn c.Call(ctx, %s, %s, nil, %v)\n}\n", callArgs, formArg, doc.reduceDataField)
+		return
+	}
+
+	resultType, ok := g.typeExpr(doc.resultType)
+	if !ok {
+		resultType = "json.RawMessage"
+	}
+	if rt := derefType(doc.resultType); ok && rt.Kind() == reflect.Struct {
+		resultType, _ = g.typeExpr(rt)
+		fmt.Fprintf(sb, "func (c *Client) %s(%s) (*%s, error) {\n", name, params, resultType)
+		fmt.Fprintf(sb, "\tvar result %s\n", resultType)
+		fmt.Fprintf(sb, "\tif err := c.Call(ctx, %s, %s, &result, %v); err != nil {\n\t\treturn nil, err\n\t}\n", callArgs, formArg, doc.reduceDataField)
+		sb.WriteString("\treturn &result, nil\n}\n")
+		return
+	}
+	fmt.Fprintf(sb, "func (c *Client) %s(%s) (%s, error) {\n", name, params, resultType)
+	fmt.Fprintf(sb, "\tvar result %s\n", resultType)
+	fmt.Fprintf(sb, "\terr := c.Call(ctx, %s, %s, &result, %v)\n", callArgs, formArg, doc.reduceDataField)
+	sb.WriteString("\treturn result, err\n}\n")
+}
+
+// goPathParam the wildcard param keep the slashes
+func goPathParam(p rpcPathParam) string {
+	if p.Wildcard {
+		return "pathWildcard(form." + p.FieldName + ")"
+	}
+	return "pathParam(form." + p.FieldName + ")"
+}
+
+func (g *goGenerator) paramType(rt reflect.Type) string {
+	expr, ok := g.typeExpr(rt)
+	if !ok {
+		return "interface{}"
+	}
+	if rt.Kind() == reflect.Struct {
+		return "*" + expr
+	}
+	return expr
+}
+
+// typeExpr return the Go expression of rt, false if the type can't be referenced
+func (g *goGenerator) typeExpr(rt reflect.Type) (string, bool) {
+	if len(rt.Name()) > 0 {
+		if len(rt.PkgPath()) <= 0 {
+			return rt.Name(), true
+		}
+		if !unicode.IsUpper([]rune(rt.Name())[0]) {
+			return "", false
+		}
+		if rt.PkgPath() == g.pkgPath {
+			return rt.Name(), true
+		}
+		return g.importName(rt.PkgPath()) + "." + rt.Name(), true
+	}
+
+	switch rt.Kind() {
+	case reflect.Ptr:
+		elem, ok := g.typeExpr(rt.Elem())
+		return "*" + elem, ok
+	case reflect.Slice:
+		elem, ok := g.typeExpr(rt.Elem())
+		return "[]" + elem, ok
+	case reflect.Array:
+		elem, ok := g.typeExpr(rt.Elem())
+		return fmt.Sprintf("[%d]%s", rt.Len(), elem), ok
+	case reflect.Map:
+		key, ok := g.typeExpr(rt.Key())
+		elem, ok2 := g.typeExpr(rt.Elem())
+		return "map[" + key + "]" + elem, ok && ok2
+	case reflect.Interface:
+		if rt.NumMethod() == 0 {
+			return "interface{}", true
+		}
+	}
+	return "", false
+}
+
+func (g *goGenerator) importName(pkgPath string) string {
+	if name, ok := g.imports[pkgPath]; ok {
+		return name
+	}
+	name := goPackageName(pkgPath)
+	taken := map[string]bool{"bytes": true, "context": true, "json": true, "fmt": true, "http": true, "url": true, "strings": true}
+	for _, v := range g.imports {
+		taken[v] = true
+	}
+	base := name
+	for i := 2; taken[name]; i++ {
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+	g.imports[pkgPath] = name
+	return name
+}
+
+// goPackageName guess the package name from path, e.g. gopkg.in/yaml.v2 => yaml
+func goPackageName(pkgPath string) string {
+	name := path.Base(pkgPath)
+	if idx := strings.Index(name, "."); idx > 0 {
+		name = name[:idx]
+	}
+	return strings.ReplaceAll(name, "-", "")
+}
+
+func (g *goGenerator) importDecl() string {
+	lines := []string{`"bytes"`, `"context"`, `"encoding/json"`, `"fmt"`, `"net/http"`, `"net/url"`, `"strings"`}
+	var pkgs []string
+	for pkgPath := range g.imports {
+		pkgs = append(pkgs, pkgPath)
+	}
+	sort.Strings(pkgs)
+	if len(pkgs) > 0 {
+		lines = append(lines, "")
+	}
+	for _, pkgPath := range pkgs {
+		name := g.imports[pkgPath]
+		if name == path.Base(pkgPath) {
+			lines = append(lines, fmt.Sprintf("%q", pkgPath))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s %q", name, pkgPath))
+		}
+	}
+	return "import (\n\t" + strings.Join(lines, "\n\t") + "\n)\n"
+}
+
+const goClientHead = `
+// Error is the fail code and message of rpc, Fields are the messages of form fields
+type Error struct {
+	Code    int                 ` + "`json:\"code\"`" + `
+	Key     string              ` + "`json:\"key\"`" + `
+	Msg     string              ` + "`json:\"msg\"`" + `
+	Details json.RawMessage     ` + "`json:\"details\"`" + `
+	Fields  map[string][]string ` + "`json:\"fields\"`" + `
+	// The http status
+	Status int ` + "`json:\"-\"`" + `
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rpc fail %d: %s", e.Code, e.Msg)
+}
+
+type Client struct {
+	BaseURL    string
+	Token      string
+	HTTPClient *http.Client
+}
+
+func NewClient(baseURL string) *Client {
+	return &Client{
+		BaseURL:    baseURL,
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+func pathParam(v interface{}) string {
+	return url.PathEscape(fmt.Sprint(v))
+}
+
+// pathWildcard escape the param of *path, and keep the slashes
+func pathWildcard(v interface{}) string {
+	parts := strings.Split(fmt.Sprint(v), "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// Call send the form as json body, and decode the data into result
+func (c *Client) Call(ctx context.Context, method, path string, form, result interface{}, reduce bool) error {
+	if form == nil {
+		form = struct{}{}
+	}
+	data, err := json.Marshal(form)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(c.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Error
+		Data json.RawMessage ` + "`json:\"data\"`" + `
+	}
+	if resp.StatusCode != http.StatusOK {
+		json.NewDecoder(resp.Body).Decode(&body)
+		if body.Code == 0 {
+			body.Code = resp.StatusCode
+		}
+		body.Error.Status = resp.StatusCode
+		return &body.Error
+	}
+	if reduce {
+		if result == nil {
+			return nil
+		}
+		return json.NewDecoder(resp.Body).Decode(result)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return err
+	}
+	if body.Code != http.StatusOK {
+		body.Error.Status = resp.StatusCode
+		return &body.Error
+	}
+	if result == nil || len(body.Data) <= 0 {
+		return nil
+	}
+	return json.Unmarshal(body.Data, result)
+}
+`
@@ -0,0 +1,140 @@
+package ginext
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files of testdata")
+
+func testCodegenDocs() []RpcDoc {
+	return []RpcDoc{
+		{IsGroup: true, RelativePath: "Auth"},
+		NewRpcDoc(&RpcContext{
+			Form:         RegisterUserForm{},
+			Result:       UserInfoResult{},
+			RelativePath: "/auth/register",
+			Doc:          "Register a new user",
+		}),
+		NewRpcDoc(&RpcContext{
+			Form:         LoginForm{},
+			Result:       UserInfoResult{},
+			RelativePath: "/auth/login",
+			Doc:          "Login with username or email\nThe session cookie is set",
+		}),
+		NewRpcDoc(&RpcContext{
+			AuthRequired: true,
+			RelativePath: "/auth/logout",
+		}),
+		{IsGroup: true, RelativePath: "Mock"},
+		NewRpcDoc(&RpcContext{
+			Form:            testOpenAPIForm{},
+			Result:          []UserInfoResult{},
+			ReduceDataField: true,
+			RelativePath:    "/mock/pets",
+		}),
+		NewRpcDoc(&RpcContext{
+			Form:         testStructFieldForm{},
+			Result:       testEmbedResult{},
+			RelativePath: "/mock/embed",
+		}),
+		NewRpcDoc(&RpcContext{
+			Form:         CodegenItemForm{},
+			Result:       true,
+			Methods:      []string{http.MethodGet, http.MethodPut},
+			RelativePath: "/mock/items/:id",
+		}),
+		NewRpcDoc(&RpcContext{
+			Form:         CodegenItemForm{},
+			Methods:      []string{http.MethodDelete},
+			RelativePath: "/mock/items/:id/files/*path",
+		}),
+		// skipped, the form of GET is bound from the query
+		NewRpcDoc(&RpcContext{
+			Methods:      []string{http.MethodGet},
+			RelativePath: "/mock/status",
+		}),
+	}
+}
+
+// CodegenItemForm is exported, the Go client reference the fields of path params
+type CodegenItemForm struct {
+	ID    uint   `json:"id" uri:"id"`
+	Path  string `json:"path" uri:"path"`
+	Title string `json:"title"`
+}
+
+// the golden is not changed by the codes registered later
+func testCodegenErrCodes() []RpcErrCode {
+	return []RpcErrCode{
+		{Code: 400, Desc: "Bad request or bind form fail"},
+		{Code: 401, Desc: "Auth required"},
+		{Code: 10002, Key: "bad_password", Desc: "Bad username or password"},
+	}
+}
+
+func checkGolden(t *testing.T, name string, data []byte) {
+	fileName := filepath.Join("testdata", name)
+	if *updateGolden {
+		assert.Nil(t, ioutil.WriteFile(fileName, data, 0644))
+	}
+	expected, err := ioutil.ReadFile(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, string(expected), string(data))
+}
+
+func TestGenerateTypeScriptClient(t *testing.T) {
+	var buf bytes.Buffer
+	err := GenerateTypeScriptClientWithCodes(&buf, testCodegenDocs(), testCodegenErrCodes())
+	assert.Nil(t, err)
+	checkGolden(t, "client.ts.golden", buf.Bytes())
+}
+
+func TestGenerateGoClient(t *testing.T) {
+	var buf bytes.Buffer
+	err := GenerateGoClient(&buf, "example.com/app/client", testCodegenDocs())
+	assert.Nil(t, err)
+	checkGolden(t, "client.go.golden", buf.Bytes())
+}
+
+func TestGenerateMethodNameCollision(t *testing.T) {
+	docs := []RpcDoc{
+		NewRpcDoc(&RpcContext{RelativePath: "/auth/login"}),
+		NewRpcDoc(&RpcContext{RelativePath: "/auth-login"}),
+	}
+	var buf bytes.Buffer
+	err := GenerateTypeScriptClientWithCodes(&buf, docs, nil)
+	assert.NotNil(t, err)
+	err = GenerateGoClient(&buf, "example.com/app/client", docs)
+	assert.NotNil(t, err)
+}
+
+func TestGenerateSkipped(t *testing.T) {
+	docs := []RpcDoc{
+		NewRpcDoc(&RpcContext{Methods: []string{http.MethodGet}, RelativePath: "/mock/status"}),
+		// the path param is not the form field
+		NewRpcDoc(&RpcContext{Form: LoginForm{}, RelativePath: "/mock/items/:id"}),
+		NewRpcDoc(&RpcContext{Form: CodegenItemForm{}, Methods: []string{http.MethodPatch}, RelativePath: "/mock/items/:id"}),
+	}
+	assert.Equal(t, "", docs[0].clientMethod())
+	_, ok := docs[1].clientPathParams()
+	assert.False(t, ok)
+	assert.Equal(t, http.MethodPatch, docs[2].clientMethod())
+
+	var buf bytes.Buffer
+	assert.Nil(t, GenerateTypeScriptClientWithCodes(&buf, docs, nil))
+	assert.NotContains(t, buf.String(), "mockStatus")
+	assert.Contains(t, buf.String(), "this.call<unknown>('PATCH', '/mock/items/' + encodeURIComponent(String(form.id)), form, false)")
+}
+
+func TestRpcMethodName(t *testing.T) {
+	assert.Equal(t, "AuthLogin", rpcMethodName("/auth/login"))
+	assert.Equal(t, "AuthPasswordLostReset", rpcMethodName("/auth/password-lost/reset"))
+	assert.Equal(t, "yaml", goPackageName("gopkg.in/yaml.v2"))
+}
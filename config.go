@@ -0,0 +1,442 @@
+package ginext
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+var configValueCache *lru.Cache
+var configCacheExpired time.Duration = 10 * time.Second
+
+// How often to check the version column for the changes of other instances
+var configSyncInterval time.Duration = 1 * time.Second
+
+type configCacheItem struct {
+	n       time.Time
+	val     string
+	version int64
+}
+
+type configSyncState struct {
+	sync.Mutex
+	version   int64
+	checkedAt time.Time
+}
+
+var configSync configSyncState
+
+// The only row of GinExtConfigVersion
+const configVersionID = 1
+
+func init() {
+	resetConfigCache()
+}
+
+func resetConfigCache() {
+	configValueCache, _ = lru.New(512) // fixed size
+	configSync.Lock()
+	configSync.version = -1
+	configSync.checkedAt = time.Time{}
+	configSync.Unlock()
+}
+
+// SettingKey return the full key of the namespaced value, e.g. MAIL.SMTP_HOST
+func SettingKey(namespace, key string) string {
+	if len(namespace) <= 0 {
+		return strings.ToUpper(key)
+	}
+	return strings.ToUpper(namespace + "." + key)
+}
+
+// syncConfigVersion invalidate the cached values which changed by other instances
+func syncConfigVersion(db *gorm.DB) {
+	configSync.Lock()
+	if time.Since(configSync.checkedAt) < configSyncInterval {
+		configSync.Unlock()
+		return
+	}
+	configSync.checkedAt = time.Now()
+
+	if configSync.version < 0 {
+		var version int64
+		result := db.Model(&GinExtConfig{}).Select("COALESCE(MAX(version), 0)").Scan(&version)
+		if result.Error == nil {
+			configSync.version = version
+		}
+		configSync.Unlock()
+		return
+	}
+
+	var items []GinExtConfig
+	result := db.Where("version > ?", configSync.version).Order("version").Find(&items)
+	if result.Error != nil {
+		configSync.Unlock()
+		return
+	}
+
+	changed := make([]GinExtConfig, 0, len(items))
+	for _, item := range items {
+		configSync.version = item.Version
+		cobj, ok := configValueCache.Get(item.Key)
+		if ok && cobj.(*configCacheItem).version >= item.Version {
+			continue
+		}
+		configValueCache.Remove(item.Key)
+		changed = append(changed, item)
+	}
+	configSync.Unlock()
+
+	// the handlers could read the values, emit without the lock
+	for _, item := range changed {
+		Sig().Emit(SigSettingChanged, nil, item.Key, item.Value)
+	}
+}
+
+func GetValueEx(db *gorm.DB, key string) string {
+	newKey := strings.ToUpper(key)
+	syncConfigVersion(db)
+
+	cobj, ok := configValueCache.Get(newKey)
+	if ok {
+		if time.Since(cobj.(*configCacheItem).n) < configCacheExpired {
+			return cobj.(*configCacheItem).val
+		}
+	}
+
+	var v GinExtConfig
+	result := db.Where("key", newKey).Take(&v)
+	if result.Error != nil {
+		return ""
+	}
+
+	configValueCache.Add(newKey, &configCacheItem{
+		n:       time.Now(),
+		val:     v.Value,
+		version: v.Version,
+	})
+	return v.Value
+}
+
+func GetInt64ValueEx(db *gorm.DB, key string, defaultVal int64) int64 {
+	v := GetValueEx(db, key)
+	if v == "" {
+		return defaultVal
+	}
+	val, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return defaultVal
+	}
+	return val
+}
+
+func GetIntValueEx(db *gorm.DB, key string, defaultVal int) int {
+	v := GetValueEx(db, key)
+	if v == "" {
+		return defaultVal
+	}
+	val, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return defaultVal
+	}
+	return int(val)
+}
+
+func GetBoolValueEx(db *gorm.DB, key string, defaultVal bool) bool {
+	v := GetValueEx(db, key)
+	if v == "" {
+		return defaultVal
+	}
+	val, err := strconv.ParseBool(v)
+	if err != nil {
+		return defaultVal
+	}
+	return val
+}
+
+// GetDurationValueEx parse the value as time.Duration, e.g. "1h30m", plain number means seconds
+func GetDurationValueEx(db *gorm.DB, key string, defaultVal time.Duration) time.Duration {
+	v := GetValueEx(db, key)
+	if v == "" {
+		return defaultVal
+	}
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	val, err := time.ParseDuration(v)
+	if err != nil {
+		return defaultVal
+	}
+	return val
+}
+
+// GetJSONValueEx decode the json value into obj
+func GetJSONValueEx(db *gorm.DB, key string, obj interface{}) error {
+	v := GetValueEx(db, key)
+	if v == "" {
+		return gorm.ErrRecordNotFound
+	}
+	return json.Unmarshal([]byte(v), obj)
+}
+
+func SetJSONValueEx(db *gorm.DB, key string, obj interface{}) error {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	SetValueEx(db, key, string(data))
+	return nil
+}
+
+// initConfigVersion create the counter row from the versions written before, the row exists is kept
+func initConfigVersion(db *gorm.DB) {
+	var version int64
+	db.Model(&GinExtConfig{}).Select("COALESCE(MAX(version), 0)").Scan(&version)
+	db.Clauses(clause.OnConflict{DoNothing: true}).Create(&GinExtConfigVersion{ID: configVersionID, Version: version})
+}
+
+// nextConfigVersion increase the counter row in the transaction, the versions are unique between instances
+func nextConfigVersion(tx *gorm.DB) (int64, error) {
+	result := tx.Model(&GinExtConfigVersion{}).Where("id", configVersionID).UpdateColumn("version", gorm.Expr("version + 1"))
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected <= 0 {
+		// the counter is created by initDB, the values written before are counted
+		var version int64
+		tx.Model(&GinExtConfig{}).Select("COALESCE(MAX(version), 0)").Scan(&version)
+		counter := GinExtConfigVersion{ID: configVersionID, Version: version + 1}
+		if err := tx.Create(&counter).Error; err != nil {
+			return 0, err
+		}
+		return counter.Version, nil
+	}
+	var counter GinExtConfigVersion
+	if err := tx.Take(&counter, configVersionID).Error; err != nil {
+		return 0, err
+	}
+	return counter.Version, nil
+}
+
+func SetValueEx(db *gorm.DB, key, value string) {
+	newKey := strings.ToUpper(key)
+	configValueCache.Remove(newKey)
+
+	var version int64
+	changed := false
+	err := db.Transaction(func(tx *gorm.DB) (err error) {
+		var v GinExtConfig
+		result := tx.Where("key", newKey).Take(&v)
+		if result.Error == nil && v.Value == value {
+			return nil
+		}
+		if version, err = nextConfigVersion(tx); err != nil {
+			return err
+		}
+		changed = true
+		if result.Error != nil {
+			newV := &GinExtConfig{
+				Key:       newKey,
+				Value:     value,
+				Namespace: settingNamespace(newKey),
+				Version:   version,
+			}
+			return tx.Create(&newV).Error
+		}
+		vals := map[string]interface{}{
+			"Value":     value,
+			"Version":   version,
+			"UpdatedAt": time.Now(),
+		}
+		return tx.Model(&GinExtConfig{}).Where("key", newKey).UpdateColumns(vals).Error
+	})
+	if err != nil || !changed {
+		return
+	}
+
+	configValueCache.Add(newKey, &configCacheItem{
+		n:       time.Now(),
+		val:     value,
+		version: version,
+	})
+	Sig().Emit(SigSettingChanged, nil, newKey, value)
+}
+
+func settingNamespace(key string) string {
+	if idx := strings.LastIndex(key, "."); idx > 0 {
+		return key[:idx]
+	}
+	return ""
+}
+
+// DefineValueEx register the setting with default value and description,
+// the value exists is never overwritten
+func DefineValueEx(db *gorm.DB, namespace, key, defaultValue, desc string) {
+	newKey := SettingKey(namespace, key)
+	var v GinExtConfig
+	result := db.Where("key", newKey).Take(&v)
+	if result.Error != nil {
+		db.Transaction(func(tx *gorm.DB) error {
+			version, err := nextConfigVersion(tx)
+			if err != nil {
+				return err
+			}
+			newV := &GinExtConfig{
+				Key:         newKey,
+				Value:       defaultValue,
+				Namespace:   strings.ToUpper(namespace),
+				Description: desc,
+				Version:     version,
+			}
+			return tx.Create(&newV).Error
+		})
+		return
+	}
+	if v.Description != desc {
+		db.Model(&v).UpdateColumn("description", desc)
+	}
+}
+
+func (cfg *GinExt) GetValue(key string) string {
+	return GetValueEx(cfg.DbInstance, key)
+}
+
+func (cfg *GinExt) GetBoolValue(key string, defaultVal bool) bool {
+	return GetBoolValueEx(cfg.DbInstance, key, defaultVal)
+}
+
+func (cfg *GinExt) GetDurationValue(key string, defaultVal time.Duration) time.Duration {
+	return GetDurationValueEx(cfg.DbInstance, key, defaultVal)
+}
+
+func (cfg *GinExt) GetJSONValue(key string, obj interface{}) error {
+	return GetJSONValueEx(cfg.DbInstance, key, obj)
+}
+
+func (cfg *GinExt) CheckValue(key, defaultValue string) {
+	if len(cfg.GetValue(key)) <= 0 {
+		cfg.SetValue(key, defaultValue)
+	}
+}
+
+func (cfg *GinExt) DefineValue(key, defaultValue, desc string) {
+	DefineValueEx(cfg.DbInstance, "", key, defaultValue, desc)
+}
+
+func (cfg *GinExt) SetValue(key, value string) {
+	SetValueEx(cfg.DbInstance, key, value)
+}
+
+func (cfg *GinExt) SetJSONValue(key string, obj interface{}) error {
+	return SetJSONValueEx(cfg.DbInstance, key, obj)
+}
+
+// ConfigNamespace group the settings of one module, e.g. "mail", "auth"
+type ConfigNamespace struct {
+	ext  *GinExt
+	Name string
+}
+
+func (cfg *GinExt) Namespace(name string) *ConfigNamespace {
+	return &ConfigNamespace{ext: cfg, Name: strings.ToUpper(name)}
+}
+
+func (ns *ConfigNamespace) Key(key string) string {
+	return SettingKey(ns.Name, key)
+}
+
+func (ns *ConfigNamespace) Define(key, defaultValue, desc string) {
+	DefineValueEx(ns.ext.DbInstance, ns.Name, key, defaultValue, desc)
+}
+
+func (ns *ConfigNamespace) Get(key string) string {
+	return ns.ext.GetValue(ns.Key(key))
+}
+
+func (ns *ConfigNamespace) GetInt(key string, defaultVal int) int {
+	return GetIntValueEx(ns.ext.DbInstance, ns.Key(key), defaultVal)
+}
+
+func (ns *ConfigNamespace) GetBool(key string, defaultVal bool) bool {
+	return ns.ext.GetBoolValue(ns.Key(key), defaultVal)
+}
+
+func (ns *ConfigNamespace) GetDuration(key string, defaultVal time.Duration) time.Duration {
+	return ns.ext.GetDurationValue(ns.Key(key), defaultVal)
+}
+
+func (ns *ConfigNamespace) GetJSON(key string, obj interface{}) error {
+	return ns.ext.GetJSONValue(ns.Key(key), obj)
+}
+
+func (ns *ConfigNamespace) Set(key, value string) {
+	ns.ext.SetValue(ns.Key(key), value)
+}
+
+func (ns *ConfigNamespace) SetJSON(key string, obj interface{}) error {
+	return ns.ext.SetJSONValue(ns.Key(key), obj)
+}
+
+/*
+	/settings/list
+	/settings/edit
+*/
+
+type SettingListForm struct {
+	PaginationForm
+	Namespace *string `json:"namespace"`
+}
+
+type SettingListResult struct {
+	PaginationResult
+	Items []GinExtConfig `json:"items"`
+}
+
+type SettingEditForm struct {
+	Key   string `json:"key" binding:"required"`
+	Value string `json:"value"`
+}
+
+const docSettingList = `List the runtime settings, staff only`
+const docSettingEdit = `Edit the runtime setting, staff only`
+
+// RegisterSettingsHandler the admin rpc of settings, require UserManager.RegisterHandler first
+func (cfg *GinExt) RegisterSettingsHandler(prefix string, r *gin.Engine) {
+	api := cfg.Registry.Router(r)
+	api.Registry.AddAppLabel("Settings")
+
+	RpcDefine(api, &RpcContext{
+		StaffRequired: true,
+		OnlyPost:      true,
+		Form:          SettingListForm{},
+		Result:        SettingListResult{},
+		RelativePath:  prefix + "/list",
+		Handler:       cfg.handleSettingList,
+		Doc:           docSettingList,
+	})
+	Rpc(api, prefix+"/edit", cfg.handleSettingEdit, WithStaffRequired(), WithOnlyPost(), WithDoc(docSettingEdit))
+}
+
+func (cfg *GinExt) handleSettingList(c *gin.Context) {
+	form := c.MustGet(RpcFormField).(*SettingListForm)
+	tx := cfg.DbInstance.Model(&GinExtConfig{})
+	if form.Namespace != nil {
+		tx = tx.Where("namespace", strings.ToUpper(*form.Namespace))
+	}
+	var r SettingListResult
+	ListObject(c, tx, &r, &form.PaginationForm, "key", "`key` LIKE ? OR description LIKE ?")
+}
+
+func (cfg *GinExt) handleSettingEdit(c *gin.Context, form *SettingEditForm) (v GinExtConfig, err error) {
+	cfg.SetValue(form.Key, form.Value)
+	err = cfg.DbInstance.Where("key", strings.ToUpper(form.Key)).Take(&v).Error
+	return v, err
+}
@@ -0,0 +1,135 @@
+package ginext
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigTypedValue(t *testing.T) {
+	cfg := NewGinExt("..")
+	cfg.Init()
+
+	cfg.SetValue("enabled", "true")
+	assert.True(t, cfg.GetBoolValue("enabled", false))
+	assert.True(t, cfg.GetBoolValue("missing", true))
+
+	cfg.SetValue("timeout", "1m30s")
+	assert.Equal(t, 90*time.Second, cfg.GetDurationValue("timeout", 0))
+	cfg.SetValue("timeout", "15")
+	assert.Equal(t, 15*time.Second, cfg.GetDurationValue("timeout", 0))
+	assert.Equal(t, time.Second, cfg.GetDurationValue("missing", time.Second))
+
+	type mockSetting struct {
+		Host string `json:"host"`
+		Port int    `json:"port"`
+	}
+	err := cfg.SetJSONValue("smtp", mockSetting{Host: "localhost", Port: 25})
+	assert.Nil(t, err)
+	var v mockSetting
+	err = cfg.GetJSONValue("smtp", &v)
+	assert.Nil(t, err)
+	assert.Equal(t, 25, v.Port)
+	assert.NotNil(t, cfg.GetJSONValue("missing", &v))
+}
+
+func TestConfigNamespace(t *testing.T) {
+	cfg := NewGinExt("..")
+	cfg.Init()
+
+	ns := cfg.Namespace("mail")
+	ns.Define("smtp_port", "25", "port of smtp server")
+	ns.Define("smtp_port", "587", "port of smtp server")
+	assert.Equal(t, 25, ns.GetInt("smtp_port", 0))
+	assert.Equal(t, "25", cfg.GetValue("MAIL.SMTP_PORT"))
+
+	ns.Set("smtp_host", "localhost")
+	var v GinExtConfig
+	cfg.DbInstance.Where("key", "MAIL.SMTP_HOST").Take(&v)
+	assert.Equal(t, "MAIL", v.Namespace)
+
+	var port GinExtConfig
+	cfg.DbInstance.Where("key", "MAIL.SMTP_PORT").Take(&port)
+	assert.Equal(t, "port of smtp server", port.Description)
+}
+
+func TestConfigChangeNotify(t *testing.T) {
+	cfg := NewGinExt("..")
+	cfg.Init()
+	configSyncInterval = 0
+	defer func() { configSyncInterval = time.Second }()
+
+	changes := map[string]string{}
+	sid := Sig().Connect(SigSettingChanged, func(sender interface{}, params ...interface{}) {
+		changes[params[0].(string)] = params[1].(string)
+	})
+	defer Sig().Disconnect(SigSettingChanged, sid)
+
+	cfg.SetValue("hello", "1")
+	assert.Equal(t, "1", changes["HELLO"])
+	assert.Equal(t, "1", cfg.GetValue("hello"))
+
+	// Changed by other instance, cache must be invalidated by the version
+	version, err := nextConfigVersion(cfg.DbInstance)
+	assert.Nil(t, err)
+	result := cfg.DbInstance.Model(&GinExtConfig{}).Where("key", "HELLO").UpdateColumns(map[string]interface{}{
+		"Value":   "2",
+		"Version": version,
+	})
+	assert.Nil(t, result.Error)
+	assert.Equal(t, "2", cfg.GetValue("hello"))
+	assert.Equal(t, "2", changes["HELLO"])
+
+	// the handler reads the values while syncing
+	reentered := ""
+	sid2 := Sig().Connect(SigSettingChanged, func(sender interface{}, params ...interface{}) {
+		reentered = cfg.GetValue("hello")
+	})
+	defer Sig().Disconnect(SigSettingChanged, sid2)
+	version, _ = nextConfigVersion(cfg.DbInstance)
+	cfg.DbInstance.Model(&GinExtConfig{}).Where("key", "HELLO").UpdateColumns(map[string]interface{}{
+		"Value":   "3",
+		"Version": version,
+	})
+	assert.Equal(t, "3", cfg.GetValue("hello"))
+	assert.Equal(t, "3", reentered)
+
+	v1, err := nextConfigVersion(cfg.DbInstance)
+	assert.Nil(t, err)
+	v2, _ := nextConfigVersion(cfg.DbInstance)
+	assert.Equal(t, v1+1, v2)
+}
+
+func TestConfigAdminHandler(t *testing.T) {
+	um, r := NewTestUserManager()
+	um.RegisterHandler("/auth", r)
+	um.ext.RegisterSettingsHandler("/settings", r)
+	um.ext.Namespace("mail").Define("smtp_host", "localhost", "host of smtp")
+
+	client := NewTestHTTPClient(r)
+	addUser(t, client, r, "bob", "bob@example.org", "hello123")
+
+	var listResult SettingListResult
+	form := SettingListForm{}
+	err := client.Call("/settings/list", &form, &listResult)
+	assert.NotNil(t, err)
+
+	bob, _ := um.Get("bob")
+	um.SetIsStaff(bob, true)
+	err = client.Call("/auth/login", &LoginForm{UserName: "bob", Password: "hello123"}, nil)
+	assert.Nil(t, err)
+
+	ns := "mail"
+	form.Namespace = &ns
+	err = client.Call("/settings/list", &form, &listResult)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, listResult.TotalCount)
+	assert.Equal(t, "MAIL.SMTP_HOST", listResult.Items[0].Key)
+
+	var item GinExtConfig
+	err = client.Call("/settings/edit", &SettingEditForm{Key: "mail.smtp_host", Value: "smtp.example.org"}, &item)
+	assert.Nil(t, err)
+	assert.Equal(t, "smtp.example.org", item.Value)
+	assert.Equal(t, "smtp.example.org", um.ext.Namespace("mail").Get("smtp_host"))
+}
@@ -3,6 +3,15 @@ package ginext
 const RpcFormField = "rpcform"
 const RpcResultField = "rpcresult"
 const RpcReduceDataField = "rpcreducedata"
+const RpcPathField = "rpcpath"
 const UserIdField = "userid"
 const UserMangerField = "ginext_um"
 const TokenField = "ginext_tk"
+const CsrfTokenField = "ginext_csrf"
+const RequestIDField = "ginext_reqid"
+const LoggerField = "ginext_logger"
+const LocaleField = "ginext_locale"
+const WebAuthnUserField = "ginext_webauthn"
+const SessionVersionField = "ginext_sv"
+const ImpersonatorField = "ginext_impersonator"
+const OrgIdField = "ginext_org"
@@ -17,6 +17,23 @@ func ListObjectEx(c *gin.Context, tx *gorm.DB, r interface{}, form *PaginationFo
 	if c == nil {
 		return
 	}
+	tx = requestDB(c, tx)
+
+	rv := reflect.ValueOf(r)
+	if rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	// scope the items by the current organization
+	itemType := rv.FieldByName("Items").Type().Elem()
+	if itemType.Kind() != reflect.Ptr {
+		itemType = reflect.PtrTo(itemType)
+	}
+	tx, _, ok := orgScope(c, tx, reflect.New(itemType.Elem()).Interface())
+	if !ok {
+		RpcAbort(c, ErrOrgRequired)
+		return
+	}
+
 	if form != nil {
 		if len(form.GetKeyword()) > 0 {
 			sc := strings.Count(searchKey, "?")
@@ -37,10 +54,6 @@ func ListObjectEx(c *gin.Context, tx *gorm.DB, r interface{}, form *PaginationFo
 		return
 	}
 
-	rv := reflect.ValueOf(r)
-	if rv.Kind() == reflect.Ptr {
-		rv = rv.Elem()
-	}
 	rv.FieldByName("TotalCount").SetInt(tc)
 	items := rv.FieldByName("Items").Addr().Interface()
 
@@ -73,6 +86,16 @@ func ListObjectEx(c *gin.Context, tx *gorm.DB, r interface{}, form *PaginationFo
 }
 
 func NewObject(c *gin.Context, db *gorm.DB, modPtr interface{}) {
+	db = requestDB(c, db)
+	if _, orgID, ok := orgScope(c, db, modPtr); !ok {
+		RpcAbort(c, ErrOrgRequired)
+		return
+	} else if orgID > 0 {
+		if err := setOrgColumn(db, modPtr, orgID); err != nil {
+			RpcError(c, err)
+			return
+		}
+	}
 	result := db.Create(modPtr)
 	if result.Error != nil {
 		if c != nil {
@@ -80,6 +103,13 @@ func NewObject(c *gin.Context, db *gorm.DB, modPtr interface{}) {
 		}
 		return
 	}
+	if _, ok := modPtr.(Auditable); ok {
+		var ID uint
+		if idField := reflect.ValueOf(modPtr).Elem().FieldByName("ID"); idField.Kind() == reflect.Uint {
+			ID = uint(idField.Uint())
+		}
+		auditObject(c, db, modPtr, AuditObjectCreate, ID, gin.H{"new": modPtr})
+	}
 	if c != nil {
 		RpcOk(c, reflect.ValueOf(modPtr).Elem().Interface())
 	}
@@ -87,12 +117,24 @@ func NewObject(c *gin.Context, db *gorm.DB, modPtr interface{}) {
 
 func DeleteObject(c *gin.Context, db *gorm.DB, modPtr interface{}, ID uint, markDelete bool) {
 	var result *gorm.DB
+	db, _, ok := orgScope(c, requestDB(c, db), modPtr)
+	if !ok {
+		RpcAbort(c, ErrOrgRequired)
+		return
+	}
+	_, audited := modPtr.(Auditable)
+	if audited {
+		db.Take(modPtr, ID)
+	}
 	tx := db.Model(modPtr).Where("id", ID)
 	if markDelete {
 		result = tx.UpdateColumn("Deleted", true)
 	} else {
 		result = tx.Delete(modPtr)
 	}
+	if audited && result.Error == nil && result.RowsAffected > 0 {
+		auditObject(c, db, modPtr, AuditObjectDelete, ID, gin.H{"old": modPtr, "markDelete": markDelete})
+	}
 
 	if c == nil {
 		return
@@ -111,7 +153,24 @@ func DeleteObject(c *gin.Context, db *gorm.DB, modPtr interface{}, ID uint, mark
 }
 
 func EditObject(c *gin.Context, db *gorm.DB, modPtr interface{}, ID uint, vals map[string]interface{}) {
+	db, orgID, ok := orgScope(c, requestDB(c, db), modPtr)
+	if !ok {
+		RpcAbort(c, ErrOrgRequired)
+		return
+	}
+	if orgID > 0 {
+		// the object is not movable to other organizations
+		deleteOrgColumn(db, modPtr, vals)
+	}
+	var changes map[string]AuditChange
+	_, audited := modPtr.(Auditable)
+	if audited && db.Take(modPtr, ID).Error == nil {
+		changes = auditChanges(db, modPtr, vals)
+	}
 	result := db.Model(modPtr).Where("id", ID).Updates(vals)
+	if audited && result.Error == nil && result.RowsAffected > 0 {
+		auditObject(c, db, modPtr, AuditObjectEdit, ID, changes)
+	}
 	if c == nil {
 		return
 	}
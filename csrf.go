@@ -0,0 +1,129 @@
+package ginext
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Token kept in the server side session, compared with the request header
+	CsrfModeSession = "session"
+	// Signed token kept in a readable cookie, the client echoes it via header
+	CsrfModeCookie = "cookie"
+)
+
+const CsrfHeaderName = "X-CSRF-Token"
+const CsrfCookieName = "csrf_token"
+const ApiCsrfTokenUri = "/csrf/token"
+
+const defaultCsrfTokenLength = 32
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+func (cfg *GinExt) signCsrfToken(val string) string {
+	mac := hmac.New(sha256.New, []byte(cfg.SessionSecret))
+	mac.Write([]byte(val))
+	return val + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (cfg *GinExt) checkCsrfSignature(token string) bool {
+	vals := strings.SplitN(token, ".", 2)
+	if len(vals) != 2 || len(vals[0]) <= 0 {
+		return false
+	}
+	return hmac.Equal([]byte(cfg.signCsrfToken(vals[0])), []byte(token))
+}
+
+// CsrfToken returns the csrf token of current client, a new token is issued if not exists
+func CsrfToken(c *gin.Context) string {
+	cfg := c.MustGet(ConfigField).(*GinExt)
+	if cfg.CsrfMode == CsrfModeCookie {
+		if token, err := c.Cookie(CsrfCookieName); err == nil && cfg.checkCsrfSignature(token) {
+			return token
+		}
+		token := cfg.signCsrfToken(RandText(defaultCsrfTokenLength))
+		c.SetSameSite(http.SameSiteStrictMode)
+		c.SetCookie(CsrfCookieName, token, 0, "/", "", false, false)
+		return token
+	}
+
+	session := sessions.Default(c)
+	if token, ok := session.Get(CsrfTokenField).(string); ok && len(token) > 0 {
+		return token
+	}
+	token := RandText(defaultCsrfTokenLength)
+	session.Set(CsrfTokenField, token)
+	session.Save()
+	return token
+}
+
+// VerifyCsrfToken check the X-CSRF-Token header of unsafe methods.
+// Safe methods, requests authenticated by a valid accesstoken, or csrf disabled always pass.
+func VerifyCsrfToken(c *gin.Context) bool {
+	return verifyCsrfToken(c, isSafeMethod(c.Request.Method))
+}
+
+// verifyCsrfToken the safe request is not checked, e.g. the GET of rpc is unsafe unless declared by WithMethods
+func verifyCsrfToken(c *gin.Context, safe bool) bool {
+	obj, ok := c.Get(ConfigField)
+	if !ok || obj == nil {
+		return true
+	}
+	cfg := obj.(*GinExt)
+	if !cfg.CsrfEnabled || safe || CurrentToken(c) != nil {
+		return true
+	}
+
+	header := c.GetHeader(CsrfHeaderName)
+	if len(header) <= 0 {
+		return false
+	}
+
+	var expected string
+	if cfg.CsrfMode == CsrfModeCookie {
+		token, err := c.Cookie(CsrfCookieName)
+		if err != nil || !cfg.checkCsrfSignature(token) {
+			return false
+		}
+		expected = token
+	} else {
+		token, ok := sessions.Default(c).Get(CsrfTokenField).(string)
+		if !ok || len(token) <= 0 {
+			return false
+		}
+		expected = token
+	}
+	return subtle.ConstantTimeCompare([]byte(header), []byte(expected)) == 1
+}
+
+// CsrfMiddleware protect the routes which not defined with RpcDefine
+func CsrfMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !VerifyCsrfToken(c) {
+			abortCsrfFail(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+func abortCsrfFail(c *gin.Context) {
+	RpcAbort(c, ErrCsrfFail)
+}
+
+func handleCsrfToken(c *gin.Context) {
+	RpcOk(c, CsrfToken(c))
+}
@@ -0,0 +1,143 @@
+package ginext
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCsrfEngine(mode string) (*UserManager, *gin.Engine) {
+	cfg := NewGinExt("..")
+	cfg.CsrfEnabled = true
+	cfg.CsrfMode = mode
+	cfg.Init()
+
+	um := NewUserManager(cfg)
+	um.Init()
+	r := gin.Default()
+	cfg.WithGinExt(r)
+	um.RegisterHandler("/auth", r)
+	return um, r
+}
+
+func postWithCsrf(client *TestHTTPClient, path string, form interface{}, token string) *http.Response {
+	body, _ := json.Marshal(form)
+	req, _ := http.NewRequest("POST", path, bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	if len(token) > 0 {
+		req.Header.Set(CsrfHeaderName, token)
+	}
+	return client.SendReq(path, req).Result()
+}
+
+func TestCsrfSession(t *testing.T) {
+	um, r := newTestCsrfEngine(CsrfModeSession)
+	um.Create("bob", "bob@example.org", "123456")
+	client := NewTestHTTPClient(r)
+	form := LoginForm{UserName: "bob", Password: "123456"}
+
+	resp := postWithCsrf(client, "/auth/login", &form, "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	var token string
+	w := client.Get(ApiCsrfTokenUri)
+	result := client.CheckResponse(t, w)
+	token = result["data"].(string)
+	assert.NotEmpty(t, token)
+
+	resp = postWithCsrf(client, "/auth/login", &form, "bad-token")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp = postWithCsrf(client, "/auth/login", &form, token)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// token keep the same in one session
+	w = client.Get(ApiCsrfTokenUri)
+	result = client.CheckResponse(t, w)
+	assert.Equal(t, token, result["data"])
+
+	resp = postWithCsrf(client, "/auth/password/change", &PasswordChangeForm{OldPassword: "123456", Password: "world789"}, "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestCsrfCookie(t *testing.T) {
+	um, r := newTestCsrfEngine(CsrfModeCookie)
+	um.Create("bob", "bob@example.org", "123456")
+	client := NewTestHTTPClient(r)
+	form := LoginForm{UserName: "bob", Password: "123456"}
+
+	w := client.Get(ApiCsrfTokenUri)
+	result := client.CheckResponse(t, w)
+	token := result["data"].(string)
+	assert.NotEmpty(t, token)
+
+	resp := postWithCsrf(client, "/auth/login", &form, token+"x")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp = postWithCsrf(client, "/auth/login", &form, token)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// forged cookie without valid signature
+	other := NewTestHTTPClient(r)
+	req, _ := http.NewRequest("POST", "/auth/login", bytes.NewBufferString(`{"username":"bob","password":"123456"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(CsrfHeaderName, "forged.token")
+	req.AddCookie(&http.Cookie{Name: CsrfCookieName, Value: "forged.token"})
+	w = other.SendReq("/auth/login", req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestCsrfBearerExempt(t *testing.T) {
+	um, r := newTestCsrfEngine(CsrfModeSession)
+	bob, _ := um.Create("bob", "bob@example.org", "123456")
+	token, err := um.MakeToken(bob)
+	assert.Nil(t, err)
+
+	body, _ := json.Marshal(PasswordChangeForm{OldPassword: "123456", Password: "world789"})
+	req, _ := http.NewRequest("POST", "/auth/password/change", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+	w := NewTestHTTPClient(r).SendReq("/auth/password/change", req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	_, err = um.Auth("bob", "world789")
+	assert.Nil(t, err)
+}
+
+func TestCsrfBearerNotAuthenticated(t *testing.T) {
+	um, r := newTestCsrfEngine(CsrfModeSession)
+	bob, _ := um.Create("bob", "bob@example.org", "123456")
+	token, _ := um.MakeToken(bob)
+	// the bearer header is ignored when the token authorization is off
+	um.EnabledTokenAuthorization = false
+
+	body, _ := json.Marshal(PasswordChangeForm{OldPassword: "123456", Password: "world789"})
+	req, _ := http.NewRequest("POST", "/auth/password/change", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+	w := NewTestHTTPClient(r).SendReq("/auth/password/change", req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestCsrfRpcGet(t *testing.T) {
+	_, r := newTestCsrfEngine(CsrfModeSession)
+	Rpc(r, "/ping/default", func(c *gin.Context, form *struct{}) (string, error) {
+		return "pong", nil
+	})
+	Rpc(r, "/ping/get", func(c *gin.Context, form *struct{}) (string, error) {
+		return "pong", nil
+	}, WithMethods("GET"))
+	client := NewTestHTTPClient(r)
+	// the default GET is the alias of POST
+	assert.Equal(t, http.StatusForbidden, client.Get("/ping/default").Code)
+	assert.Equal(t, http.StatusOK, client.Get("/ping/get").Code)
+
+	// the magic link is opened from the mail
+	w := client.Get("/auth/login/link/verify?token=a.b.c")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "bad magic link")
+}
@@ -1,14 +1,13 @@
 package ginext
 
 import (
-	"encoding/json"
+	"flag"
 	"io"
-	"io/ioutil"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"time"
 
@@ -17,8 +16,6 @@ import (
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
-
-	lru "github.com/hashicorp/golang-lru"
 )
 
 const (
@@ -33,31 +30,56 @@ const (
 	ConfigField = "ginext_cfg"
 )
 
-var configValueCache *lru.Cache
-var configCacheExpired time.Duration = 10 * time.Second
-
-type configCacheItem struct {
-	n   time.Time
-	val string
-}
-
-func init() {
-	configValueCache, _ = lru.New(512) // fixed size
-}
-
 // GinExt Config and core info
 type GinExt struct {
-	AppDir   string `json:"-"`
-	AssetDir string `json:"-"`
-	ConfDir  string `json:"-"`
-	ConfFile string `json:"-"`
-	LogFile  string `json:"log_file"`
+	AppDir      string `json:"-"`
+	AssetDir    string `json:"-"`
+	ConfDir     string `json:"-"`
+	ConfFile    string `json:"-"`
+	SettingsEnv string `json:"-"`
+	LogFile     string `json:"log_file"`
+	// text or json
+	LogFormat string `json:"log_format"`
+	LogLevel  string `json:"log_level"`
+	// Rotate the log file when exceed, in MB
+	LogMaxSize int `json:"log_max_size"`
+	// Remove the rotated files older than, in days
+	LogMaxAge     int `json:"log_max_age"`
+	LogMaxBackups int `json:"log_max_backups"`
 
 	PasswordSalt  string `json:"password_salt"`
 	SessionSecret string `json:"session_secret"`
 	SessionStore  string `json:"session_store"`
 	SessionName   string `json:"session_name"`
 
+	CsrfEnabled bool   `json:"csrf_enabled"`
+	CsrfMode    string `json:"csrf_mode"`
+
+	MetricsEnabled bool `json:"metrics_enabled"`
+
+	// Send the RpcErr with the http status of the registered code, default is http 200
+	RpcErrStatus bool `json:"rpc_err_status"`
+
+	// OTLP/HTTP endpoint, e.g. localhost:4318
+	TraceEndpoint    string `json:"trace_endpoint"`
+	TraceInsecure    bool   `json:"trace_insecure"`
+	TraceServiceName string `json:"trace_service_name"`
+
+	// The locale when the request not negotiated, e.g. en, zh-CN
+	DefaultLocale string `json:"default_locale"`
+
+	// smtp, file or memory, the mails are not sent when empty
+	MailDriver string `json:"mail_driver"`
+	MailFrom   string `json:"mail_from"`
+	// The dir of file driver, default is <AppDir>/mails
+	MailDir      string `json:"mail_dir"`
+	SmtpHost     string `json:"smtp_host"`
+	SmtpPort     int    `json:"smtp_port"`
+	SmtpUsername string `json:"smtp_username"`
+	SmtpPassword string `json:"smtp_password"`
+	// starttls, tls or none
+	SmtpTLS string `json:"smtp_tls"`
+
 	DbDriver  string `json:"db_driver"`
 	DbDSN     string `json:"db_dsn"`
 	ServeAddr string `json:"serve_addr"`
@@ -65,6 +87,15 @@ type GinExt struct {
 	DbInstance   *gorm.DB       `json:"-"`
 	sessionStore sessions.Store `json:"-"`
 	LogWriter    io.Writer      `json:"-"`
+	Logger       *slog.Logger   `json:"-"`
+	Metrics      *Metrics       `json:"-"`
+	// The docs of rpc defined by the handlers of GinExt, and the routers bound by Registry.Router
+	Registry *RpcRegistry `json:"-"`
+	// The builtin messages, and the files in AssetDir/locales
+	Catalog *Catalog      `json:"-"`
+	flagSet *flag.FlagSet `json:"-"`
+	// The validator of rpc forms, the tags by RegisterValidator
+	validators *formValidators `json:"-"`
 }
 
 func HintRootDir(conf string) string {
@@ -93,17 +124,27 @@ func NewGinExt(appDir string) *GinExt {
 		AssetDir:      filepath.Join(appDir, "assets"),
 		ConfDir:       filepath.Join(appDir, "conf"),
 		ConfFile:      filepath.Join(appDir, "conf/settings.json"),
+		SettingsEnv:   os.Getenv(SettingsEnvName),
 		LogFile:       "",
+		LogFormat:     LogFormatText,
+		LogLevel:      "info",
 		PasswordSalt:  "",
-		SessionSecret: "ginext-session-secret",
+		SessionSecret: defaultSessionSecret,
 		SessionStore:  "cookie",
 		SessionName:   "ginsession",
+		CsrfEnabled:   false,
+		CsrfMode:      CsrfModeSession,
 		DbDriver:      "sqlite",
 		DbDSN:         "file::memory:",
 		ServeAddr:     ":8080",
 		LogWriter:     os.Stdout,
+		DefaultLocale: DefaultLocale,
+		Catalog:       NewBuiltinCatalog(),
+		Registry:      NewRpcRegistry(),
+		validators:    newFormValidators(),
 	}
-	configValueCache, _ = lru.New(512)
+	cfg.Logger = NewLogger(cfg.LogWriter, cfg.LogFormat, cfg.LogLevel)
+	resetConfigCache()
 	return cfg
 }
 
@@ -117,45 +158,27 @@ func (c *GinExt) FilePath(path string) string {
 	return filepath.Join(c.AppDir, path)
 }
 
-func (c *GinExt) LoadSettings(isMigrate bool) {
-	log.Default().SetFlags(log.Lshortfile | log.Ltime | log.Ldate)
-
-	confData, err := ioutil.ReadFile(c.ConfFile)
-	if err != nil {
-		log.Printf("load conf fail %s - %v", c.ConfFile, err)
-		return
-	}
-	err = json.Unmarshal(confData, c)
+func (c *GinExt) Init() (err error) {
+	registerValidators()
+	err = c.Validate()
 	if err != nil {
-		log.Printf("load conf fail %s - %v", c.ConfFile, err)
-	} else {
-		log.Println("Load done ", c.ConfFile)
+		return err
 	}
 
-	if len(c.LogFile) > 0 && !isMigrate {
-		gin.DisableConsoleColor()
-		logFile, err := os.OpenFile(c.LogFile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
-		if err != nil {
-			log.Panic(err)
-		}
-		c.LogWriter = io.MultiWriter(logFile)
-		log.SetOutput(logFile)
-		gin.DefaultWriter = c.LogWriter
-	}
-}
-
-func (c *GinExt) Init() (err error) {
-
 	if len(c.DbDSN) > 0 {
 		err = c.initDB()
 		if err != nil {
-			return nil
+			return err
 		}
 	}
 
 	if len(c.SessionStore) > 0 {
 		c.sessionStore = cookie.NewStore([]byte(c.SessionSecret))
 	}
+
+	if st, e := os.Stat(filepath.Join(c.AssetDir, "locales")); e == nil && st.IsDir() {
+		err = c.Catalog.LoadDir(filepath.Join(c.AssetDir, "locales"))
+	}
 	return err
 }
 
@@ -164,18 +187,8 @@ func (c *GinExt) IsUnitTest() bool {
 }
 
 func (c *GinExt) initDB() (err error) {
-	newLogger := logger.New(
-		log.New(c.LogWriter, "\r\n", log.LstdFlags), // io writer
-		logger.Config{
-			SlowThreshold:             time.Second, // Slow SQL threshold
-			LogLevel:                  logger.Warn, // Log level
-			IgnoreRecordNotFoundError: true,        // Ignore ErrRecordNotFound error for logger
-			Colorful:                  false,       // Disable color
-		},
-	)
-
 	cfg := &gorm.Config{
-		Logger:                 newLogger,
+		Logger:                 newGormLogger(c, logger.Warn, time.Second),
 		SkipDefaultTransaction: true,
 	}
 
@@ -183,10 +196,15 @@ func (c *GinExt) initDB() (err error) {
 	if err != nil {
 		log.Panicf("connect db fail %v", err)
 	}
-	err = c.DbInstance.AutoMigrate(&GinExtConfig{})
+	err = c.DbInstance.Use(&TracingPlugin{})
+	if err != nil {
+		return err
+	}
+	err = c.DbInstance.AutoMigrate(&GinExtConfig{}, &GinExtConfigVersion{})
 	if err != nil {
 		log.Panicf("Migrate GinExtConfig Fail %v", err)
 	}
+	initConfigVersion(c.DbInstance)
 	return nil
 }
 
@@ -195,7 +213,7 @@ func CORSMiddleware() gin.HandlerFunc {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, PATCH, DELETE")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(http.StatusNoContent) // 204
@@ -209,93 +227,29 @@ func CORSMiddleware() gin.HandlerFunc {
 // Init Gin middleware
 func (cfg *GinExt) WithGinExt(r *gin.Engine) {
 	gin.DebugPrintRouteFunc = func(httpMethod, absolutePath, handlerName string, nuHandlers int) {}
+	registerValidators()
 	r.Use(sessions.Sessions(cfg.SessionName, cfg.sessionStore))
+	r.Use(cfg.requestLogger())
 	r.Use(CORSMiddleware())
 
 	r.Use(func(c *gin.Context) {
 		c.Set(ConfigField, cfg)
-		c.Set(DBField, cfg.DbInstance)
-		c.Next()
-	})
-
-	if gin.Mode() != gin.ReleaseMode {
-		registerDocHandler(r)
-	}
-}
-
-func GetValueEx(db *gorm.DB, key string) string {
-	cobj, ok := configValueCache.Get(key)
-	if ok {
-		if time.Since(cobj.(*configCacheItem).n) < configCacheExpired {
-			return cobj.(*configCacheItem).val
+		// the db is not connected without DbDSN
+		if cfg.DbInstance != nil {
+			c.Set(DBField, cfg.DbInstance.WithContext(c.Request.Context()))
 		}
-	}
-
-	var v GinExtConfig
-	newKey := strings.ToUpper(key)
-	result := db.Where("key", newKey).Take(&v)
-	if result.Error != nil {
-		return ""
-	}
-
-	configValueCache.Add(key, &configCacheItem{
-		n:   time.Now(),
-		val: v.Value,
+		c.Next()
 	})
-	return v.Value
-}
-
-func GetInt64ValueEx(db *gorm.DB, key string, defaultVal int64) int64 {
-	v := GetValueEx(db, key)
-	if v == "" {
-		return defaultVal
-	}
-	val, err := strconv.ParseInt(v, 10, 64)
-	if err != nil {
-		return defaultVal
-	}
-	return val
-}
 
-func GetIntValueEx(db *gorm.DB, key string, defaultVal int) int {
-	v := GetValueEx(db, key)
-	if v == "" {
-		return defaultVal
+	if cfg.CsrfEnabled {
+		r.GET(ApiCsrfTokenUri, handleCsrfToken)
 	}
-	val, err := strconv.ParseInt(v, 10, 64)
-	if err != nil {
-		return defaultVal
-	}
-	return int(val)
-}
-
-func SetValueEx(db *gorm.DB, key, value string) {
-	newKey := strings.ToUpper(key)
-	configValueCache.Remove(newKey)
 
-	var v GinExtConfig
-	result := db.Where("key", newKey).Take(&v)
-	if result.Error != nil {
-		newV := &GinExtConfig{
-			Key:   newKey,
-			Value: value,
-		}
-		db.Create(&newV)
-		return
+	if cfg.MetricsEnabled {
+		cfg.EnableMetrics(r)
 	}
-	db.Model(&GinExtConfig{}).Where("key", newKey).UpdateColumn("value", value)
-}
-
-func (cfg *GinExt) GetValue(key string) string {
-	return GetValueEx(cfg.DbInstance, key)
-}
 
-func (cfg *GinExt) CheckValue(key, defaultValue string) {
-	if len(cfg.GetValue(key)) <= 0 {
-		cfg.SetValue(key, defaultValue)
+	if gin.Mode() != gin.ReleaseMode {
+		cfg.Registry.RegisterDocHandler(r, "/docs")
 	}
 }
-
-func (cfg *GinExt) SetValue(key, value string) {
-	SetValueEx(cfg.DbInstance, key, value)
-}
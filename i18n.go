@@ -0,0 +1,276 @@
+package ginext
+
+import (
+	"bufio"
+	"embed"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const DefaultLocale = "en"
+
+//go:embed assets/locales
+var builtinLocales embed.FS
+
+// Catalog the messages by locale and key, the locale is like zh-CN, en
+type Catalog struct {
+	mu       sync.RWMutex
+	messages map[string]map[string]string
+}
+
+func NewCatalog() *Catalog {
+	return &Catalog{messages: map[string]map[string]string{}}
+}
+
+// NewBuiltinCatalog the catalog with the builtin messages of ginext
+func NewBuiltinCatalog() *Catalog {
+	cat := NewCatalog()
+	if err := cat.LoadFS(builtinLocales, "assets/locales"); err != nil {
+		panic(err)
+	}
+	return cat
+}
+
+// normalizeLocale e.g. zh_cn => zh-cn, the locales are compared in lower case
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+}
+
+func (cat *Catalog) Add(locale, key, msg string) {
+	cat.AddMessages(locale, map[string]string{key: msg})
+}
+
+func (cat *Catalog) AddMessages(locale string, messages map[string]string) {
+	locale = normalizeLocale(locale)
+	cat.mu.Lock()
+	defer cat.mu.Unlock()
+	m, ok := cat.messages[locale]
+	if !ok {
+		m = map[string]string{}
+		cat.messages[locale] = m
+	}
+	for k, v := range messages {
+		m[k] = v
+	}
+}
+
+// LoadDir load the <locale>.json and <locale>.po files in dir, e.g. zh-CN.json
+func (cat *Catalog) LoadDir(dir string) error {
+	return cat.LoadFS(os.DirFS(dir), ".")
+}
+
+func (cat *Catalog) LoadFS(fsys fs.FS, dir string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		ext := path.Ext(entry.Name())
+		locale := strings.TrimSuffix(entry.Name(), ext)
+		if ext != ".json" && ext != ".po" {
+			continue
+		}
+
+		f, err := fsys.Open(path.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		var messages map[string]string
+		if ext == ".json" {
+			err = json.NewDecoder(f).Decode(&messages)
+		} else {
+			messages, err = parsePO(f)
+		}
+		f.Close()
+		if err != nil {
+			return errors.New(entry.Name() + ": " + err.Error())
+		}
+		cat.AddMessages(locale, messages)
+	}
+	return nil
+}
+
+// parsePO read the msgid and msgstr of gettext po file, the empty msgstr are skipped
+func parsePO(r io.Reader) (map[string]string, error) {
+	messages := map[string]string{}
+	var msgid, msgstr *string
+	var current *string
+	flush := func() {
+		if msgid != nil && msgstr != nil && len(*msgid) > 0 && len(*msgstr) > 0 {
+			messages[*msgid] = *msgstr
+		}
+		msgid, msgstr, current = nil, nil, nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		var quoted string
+		switch {
+		case len(line) <= 0 || strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "msgid "):
+			flush()
+			msgid = new(string)
+			current = msgid
+			quoted = strings.TrimPrefix(line, "msgid ")
+		case strings.HasPrefix(line, "msgstr "):
+			msgstr = new(string)
+			current = msgstr
+			quoted = strings.TrimPrefix(line, "msgstr ")
+		case strings.HasPrefix(line, `"`) && current != nil:
+			quoted = line
+		default:
+			continue
+		}
+		val, err := strconv.Unquote(quoted)
+		if err != nil {
+			return nil, errors.New("line " + strconv.Itoa(lineNo) + ": " + err.Error())
+		}
+		*current += val
+	}
+	flush()
+	return messages, scanner.Err()
+}
+
+// Lookup the message of locale, then the base language, e.g. zh-CN => zh
+func (cat *Catalog) Lookup(locale, key string) (string, bool) {
+	locale = normalizeLocale(locale)
+	cat.mu.RLock()
+	defer cat.mu.RUnlock()
+	if msg, ok := cat.messages[locale][key]; ok {
+		return msg, true
+	}
+	if idx := strings.Index(locale, "-"); idx > 0 {
+		if msg, ok := cat.messages[locale[:idx]][key]; ok {
+			return msg, true
+		}
+	}
+	return "", false
+}
+
+// Translate return fallback when the key not found
+func (cat *Catalog) Translate(locale, key, fallback string) string {
+	if msg, ok := cat.Lookup(locale, key); ok {
+		return msg
+	}
+	return fallback
+}
+
+// FormatData render the template of key in locale, see FormatData
+func (cat *Catalog) FormatData(locale, key string, ctx map[string]string, fallbackFmt string) string {
+	return FormatData(cat.Translate(locale, key, ""), ctx, fallbackFmt)
+}
+
+// Supported the locale or its base language has messages
+func (cat *Catalog) Supported(locale string) bool {
+	locale = normalizeLocale(locale)
+	cat.mu.RLock()
+	defer cat.mu.RUnlock()
+	if _, ok := cat.messages[locale]; ok {
+		return true
+	}
+	if idx := strings.Index(locale, "-"); idx > 0 {
+		_, ok := cat.messages[locale[:idx]]
+		return ok
+	}
+	return false
+}
+
+// MatchAcceptLanguage the supported locale of the Accept-Language header, by the q value
+func (cat *Catalog) MatchAcceptLanguage(header string) (string, bool) {
+	type acceptLocale struct {
+		locale string
+		q      float64
+	}
+	var accepts []acceptLocale
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		locale := strings.TrimSpace(fields[0])
+		if len(locale) <= 0 || locale == "*" {
+			continue
+		}
+		q := 1.0
+		for _, f := range fields[1:] {
+			if v := strings.TrimSpace(f); strings.HasPrefix(v, "q=") {
+				q, _ = strconv.ParseFloat(v[2:], 64)
+			}
+		}
+		accepts = append(accepts, acceptLocale{locale, q})
+	}
+	sort.SliceStable(accepts, func(i, j int) bool {
+		return accepts[i].q > accepts[j].q
+	})
+	for _, v := range accepts {
+		if v.q > 0 && cat.Supported(v.locale) {
+			return v.locale, true
+		}
+	}
+	return "", false
+}
+
+// CurrentLocale negotiate the locale of request: the `Locale` field of rpc form,
+// the profile of current user, the Accept-Language header, then GinExt.DefaultLocale
+func CurrentLocale(c *gin.Context) string {
+	if locale := c.GetString(LocaleField); len(locale) > 0 {
+		return locale
+	}
+	locale := negotiateLocale(c)
+	c.Set(LocaleField, locale)
+	return locale
+}
+
+func negotiateLocale(c *gin.Context) string {
+	if form, ok := c.Get(RpcFormField); ok && form != nil {
+		rv := reflect.Indirect(reflect.ValueOf(form))
+		if rv.Kind() == reflect.Struct {
+			if f := rv.FieldByName("Locale"); f.IsValid() && f.Kind() == reflect.String && len(f.String()) > 0 {
+				return f.String()
+			}
+		}
+	}
+
+	if _, ok := c.Get(UserMangerField); ok {
+		if user := CurrentUser(c); user != nil {
+			var profile GinProfile
+			db := requestDB(c, c.MustGet(UserMangerField).(*UserManager).db)
+			if db.Where("user_id", user.ID).Take(&profile).Error == nil && len(profile.Locale) > 0 {
+				return profile.Locale
+			}
+		}
+	}
+
+	cfg := configOf(c)
+	if cfg != nil && cfg.Catalog != nil {
+		if locale, ok := cfg.Catalog.MatchAcceptLanguage(c.GetHeader("Accept-Language")); ok {
+			return locale
+		}
+	}
+	if cfg != nil && len(cfg.DefaultLocale) > 0 {
+		return cfg.DefaultLocale
+	}
+	return DefaultLocale
+}
+
+// Translate the message of key in the locale of request, return fallback when not found
+func Translate(c *gin.Context, key, fallback string) string {
+	cfg := configOf(c)
+	if cfg == nil || cfg.Catalog == nil || len(key) <= 0 {
+		return fallback
+	}
+	return cfg.Catalog.Translate(CurrentLocale(c), key, fallback)
+}
@@ -0,0 +1,136 @@
+package ginext
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCatalog(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "fr.json"), []byte(`{"hello": "Bonjour {{.Name}}"}`), 0644)
+	os.WriteFile(filepath.Join(dir, "ja_JP.po"), []byte(`# comment
+msgid ""
+msgstr "Content-Type: text/plain; charset=UTF-8\n"
+
+msgid "hello"
+msgstr ""
+"こんにちは "
+"{{.Name}}"
+
+msgid "untranslated"
+msgstr ""
+`), 0644)
+
+	cat := NewBuiltinCatalog()
+	assert.Nil(t, cat.LoadDir(dir))
+
+	msg, ok := cat.Lookup("ja-JP", "hello")
+	assert.True(t, ok)
+	assert.Equal(t, "こんにちは {{.Name}}", msg)
+	_, ok = cat.Lookup("ja-JP", "untranslated")
+	assert.False(t, ok)
+
+	// base language
+	assert.Equal(t, "Bonjour bob", cat.FormatData("fr-CA", "hello", map[string]string{"Name": "bob"}, "Hello {{.Name}}"))
+	assert.Equal(t, "Hello bob", cat.FormatData("de", "hello", map[string]string{"Name": "bob"}, "Hello {{.Name}}"))
+	assert.Equal(t, "用户名已存在", cat.Translate("zh_CN", "username_exists", ""))
+
+	locale, ok := cat.MatchAcceptLanguage("de-DE,de;q=0.9,fr;q=0.5,zh-CN;q=0.8")
+	assert.True(t, ok)
+	assert.Equal(t, "zh-CN", locale)
+	_, ok = cat.MatchAcceptLanguage("de-DE,*;q=0.1")
+	assert.False(t, ok)
+}
+
+type testAgeForm struct {
+	Age     int  `json:"age" binding:"min=18"`
+	Default bool `json:"default"`
+}
+
+func TestRpcFailTranslated(t *testing.T) {
+	um, r := NewTestUserManager()
+	um.RegisterHandler("/auth", r)
+	client := NewTestHTTPClient(r)
+	addUser(t, client, r, "bob", "bob@example.org", "hello123")
+
+	post := func(path string, form map[string]interface{}, acceptLanguage string) (body RpcErr) {
+		data, _ := json.Marshal(form)
+		req, _ := http.NewRequest("POST", path, strings.NewReader(string(data)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept-Language", acceptLanguage)
+		w := client.SendReq(path, req)
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return body
+	}
+
+	form := map[string]interface{}{"email": "alice@example.org", "password": "hello123", "username": "bob"}
+	body := post("/auth/register", form, "zh-CN,zh;q=0.9")
+	assert.Equal(t, ErrCodeUsernameExists, body.Code)
+	assert.Equal(t, "用户名已存在", body.Msg)
+
+	body = post("/auth/register", form, "en-US")
+	assert.Equal(t, "username is exists", body.Msg)
+
+	// the locale of form first
+	form["locale"] = "zh-CN"
+	body = post("/auth/register", form, "en-US")
+	assert.Equal(t, "用户名已存在", body.Msg)
+
+	body = post("/auth/register", map[string]interface{}{"email": "alice@example.org"}, "zh-CN")
+	assert.Equal(t, []string{"不能为空"}, body.Fields["password"])
+
+	// the explicit msg is not replaced by the translation of key
+	RpcDefine(r, &RpcContext{
+		Form:         testAgeForm{},
+		RelativePath: "/mockapi/fail",
+		Handler: func(c *gin.Context) {
+			form := c.MustGet(RpcFormField).(*testAgeForm)
+			if form.Default {
+				RpcAbort(c, NewRpcErr(ErrCodeInvalidParams, ""))
+				return
+			}
+			RpcAbort(c, NewRpcErr(ErrCodeInvalidParams, "token build fail"))
+		},
+	})
+	body = post("/mockapi/fail", map[string]interface{}{"age": 18}, "zh-CN")
+	assert.Equal(t, "token build fail", body.Msg)
+	body = post("/mockapi/fail", map[string]interface{}{"age": 18, "default": true}, "zh-CN")
+	assert.Equal(t, "参数错误", body.Msg)
+	body = post("/mockapi/fail", map[string]interface{}{"age": 10}, "zh-CN")
+	assert.Equal(t, []string{"不能小于 18"}, body.Fields["age"])
+}
+
+func TestCurrentLocaleProfile(t *testing.T) {
+	um, r := NewTestUserManager()
+	um.RegisterHandler("/auth", r)
+	RpcDefine(r, &RpcContext{
+		AuthRequired: true,
+		RelativePath: "/mockapi/locale",
+		Handler: func(c *gin.Context) {
+			RpcOk(c, CurrentLocale(c))
+		},
+	})
+	client := NewTestHTTPClient(r)
+	addUser(t, client, r, "bob", "bob@example.org", "hello123")
+	var info UserInfoResult
+	err := client.Call("/auth/login", LoginForm{UserName: "bob", Password: "hello123"}, &info)
+	assert.Nil(t, err)
+
+	var locale string
+	err = client.Call("/mockapi/locale", nil, &locale)
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultLocale, locale)
+
+	bob, _ := um.GetByEmail("bob@example.org")
+	UpdateProfile(um.db, bob.ID, &GinProfile{UserID: bob.ID, Locale: "zh-CN"})
+	err = client.Call("/mockapi/locale", nil, &locale)
+	assert.Nil(t, err)
+	assert.Equal(t, "zh-CN", locale)
+}
@@ -0,0 +1,327 @@
+package ginext
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+const maxRequestIDLength = 128
+const defaultRequestIDLength = 20
+
+type loggerContextKey struct{}
+
+// NewLogger create the slog logger, format is text or json, level is debug/info/warn/error
+func NewLogger(w io.Writer, format, level string) *slog.Logger {
+	var lv slog.Level
+	if err := lv.UnmarshalText([]byte(level)); err != nil {
+		lv = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{Level: lv}
+	if strings.ToLower(format) == LogFormatJSON {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// WithLogger return the context carried the logger, the gorm sql log use it
+func WithLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey{}, l)
+}
+
+// LoggerFromContext return the logger in context, or slog.Default()
+func LoggerFromContext(ctx context.Context) *slog.Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(loggerContextKey{}).(*slog.Logger); ok && l != nil {
+			return l
+		}
+	}
+	return slog.Default()
+}
+
+// CurrentLogger return the request scoped logger, with request_id field
+func CurrentLogger(c *gin.Context) *slog.Logger {
+	if obj, ok := c.Get(LoggerField); ok && obj != nil {
+		return obj.(*slog.Logger)
+	}
+	if obj, ok := c.Get(ConfigField); ok && obj != nil {
+		return obj.(*GinExt).Logger
+	}
+	return slog.Default()
+}
+
+// CurrentRequestID return the X-Request-ID of current request
+func CurrentRequestID(c *gin.Context) string {
+	return c.GetString(RequestIDField)
+}
+
+func (c *GinExt) initLogger(isMigrate bool) error {
+	if len(c.LogFile) > 0 && !isMigrate {
+		gin.DisableConsoleColor()
+		w, err := NewRotateWriter(c.LogFile, c.LogMaxSize, c.LogMaxAge, c.LogMaxBackups)
+		if err != nil {
+			return err
+		}
+		c.LogWriter = w
+		gin.DefaultWriter = c.LogWriter
+	}
+	c.Logger = NewLogger(c.LogWriter, c.LogFormat, c.LogLevel)
+	// the log package output to the same handler
+	slog.SetDefault(c.Logger)
+	return nil
+}
+
+func currentUserID(c *gin.Context) interface{} {
+	if obj, ok := c.Get(UserIdField); ok && obj != nil {
+		return obj.(*GinExtUser).ID
+	}
+	if _, ok := c.Get(sessions.DefaultKey); ok {
+		return sessions.Default(c).Get(UserIdField)
+	}
+	return nil
+}
+
+func (cfg *GinExt) requestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		reqID := c.GetHeader(RequestIDHeader)
+		if len(reqID) <= 0 || len(reqID) > maxRequestIDLength {
+			reqID = RandText(defaultRequestIDLength)
+		}
+		c.Header(RequestIDHeader, reqID)
+		c.Set(RequestIDField, reqID)
+
+		l := cfg.Logger.With("request_id", reqID)
+		c.Set(LoggerField, l)
+		c.Request = c.Request.WithContext(WithLogger(c.Request.Context(), l))
+
+		c.Next()
+
+		status := c.Writer.Status()
+		attrs := []interface{}{
+			"method", c.Request.Method,
+			"route", c.FullPath(),
+			"path", c.Request.URL.Path,
+			"status", status,
+			"latency", time.Since(start),
+			"ip", c.ClientIP(),
+		}
+		if userID := currentUserID(c); userID != nil {
+			attrs = append(attrs, "user_id", userID)
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "error", c.Errors.String())
+		}
+
+		switch {
+		case status >= 500:
+			l.Error("request", attrs...)
+		case status >= 400:
+			l.Warn("request", attrs...)
+		default:
+			l.Info("request", attrs...)
+		}
+	}
+}
+
+// gormLogger write the sql log with slog, the request logger in context is preferred,
+// and then the Logger of GinExt
+type gormLogger struct {
+	ext           *GinExt
+	level         logger.LogLevel
+	slowThreshold time.Duration
+}
+
+func newGormLogger(ext *GinExt, level logger.LogLevel, slowThreshold time.Duration) logger.Interface {
+	return &gormLogger{ext: ext, level: level, slowThreshold: slowThreshold}
+}
+
+func (l *gormLogger) logger(ctx context.Context) *slog.Logger {
+	if ctx != nil {
+		if v, ok := ctx.Value(loggerContextKey{}).(*slog.Logger); ok && v != nil {
+			return v
+		}
+	}
+	if l.ext != nil && l.ext.Logger != nil {
+		return l.ext.Logger
+	}
+	return slog.Default()
+}
+
+func (l *gormLogger) LogMode(level logger.LogLevel) logger.Interface {
+	v := *l
+	v.level = level
+	return &v
+}
+
+func (l *gormLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= logger.Info {
+		l.logger(ctx).Info(fmt.Sprintf(msg, args...))
+	}
+}
+
+func (l *gormLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= logger.Warn {
+		l.logger(ctx).Warn(fmt.Sprintf(msg, args...))
+	}
+}
+
+func (l *gormLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= logger.Error {
+		l.logger(ctx).Error(fmt.Sprintf(msg, args...))
+	}
+}
+
+func (l *gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.level <= logger.Silent {
+		return
+	}
+	elapsed := time.Since(begin)
+	switch {
+	case err != nil && l.level >= logger.Error && !errors.Is(err, gorm.ErrRecordNotFound):
+		sql, rows := fc()
+		l.logger(ctx).Error("sql", "sql", sql, "rows", rows, "elapsed", elapsed, "error", err)
+	case l.slowThreshold > 0 && elapsed > l.slowThreshold && l.level >= logger.Warn:
+		sql, rows := fc()
+		l.logger(ctx).Warn("slow sql", "sql", sql, "rows", rows, "elapsed", elapsed)
+	case l.level >= logger.Info:
+		sql, rows := fc()
+		l.logger(ctx).Debug("sql", "sql", sql, "rows", rows, "elapsed", elapsed)
+	}
+}
+
+// RotateWriter rotate the log file by size, and remove the old files by age and count
+type RotateWriter struct {
+	FileName   string
+	MaxSize    int64
+	MaxAge     time.Duration
+	MaxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewRotateWriter maxSize in MB, maxAge in days, zero means unlimited
+func NewRotateWriter(fileName string, maxSize, maxAge, maxBackups int) (*RotateWriter, error) {
+	w := &RotateWriter{
+		FileName:   fileName,
+		MaxSize:    int64(maxSize) * 1024 * 1024,
+		MaxAge:     time.Duration(maxAge) * 24 * time.Hour,
+		MaxBackups: maxBackups,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *RotateWriter) open() error {
+	f, err := os.OpenFile(w.FileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file = f
+	w.size = st.Size()
+	return nil
+}
+
+func (w *RotateWriter) Write(p []byte) (n int, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+	if w.MaxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.MaxSize {
+		if err = w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err = w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Rotate force to rotate the log file
+func (w *RotateWriter) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rotate()
+}
+
+func (w *RotateWriter) rotate() error {
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+	backup := w.FileName + "." + time.Now().Format("20060102-150405.000000")
+	if err := os.Rename(w.FileName, backup); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+	w.removeBackups()
+	return nil
+}
+
+func (w *RotateWriter) backups() []string {
+	files, _ := filepath.Glob(w.FileName + ".*")
+	sort.Strings(files)
+	return files
+}
+
+func (w *RotateWriter) removeBackups() {
+	files := w.backups()
+	for i, name := range files {
+		expired := false
+		if w.MaxBackups > 0 && len(files)-i > w.MaxBackups {
+			expired = true
+		}
+		if w.MaxAge > 0 {
+			if st, err := os.Stat(name); err == nil && time.Since(st.ModTime()) > w.MaxAge {
+				expired = true
+			}
+		}
+		if expired {
+			os.Remove(name)
+		}
+	}
+}
+
+func (w *RotateWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
@@ -0,0 +1,110 @@
+package ginext
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	cfg := NewGinExt("..")
+	cfg.Init()
+	cfg.Logger = NewLogger(&buf, LogFormatJSON, "info")
+
+	r := gin.New()
+	cfg.WithGinExt(r)
+	r.GET("/items/:id", func(c *gin.Context) {
+		CurrentLogger(c).Info("handle item")
+		c.String(http.StatusOK, CurrentRequestID(c))
+	})
+
+	client := NewTestHTTPClient(r)
+	req, _ := http.NewRequest("GET", "/items/1", nil)
+	req.Header.Set(RequestIDHeader, "req-unittest")
+	w := client.SendReq("/items/1", req)
+	assert.Equal(t, "req-unittest", w.Header().Get(RequestIDHeader))
+	assert.Equal(t, "req-unittest", w.Body.String())
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, 2, len(lines))
+	var entry map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(lines[1]), &entry))
+	assert.Equal(t, "req-unittest", entry["request_id"])
+	assert.Equal(t, "/items/:id", entry["route"])
+	assert.Equal(t, float64(http.StatusOK), entry["status"])
+
+	// generate the request id
+	w = client.Get("/items/2")
+	assert.Equal(t, defaultRequestIDLength, len(w.Header().Get(RequestIDHeader)))
+}
+
+func TestWithGinExtWithoutDB(t *testing.T) {
+	cfg := NewGinExt("..")
+	cfg.DbDSN = ""
+	assert.Nil(t, cfg.Init())
+	assert.Nil(t, cfg.DbInstance)
+
+	r := gin.New()
+	cfg.WithGinExt(r)
+	r.GET("/ping", func(c *gin.Context) {
+		_, ok := c.Get(DBField)
+		assert.False(t, ok)
+		c.String(http.StatusOK, "pong")
+	})
+
+	client := NewTestHTTPClient(r)
+	req, _ := http.NewRequest("GET", "/ping", nil)
+	w := client.SendReq("/ping", req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "pong", w.Body.String())
+}
+
+func TestGormLogger(t *testing.T) {
+	var buf bytes.Buffer
+	cfg := NewGinExt("..")
+	cfg.Init()
+	l := NewLogger(&buf, LogFormatJSON, "debug").With("request_id", "sql-unittest")
+
+	result := cfg.DbInstance.WithContext(WithLogger(cfg.DbInstance.Statement.Context, l)).Exec("SELECT * FROM not_exists")
+	assert.NotNil(t, result.Error)
+	assert.Contains(t, buf.String(), "sql-unittest")
+	assert.Contains(t, buf.String(), "not_exists")
+
+	// the Logger of GinExt without the logger in context
+	var extBuf bytes.Buffer
+	cfg.Logger = NewLogger(&extBuf, LogFormatJSON, "debug")
+	result = cfg.DbInstance.Exec("SELECT * FROM not_exists_ext")
+	assert.NotNil(t, result.Error)
+	assert.Contains(t, extBuf.String(), "not_exists_ext")
+}
+
+func TestRotateWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ginext_log")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "app.log")
+	w, err := NewRotateWriter(fileName, 0, 0, 2)
+	assert.Nil(t, err)
+	w.MaxSize = 16
+
+	for i := 0; i < 5; i++ {
+		_, err = w.Write([]byte("0123456789\n"))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, w.Close())
+
+	backups := w.backups()
+	assert.Equal(t, 2, len(backups))
+	data, _ := ioutil.ReadFile(fileName)
+	assert.Equal(t, "0123456789\n", string(data))
+}
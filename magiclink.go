@@ -0,0 +1,159 @@
+package ginext
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	MagicLinkTypeSession = "session"
+	MagicLinkTypeToken   = "token"
+)
+
+const magicLinkCodeLength = 12
+
+type MagicLinkForm struct {
+	Email    string `json:"email" binding:"required"`
+	Redirect string `json:"redirect"`
+	Locale   string `json:"locale"`
+}
+
+type MagicLinkVerifyForm struct {
+	Token    string `json:"token" form:"token" binding:"required"`
+	Redirect string `json:"redirect" form:"redirect"`
+	// session (default) or token
+	Type string `json:"type" form:"type" binding:"omitempty,oneof=session token"`
+}
+
+// allowRedirect the relative path, or the url matched by MagicLinkRedirects
+func (um *UserManager) allowRedirect(redirect string) bool {
+	if len(redirect) <= 0 {
+		return true
+	}
+	if strings.HasPrefix(redirect, "/") && !strings.HasPrefix(redirect, "//") && !strings.HasPrefix(redirect, "/\\") {
+		return true
+	}
+	for _, v := range um.MagicLinkRedirects {
+		if redirect == v || strings.HasPrefix(redirect, strings.TrimSuffix(v, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// magicLinkSignature sign the key, code and redirect, with the ip and User-Agent when bound
+func (um *UserManager) magicLinkSignature(c *gin.Context, key, code, redirect string) string {
+	mac := hmac.New(sha256.New, []byte(um.ext.SessionSecret))
+	mac.Write([]byte(key + "." + code + "\n" + redirect))
+	if um.MagicLinkBindIP {
+		mac.Write([]byte("\n" + c.ClientIP()))
+	}
+	if um.MagicLinkBindDevice {
+		mac.Write([]byte("\n" + c.Request.UserAgent()))
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// handleLoginLink the link is sent by SigUserMagicLink, the unknown email is not revealed
+func (um *UserManager) handleLoginLink(c *gin.Context) {
+	form := c.MustGet(RpcFormField).(*MagicLinkForm)
+	if !um.allowRedirect(form.Redirect) {
+		RpcFail(c, ErrCodeInvalidParams, "redirect is not allowed")
+		return
+	}
+
+	user, err := um.GetByEmail(form.Email)
+	if err != nil || !user.Enabled {
+		RpcOk(c, true)
+		return
+	}
+
+	if um.abortSendRate(c, user.Email, um.MagicLinkSendInterval, 0, 0) {
+		return
+	}
+
+	key, code := um.newVerifyCode(user, user.Email, RandText(magicLinkCodeLength), um.MagicLinkExpired)
+	if len(key) <= 0 {
+		RpcFail(c, ErrCodeServerError, "create verify code fail")
+		return
+	}
+
+	vals := url.Values{}
+	vals.Set("token", key+"."+code+"."+um.magicLinkSignature(c, key, code, form.Redirect))
+	if len(form.Redirect) > 0 {
+		vals.Set("redirect", form.Redirect)
+	}
+	link := strings.TrimSuffix(um.ext.GetValue(Key_SITE_LINK), "/") + rpcPath(c) + "/verify?" + vals.Encode()
+
+	Sig().Emit(SigUserMagicLink, user, user.Email, link, CurrentLocale(c), c)
+	RpcOk(c, true)
+}
+
+// handleLoginLinkVerify the token is single-use, the GET request with redirect is redirected after login.
+// The link proves the email only, the session or token is not verified by WebAuthn as the second factor
+func (um *UserManager) handleLoginLinkVerify(c *gin.Context) {
+	form := c.MustGet(RpcFormField).(*MagicLinkVerifyForm)
+	vals := strings.Split(form.Token, ".")
+	if len(vals) != 3 || !um.allowRedirect(form.Redirect) {
+		RpcFail(c, ErrCodeBadVerifyCode, "bad magic link")
+		return
+	}
+	key, code, sig := vals[0], vals[1], vals[2]
+	if !hmac.Equal([]byte(sig), []byte(um.magicLinkSignature(c, key, code, form.Redirect))) {
+		um.loginFailed(c, "", "bad magic link")
+		RpcFail(c, ErrCodeBadVerifyCode, "bad magic link")
+		return
+	}
+
+	var val GinVerifyCode
+	if um.db.Where("key", key).Take(&val).Error != nil || !um.verifyCode(key, val.Source, code) {
+		um.loginFailed(c, val.Source, "bad magic link")
+		RpcFail(c, ErrCodeBadVerifyCode, "bad magic link")
+		return
+	}
+
+	user, err := um.GetByEmail(val.Source)
+	if err != nil || !user.Enabled {
+		um.loginFailed(c, val.Source, "user is not allow login")
+		RpcAbort(c, ErrUserNotAllowed)
+		return
+	}
+	// the link proves the email
+	if !user.Actived {
+		um.SetActived(user, true)
+	}
+
+	if form.Type == MagicLinkTypeToken {
+		token, err := um.MakeToken(user)
+		if err != nil {
+			RpcFail(c, ErrCodeServerError, "token build fail")
+			return
+		}
+		um.SetLastLogin(user, c.ClientIP())
+		um.audit(c, user.ID, AuditLogin, auditID(user.ID), nil)
+		um.ext.Metrics.incLogin(true)
+		RpcOk(c, TokenResult{
+			Token:     token.Token,
+			ExpiredAt: token.ExpiredAt,
+		})
+		return
+	}
+
+	um.ext.Metrics.incLogin(true)
+	Login(c, user)
+	if len(form.Redirect) > 0 && c.Request.Method == http.MethodGet {
+		c.Redirect(http.StatusFound, form.Redirect)
+		return
+	}
+	RpcOk(c, UserInfoResult{
+		UserName:  user.UserName,
+		Email:     user.Email,
+		LastLogin: user.LastLogin,
+	})
+}
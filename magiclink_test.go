@@ -0,0 +1,102 @@
+package ginext
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMagicLinkLogin(t *testing.T) {
+	um, r := NewTestUserManager()
+	um.MagicLinkSendInterval = 0
+	um.MagicLinkRedirects = []string{"https://app.example.org"}
+	um.RegisterHandler("/auth", r)
+	client := NewTestHTTPClient(r)
+	addUser(t, client, r, "bob", "bob@example.org", "hello123")
+
+	link := ""
+	sid := Sig().Connect(SigUserMagicLink, func(sender interface{}, params ...interface{}) {
+		link = params[1].(string)
+	})
+	defer Sig().Disconnect(SigUserMagicLink, sid)
+
+	sendLink := func(form MagicLinkForm) *url.URL {
+		link = ""
+		ok := false
+		err := client.Call("/auth/login/link", &form, &ok)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		u, err := url.Parse(link)
+		assert.Nil(t, err)
+		return u
+	}
+
+	{
+		ok := false
+		err := client.Call("/auth/login/link", MagicLinkForm{Email: "bob@example.org", Redirect: "https://app.example.org.evil.com/"}, &ok)
+		assert.NotNil(t, err)
+		err = client.Call("/auth/login/link", MagicLinkForm{Email: "bob@example.org", Redirect: "//evil.com"}, &ok)
+		assert.NotNil(t, err)
+
+		// the unknown email is not revealed
+		sendLink(MagicLinkForm{Email: "alice@example.org"})
+		assert.Empty(t, link)
+	}
+	{
+		u := sendLink(MagicLinkForm{Email: "bob@example.org", Redirect: "https://app.example.org/home"})
+		assert.Equal(t, "/auth/login/link/verify", u.Path)
+
+		// the redirect is signed
+		q := u.Query()
+		q.Set("redirect", "/other")
+		w := client.Get(u.Path + "?" + q.Encode())
+		assert.Contains(t, w.Body.String(), "bad magic link")
+
+		w = client.Get(u.RequestURI())
+		assert.Equal(t, http.StatusFound, w.Code)
+		assert.Equal(t, "https://app.example.org/home", w.Header().Get("Location"))
+
+		var profile UserProfileResult
+		err := client.Call("/auth/profile", nil, &profile)
+		assert.Nil(t, err)
+		assert.Equal(t, "bob", profile.UserName)
+
+		// the link is not the second factor
+		r.POST("/mockapi/mfa", um.WebAuthnRequired(), func(c *gin.Context) {
+			RpcOk(c, true)
+		})
+		w = client.Post("/mockapi/mfa", nil)
+		assert.Contains(t, w.Body.String(), "webauthn_required")
+
+		// single-use
+		w = client.Get(u.RequestURI())
+		assert.Contains(t, w.Body.String(), "bad magic link")
+	}
+	{
+		u := sendLink(MagicLinkForm{Email: "bob@example.org"})
+		var before, after int64
+		um.db.Model(&GinAuditLog{}).Where("action", AuditLogin).Count(&before)
+		var token TokenResult
+		err := client.Call("/auth/login/link/verify", MagicLinkVerifyForm{Token: u.Query().Get("token"), Type: MagicLinkTypeToken}, &token)
+		assert.Nil(t, err)
+		assert.NotEmpty(t, token.Token)
+		um.db.Model(&GinAuditLog{}).Where("action", AuditLogin).Count(&after)
+		assert.Equal(t, before+1, after)
+	}
+	{
+		um.MagicLinkBindDevice = true
+		u := sendLink(MagicLinkForm{Email: "bob@example.org"})
+
+		req, _ := http.NewRequest("GET", u.RequestURI(), nil)
+		req.Header.Set("User-Agent", "other device")
+		w := client.SendReq(u.Path, req)
+		assert.Contains(t, w.Body.String(), "bad magic link")
+
+		w = client.Get(u.RequestURI())
+		assert.True(t, strings.Contains(w.Body.String(), `"username":"bob"`))
+	}
+}
@@ -0,0 +1,322 @@
+package ginext
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MailDriverSMTP   = "smtp"
+	MailDriverFile   = "file"
+	MailDriverMemory = "memory"
+
+	SmtpTLSStartTLS = "starttls"
+	SmtpTLSImplicit = "tls"
+	SmtpTLSNone     = "none"
+)
+
+// Mail the message to send, Text and HTML are sent as multipart/alternative
+type Mail struct {
+	From    string            `json:"from"`
+	To      []string          `json:"to"`
+	Cc      []string          `json:"cc,omitempty"`
+	Subject string            `json:"subject"`
+	Text    string            `json:"text,omitempty"`
+	HTML    string            `json:"html,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+type Mailer interface {
+	Send(ctx context.Context, m *Mail) error
+}
+
+// checkHeaders the header values with CR or LF inject the other headers
+func (m *Mail) checkHeaders() error {
+	vals := append(append([]string{m.From}, m.To...), m.Cc...)
+	for k, v := range m.Headers {
+		vals = append(vals, k, v)
+	}
+	for _, v := range vals {
+		if strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("invalid mail header %q", v)
+		}
+	}
+	return nil
+}
+
+// Bytes the RFC 5322 message of mail
+func (m *Mail) Bytes() ([]byte, error) {
+	if len(m.To) <= 0 {
+		return nil, errors.New("mail without recipient")
+	}
+	if err := m.checkHeaders(); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	header := textproto.MIMEHeader{}
+	header.Set("From", m.From)
+	header.Set("To", strings.Join(m.To, ", "))
+	if len(m.Cc) > 0 {
+		header.Set("Cc", strings.Join(m.Cc, ", "))
+	}
+	header.Set("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header.Set("Date", time.Now().Format(time.RFC1123Z))
+	header.Set("Message-Id", "<"+RandText(24)+"@"+mailDomain(m.From)+">")
+	header.Set("Mime-Version", "1.0")
+	for k, v := range m.Headers {
+		header.Set(k, v)
+	}
+
+	mw := multipart.NewWriter(&buf)
+	if len(m.Text) > 0 && len(m.HTML) > 0 {
+		header.Set("Content-Type", "multipart/alternative; boundary="+mw.Boundary())
+		writeMailHeader(&buf, header)
+		if err := writeMailPart(mw, "text/plain", m.Text); err != nil {
+			return nil, err
+		}
+		if err := writeMailPart(mw, "text/html", m.HTML); err != nil {
+			return nil, err
+		}
+		if err := mw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	contentType, body := "text/plain", m.Text
+	if len(m.HTML) > 0 {
+		contentType, body = "text/html", m.HTML
+	}
+	header.Set("Content-Type", contentType+"; charset=utf-8")
+	header.Set("Content-Transfer-Encoding", "quoted-printable")
+	writeMailHeader(&buf, header)
+	qw := quotedprintable.NewWriter(&buf)
+	if _, err := qw.Write([]byte(body)); err != nil {
+		return nil, err
+	}
+	if err := qw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeMailHeader(buf *bytes.Buffer, header textproto.MIMEHeader) {
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range header[k] {
+			fmt.Fprintf(buf, "%s: %s\r\n", k, v)
+		}
+	}
+	buf.WriteString("\r\n")
+}
+
+func writeMailPart(mw *multipart.Writer, contentType, body string) error {
+	pw, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType + "; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	qw := quotedprintable.NewWriter(pw)
+	if _, err := qw.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qw.Close()
+}
+
+func mailDomain(from string) string {
+	if addr, err := mail.ParseAddress(from); err == nil {
+		from = addr.Address
+	}
+	if idx := strings.LastIndex(from, "@"); idx >= 0 {
+		return from[idx+1:]
+	}
+	return "localhost"
+}
+
+// mailAddress the address without display name, e.g. "Bob <bob@example.org>" => bob@example.org
+func mailAddress(v string) string {
+	if addr, err := mail.ParseAddress(v); err == nil {
+		return addr.Address
+	}
+	return v
+}
+
+// SMTPMailer send by the SMTP server, the TLS is starttls (default), tls or none
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	TLS      string
+	Timeout  time.Duration
+	// Skip verify the certificate of server, only for testing
+	InsecureSkipVerify bool
+}
+
+func (s *SMTPMailer) Send(ctx context.Context, m *Mail) error {
+	msg, err := m.Bytes()
+	if err != nil {
+		return err
+	}
+
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		timeout = time.Until(deadline)
+	}
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	tlsConfig := &tls.Config{ServerName: s.Host, InsecureSkipVerify: s.InsecureSkipVerify}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	if s.TLS == SmtpTLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	c, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if s.TLS == "" || s.TLS == SmtpTLSStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("smtp server not support STARTTLS")
+		}
+		if err = c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if len(s.Username) > 0 {
+		if err = c.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err = c.Mail(mailAddress(m.From)); err != nil {
+		return err
+	}
+	for _, to := range append(append([]string{}, m.To...), m.Cc...) {
+		if err = c.Rcpt(mailAddress(to)); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(msg); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// MemoryMailer capture the mails, for testing
+type MemoryMailer struct {
+	mu    sync.Mutex
+	mails []Mail
+}
+
+func (s *MemoryMailer) Send(ctx context.Context, m *Mail) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mails = append(s.mails, *m)
+	return nil
+}
+
+// Mails return the captured mails
+func (s *MemoryMailer) Mails() []Mail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Mail{}, s.mails...)
+}
+
+func (s *MemoryMailer) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mails = nil
+}
+
+// FileMailer write the mails into Dir as .eml files
+type FileMailer struct {
+	Dir string
+}
+
+func (s *FileMailer) Send(ctx context.Context, m *Mail) error {
+	msg, err := m.Bytes()
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+	name := time.Now().Format("20060102150405") + "-" + RandText(8) + ".eml"
+	return os.WriteFile(filepath.Join(s.Dir, name), msg, 0644)
+}
+
+// NewMailer create the mailer of mail_driver, nil when mail_driver is empty
+func (c *GinExt) NewMailer() (Mailer, error) {
+	switch c.MailDriver {
+	case "":
+		return nil, nil
+	case MailDriverSMTP:
+		port := c.SmtpPort
+		if port <= 0 {
+			port = 587
+			if c.SmtpTLS == SmtpTLSImplicit {
+				port = 465
+			}
+		}
+		return &SMTPMailer{
+			Host:     c.SmtpHost,
+			Port:     port,
+			Username: c.SmtpUsername,
+			Password: c.SmtpPassword,
+			TLS:      c.SmtpTLS,
+		}, nil
+	case MailDriverFile:
+		dir := c.MailDir
+		if len(dir) <= 0 {
+			dir = c.FilePath("mails")
+		}
+		return &FileMailer{Dir: dir}, nil
+	case MailDriverMemory:
+		return &MemoryMailer{}, nil
+	}
+	return nil, fmt.Errorf("unknown mail_driver %s", c.MailDriver)
+}
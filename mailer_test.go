@@ -0,0 +1,77 @@
+package ginext
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMailBytes(t *testing.T) {
+	m := Mail{
+		From:    "Site <noreply@example.org>",
+		To:      []string{"bob@example.org"},
+		Subject: "验证码",
+		Text:    "code is 1234",
+	}
+	data, err := m.Bytes()
+	assert.Nil(t, err)
+	msg := string(data)
+	assert.Contains(t, msg, "Message-Id: <")
+	assert.Contains(t, msg, "@example.org>\r\n")
+	assert.Contains(t, msg, "Subject: =?utf-8?q?")
+	assert.Contains(t, msg, "Content-Type: text/plain; charset=utf-8\r\n")
+	assert.True(t, strings.HasSuffix(msg, "\r\n\r\ncode is 1234"))
+
+	m.HTML = "<b>1234</b>"
+	data, err = m.Bytes()
+	assert.Nil(t, err)
+	msg = string(data)
+	assert.Contains(t, msg, "Content-Type: multipart/alternative; boundary=")
+	assert.Contains(t, msg, "Content-Type: text/html; charset=utf-8")
+	assert.Contains(t, msg, "<b>1234</b>")
+
+	_, err = (&Mail{Subject: "hello"}).Bytes()
+	assert.NotNil(t, err)
+
+	// the injected headers
+	m.Headers = map[string]string{"Reply-To": "bob@example.org\r\nBcc: eve@example.org"}
+	_, err = m.Bytes()
+	assert.NotNil(t, err)
+	m.Headers = nil
+	m.To = []string{"bob@example.org\nBcc: eve@example.org"}
+	_, err = m.Bytes()
+	assert.NotNil(t, err)
+}
+
+func TestNewMailer(t *testing.T) {
+	cfg := NewGinExt("..")
+	mailer, err := cfg.NewMailer()
+	assert.Nil(t, err)
+	assert.Nil(t, mailer)
+
+	cfg.MailDriver = MailDriverSMTP
+	cfg.SmtpHost = "smtp.example.org"
+	cfg.SmtpTLS = SmtpTLSImplicit
+	mailer, err = cfg.NewMailer()
+	assert.Nil(t, err)
+	assert.Equal(t, 465, mailer.(*SMTPMailer).Port)
+
+	cfg.MailDriver = "pigeon"
+	_, err = cfg.NewMailer()
+	assert.NotNil(t, err)
+
+	cfg.MailDriver = MailDriverFile
+	cfg.MailDir = t.TempDir()
+	mailer, err = cfg.NewMailer()
+	assert.Nil(t, err)
+	err = mailer.Send(context.Background(), &Mail{From: "noreply@example.org", To: []string{"bob@example.org"}, Subject: "hello", Text: "world"})
+	assert.Nil(t, err)
+	files, _ := filepath.Glob(filepath.Join(cfg.MailDir, "*.eml"))
+	assert.Equal(t, 1, len(files))
+	data, _ := os.ReadFile(files[0])
+	assert.Contains(t, string(data), "To: bob@example.org")
+}
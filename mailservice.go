@@ -0,0 +1,274 @@
+package ginext
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"html/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const MailTaskType = "ginext.mail"
+
+// The builtin mail templates, sent on the signals
+const (
+	MailVerifyEmail     = "verify_email"
+	MailResetPassword   = "reset_password"
+	MailMagicLink       = "magic_link"
+	MailPasswordChanged = "password_changed"
+	MailOrgInvite       = "org_invite"
+)
+
+// MailTemplate the fallback of the catalog keys mail.<name>.subject, mail.<name>.text and mail.<name>.html,
+// the subject and text are rendered by FormatData, the html by html/template
+type MailTemplate struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+var defaultMailTemplates = map[string]MailTemplate{
+	MailVerifyEmail: {
+		Subject: "{{.SiteName}} email verification",
+		Text:    "Your verification code is {{.Code}}, please do not share it with others.",
+		HTML:    `<p>Your verification code is <b>{{.Code}}</b>, please do not share it with others.</p><p><a href="{{.SiteLink}}">{{.SiteName}}</a></p>`,
+	},
+	MailResetPassword: {
+		Subject: "{{.SiteName}} reset password",
+		Text:    "You are resetting the password, the verification code is {{.Code}}. Please ignore this mail if it was not you.",
+		HTML:    `<p>You are resetting the password, the verification code is <b>{{.Code}}</b>.</p><p>Please ignore this mail if it was not you.</p><p><a href="{{.SiteLink}}">{{.SiteName}}</a></p>`,
+	},
+	MailMagicLink: {
+		Subject: "{{.SiteName}} login link",
+		Text:    "Open the link to login, it can be used only once and expires soon: {{.Link}}",
+		HTML:    `<p>Click the link to login, it can be used only once and expires soon.</p><p><a href="{{.Link}}">Login to {{.SiteName}}</a></p>`,
+	},
+	MailPasswordChanged: {
+		Subject: "{{.SiteName}} password changed",
+		Text:    "The password of {{.UserName}} was changed, and the other devices were logged out. Please reset the password if it was not you.",
+		HTML:    `<p>The password of <b>{{.UserName}}</b> was changed, and the other devices were logged out.</p><p>Please reset the password if it was not you.</p><p><a href="{{.SiteLink}}">{{.SiteName}}</a></p>`,
+	},
+	MailOrgInvite: {
+		Subject: "{{.SiteName}} invitation to {{.OrgName}}",
+		Text:    "{{.UserName}} invited you to join {{.OrgName}}, the invitation token is {{.Token}}. Please ignore this mail if you do not know the organization.",
+		HTML:    `<p><b>{{.UserName}}</b> invited you to join <b>{{.OrgName}}</b>, the invitation token is <b>{{.Token}}</b>.</p><p>Please ignore this mail if you do not know the organization.</p><p><a href="{{.SiteLink}}">{{.SiteName}}</a></p>`,
+	},
+}
+
+// MailService send the mails of SigUserVerifyEmail, SigUserResetpassword, SigUserMagicLink and SigOrgInvite,
+// the mails are queued by WorkerManager and retried when fail
+type MailService struct {
+	ext        *GinExt
+	wm         *WorkerManager
+	Mailer     Mailer
+	Templates  map[string]MailTemplate
+	MaxRetries int
+	// Notify the user by SigUserPasswordChanged, set before Init
+	NotifyPasswordChanged bool
+	sigIDs                map[string]uint
+}
+
+// NewMailService the mailer is created by the mail_driver settings when nil
+func NewMailService(ext *GinExt, wm *WorkerManager, mailer Mailer) *MailService {
+	templates := map[string]MailTemplate{}
+	for k, v := range defaultMailTemplates {
+		templates[k] = v
+	}
+	return &MailService{
+		ext:        ext,
+		wm:         wm,
+		Mailer:     mailer,
+		Templates:  templates,
+		MaxRetries: 5,
+		sigIDs:     map[string]uint{},
+	}
+}
+
+func (ms *MailService) Init() (err error) {
+	if ms.Mailer == nil {
+		if ms.Mailer, err = ms.ext.NewMailer(); err != nil {
+			return err
+		}
+	}
+	if ms.Mailer == nil {
+		ms.ext.Logger.Warn("mail_driver is empty, mails are not sent")
+		return nil
+	}
+	ms.connect(SigUserVerifyEmail, MailVerifyEmail, "Code")
+	ms.connect(SigUserResetpassword, MailResetPassword, "Code")
+	ms.connect(SigUserMagicLink, MailMagicLink, "Link")
+	ms.sigIDs[SigOrgInvite] = Sig().Connect(SigOrgInvite, ms.handleOrgInvite)
+	if ms.NotifyPasswordChanged {
+		ms.sigIDs[SigUserPasswordChanged] = Sig().Connect(SigUserPasswordChanged, ms.handlePasswordChanged)
+	}
+	return nil
+}
+
+// requestContext the context of request in the signal params, to continue the trace in the worker
+func requestContext(params []interface{}, idx int) context.Context {
+	if len(params) > idx {
+		if c, ok := params[idx].(*gin.Context); ok && c != nil && c.Request != nil {
+			return c.Request.Context()
+		}
+	}
+	return context.Background()
+}
+
+// handlePasswordChanged the params: c *gin.Context
+func (ms *MailService) handlePasswordChanged(sender interface{}, params ...interface{}) {
+	user, ok := sender.(*GinExtUser)
+	if !ok || user == nil || len(user.Email) <= 0 {
+		return
+	}
+	ctx, locale := context.Background(), ""
+	if len(params) > 0 {
+		if c, ok := params[0].(*gin.Context); ok && c != nil && c.Request != nil {
+			ctx, locale = c.Request.Context(), CurrentLocale(c)
+		}
+	}
+	vals := map[string]string{"Email": user.Email, "UserName": user.UserName}
+	if err := ms.Queue(ctx, user.Email, MailPasswordChanged, locale, vals); err != nil {
+		ms.ext.Logger.Error("queue mail fail", "mail", MailPasswordChanged, "email", user.Email, "error", err)
+	}
+}
+
+// handleOrgInvite the params: org *GinOrganization, email, token, locale string, c *gin.Context
+func (ms *MailService) handleOrgInvite(sender interface{}, params ...interface{}) {
+	if len(params) < 4 {
+		return
+	}
+	org, ok := params[0].(*GinOrganization)
+	if !ok || org == nil {
+		return
+	}
+	email, _ := params[1].(string)
+	token, _ := params[2].(string)
+	locale, _ := params[3].(string)
+	vals := map[string]string{"Email": email, "Token": token, "OrgName": org.Name}
+	if user, ok := sender.(*GinExtUser); ok && user != nil {
+		vals["UserName"] = user.UserName
+	}
+	if err := ms.Queue(requestContext(params, 4), email, MailOrgInvite, locale, vals); err != nil {
+		ms.ext.Logger.Error("queue mail fail", "mail", MailOrgInvite, "email", email, "error", err)
+	}
+}
+
+// Close disconnect the signals
+func (ms *MailService) Close() {
+	for event, id := range ms.sigIDs {
+		Sig().Disconnect(event, id)
+	}
+	ms.sigIDs = map[string]uint{}
+}
+
+// connect the signal with params: email, code (or link), locale string, c *gin.Context
+func (ms *MailService) connect(event, name, valName string) {
+	ms.sigIDs[event] = Sig().Connect(event, func(sender interface{}, params ...interface{}) {
+		if len(params) < 3 {
+			return
+		}
+		email, _ := params[0].(string)
+		val, _ := params[1].(string)
+		locale, _ := params[2].(string)
+		vals := map[string]string{"Email": email, valName: val}
+		if user, ok := sender.(*GinExtUser); ok && user != nil {
+			vals["UserName"] = user.UserName
+		}
+		if err := ms.Queue(requestContext(params, 3), email, name, locale, vals); err != nil {
+			ms.ext.Logger.Error("queue mail fail", "mail", name, "email", email, "error", err)
+		}
+	})
+}
+
+// Render the mail of template name in locale, SiteName and SiteLink are from the config store
+func (ms *MailService) Render(locale, name string, vals map[string]string) *Mail {
+	ctx := map[string]string{
+		"SiteName": ms.ext.GetValue(Key_SITE_NAME),
+		"SiteLink": ms.ext.GetValue(Key_SITE_LINK),
+	}
+	for k, v := range vals {
+		ctx[k] = v
+	}
+
+	tmpl := ms.Templates[name]
+	cat := ms.ext.Catalog
+	if cat == nil {
+		cat = NewCatalog()
+	}
+	m := &Mail{
+		From:    ms.ext.MailFrom,
+		Subject: cat.FormatData(locale, "mail."+name+".subject", ctx, tmpl.Subject),
+		Text:    cat.FormatData(locale, "mail."+name+".text", ctx, tmpl.Text),
+		HTML:    renderHTML(cat.Translate(locale, "mail."+name+".html", tmpl.HTML), ctx),
+	}
+	if len(m.From) <= 0 {
+		m.From = "noreply@localhost"
+	}
+	return m
+}
+
+func renderHTML(text string, ctx map[string]string) string {
+	if len(text) <= 0 {
+		return ""
+	}
+	tmpl, err := template.New("ginext mail").Parse(text)
+	if err != nil {
+		return ""
+	}
+	var b bytes.Buffer
+	if err = tmpl.Execute(&b, ctx); err != nil {
+		return ""
+	}
+	return b.String()
+}
+
+// mailTask the context of mail task, the mail is rendered when sending,
+// and the context with the codes or links is cleared when sent or failed
+type mailTask struct {
+	To     []string          `json:"to"`
+	Name   string            `json:"name"`
+	Locale string            `json:"locale"`
+	Vals   map[string]string `json:"vals"`
+}
+
+// Queue the mail of template name to send by worker
+func (ms *MailService) Queue(ctx context.Context, to, name, locale string, vals map[string]string) error {
+	data, err := json.Marshal(mailTask{To: []string{to}, Name: name, Locale: locale, Vals: vals})
+	if err != nil {
+		return err
+	}
+	return ms.wm.AddTask(ctx, &GinTask{
+		TaskType:   MailTaskType,
+		Context:    string(data),
+		MaxRetries: ms.MaxRetries,
+	})
+}
+
+// RegisterWorker handle the mail tasks of worker
+func (ms *MailService) RegisterWorker(w *Worker) {
+	w.AddHandle(MailTaskType, ms.handleMailTask)
+}
+
+func (ms *MailService) handleMailTask(t *GinTask) (string, error) {
+	var q mailTask
+	if err := json.Unmarshal([]byte(t.Context), &q); err != nil || len(q.Name) <= 0 {
+		return UnmarshalFailHandle, nil
+	}
+	if ms.Mailer == nil {
+		return NotImplementHandle, nil
+	}
+	m := ms.Render(q.Locale, q.Name, q.Vals)
+	m.To = q.To
+	ctx, cancel := context.WithTimeout(t.Ctx(), 60*time.Second)
+	defer cancel()
+	err := ms.Mailer.Send(ctx, m)
+	if err == nil || t.Retries >= t.MaxRetries {
+		ms.wm.db.WithContext(t.Ctx()).Model(t).UpdateColumn("context", "")
+	}
+	if err != nil {
+		return "", err
+	}
+	return `{"msg":"sent"}`, nil
+}
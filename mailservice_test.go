@@ -0,0 +1,117 @@
+package ginext
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type failMailer struct {
+	MemoryMailer
+	fails int
+}
+
+func (s *failMailer) Send(ctx context.Context, m *Mail) error {
+	if s.fails > 0 {
+		s.fails--
+		return errors.New("smtp unavailable")
+	}
+	return s.MemoryMailer.Send(ctx, m)
+}
+
+func TestMailServiceRender(t *testing.T) {
+	defer Tidyup()
+	wm := NewTestWorkerManager()
+	wm.ext.SetValue(Key_SITE_NAME, "Restsend")
+	wm.ext.SetValue(Key_SITE_LINK, "https://example.org")
+	ms := NewMailService(wm.ext, wm, &MemoryMailer{})
+
+	m := ms.Render("en", MailVerifyEmail, map[string]string{"Code": "<1234>"})
+	assert.Equal(t, "Restsend email verification", m.Subject)
+	assert.Contains(t, m.Text, "<1234>")
+	assert.Contains(t, m.HTML, "&lt;1234&gt;")
+	assert.Contains(t, m.HTML, `href="https://example.org"`)
+
+	m = ms.Render("zh-CN", MailResetPassword, map[string]string{"Code": "1234"})
+	assert.Equal(t, "Restsend 重置密码", m.Subject)
+	assert.Contains(t, m.Text, "验证码是 1234")
+
+	m = ms.Render("en", MailMagicLink, map[string]string{"Link": "https://example.org/auth/login/link/verify?token=a&redirect=b"})
+	assert.Contains(t, m.Text, "token=a&redirect=b")
+	assert.Contains(t, m.HTML, `href="https://example.org/auth/login/link/verify?token=a&amp;redirect=b"`)
+}
+
+func TestMailServiceQueue(t *testing.T) {
+	defer Tidyup()
+	wm := NewTestWorkerManager()
+	mailer := &failMailer{fails: 1}
+	ms := NewMailService(wm.ext, wm, mailer)
+	ms.MaxRetries = 1
+	assert.Nil(t, ms.Init())
+	defer ms.Close()
+
+	// the trace of request is continued by the task
+	flush := newTestTracer(t)
+	ctx, reqSpan := tracer().Start(context.Background(), "request")
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request, _ = http.NewRequestWithContext(ctx, "POST", "/auth/verifyemail/new", nil)
+	Sig().Emit(SigUserVerifyEmail, nil, "bob@example.org", "1234", "en", c)
+	reqSpan.End()
+	flush()
+
+	var task GinTask
+	assert.Nil(t, wm.db.Where("task_type", MailTaskType).Take(&task).Error)
+	assert.Contains(t, task.TraceContext, "traceparent")
+	var queued mailTask
+	assert.Nil(t, json.Unmarshal([]byte(task.Context), &queued))
+	assert.Equal(t, []string{"bob@example.org"}, queued.To)
+	assert.Equal(t, MailVerifyEmail, queued.Name)
+
+	w := NewWorker(wm.db, "mail")
+	ms.RegisterWorker(w)
+
+	// retry later
+	assert.NotNil(t, w.DoTask(&task))
+	assert.Nil(t, wm.db.Take(&task, task.ID).Error)
+	assert.False(t, task.Done)
+	assert.Equal(t, 1, task.Retries)
+	assert.Equal(t, "smtp unavailable", task.Result)
+	assert.NotNil(t, task.StartTime)
+	assert.NotEmpty(t, task.Context)
+
+	assert.Nil(t, w.DoTask(&task))
+	assert.Nil(t, wm.db.Take(&task, task.ID).Error)
+	assert.True(t, task.Done)
+	assert.False(t, task.Failed)
+	// the code is not kept after sent
+	assert.Empty(t, task.Context)
+	mails := mailer.Mails()
+	assert.Equal(t, 1, len(mails))
+	assert.Contains(t, mails[0].Text, "1234")
+}
+
+func TestMailServicePasswordChanged(t *testing.T) {
+	defer Tidyup()
+	wm := NewTestWorkerManager()
+	ms := NewMailService(wm.ext, wm, &MemoryMailer{})
+	ms.NotifyPasswordChanged = true
+	assert.Nil(t, ms.Init())
+	defer ms.Close()
+
+	Sig().Emit(SigUserPasswordChanged, &GinExtUser{UserName: "bob", Email: "bob@example.org"}, nil)
+
+	var task GinTask
+	assert.Nil(t, wm.db.Where("task_type", MailTaskType).Take(&task).Error)
+	var queued mailTask
+	assert.Nil(t, json.Unmarshal([]byte(task.Context), &queued))
+	assert.Equal(t, []string{"bob@example.org"}, queued.To)
+	m := ms.Render(queued.Locale, queued.Name, queued.Vals)
+	assert.Contains(t, m.Subject, "password changed")
+	assert.Contains(t, m.Text, "The password of bob was changed")
+}
@@ -0,0 +1,188 @@
+package ginext
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const ApiMetricsUri = "/metrics"
+const MetricsNamespace = "ginext"
+
+// Metrics export the prometheus metrics of rpc, worker, auth and db.
+// All methods are safe with nil receiver, so the metrics is optional.
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	RpcRequests *prometheus.CounterVec
+	RpcLatency  *prometheus.HistogramVec
+	RpcFails    *prometheus.CounterVec
+
+	WorkerQueueDepth   *prometheus.GaugeVec
+	WorkerTaskDuration *prometheus.HistogramVec
+	WorkerTaskFailures *prometheus.CounterVec
+
+	AuthLogins        prometheus.Counter
+	AuthLoginFailures prometheus.Counter
+	AuthTokensIssued  prometheus.Counter
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		RpcRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Name:      "rpc_requests_total",
+			Help:      "Total number of rpc requests.",
+		}, []string{"path", "method"}),
+		RpcLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: MetricsNamespace,
+			Name:      "rpc_request_duration_seconds",
+			Help:      "Latency of rpc requests.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"path"}),
+		RpcFails: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Name:      "rpc_fails_total",
+			Help:      "Total number of rpc fails by code.",
+		}, []string{"path", "code"}),
+		WorkerQueueDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: MetricsNamespace,
+			Name:      "worker_queue_depth",
+			Help:      "Number of pending tasks.",
+		}, []string{"worker"}),
+		WorkerTaskDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: MetricsNamespace,
+			Name:      "worker_task_duration_seconds",
+			Help:      "Duration of worker tasks.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"task_type"}),
+		WorkerTaskFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Name:      "worker_task_failures_total",
+			Help:      "Total number of failed worker tasks.",
+		}, []string{"task_type"}),
+		AuthLogins: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Name:      "auth_logins_total",
+			Help:      "Total number of user logins.",
+		}),
+		AuthLoginFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Name:      "auth_login_failures_total",
+			Help:      "Total number of failed user logins.",
+		}),
+		AuthTokensIssued: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Name:      "auth_tokens_issued_total",
+			Help:      "Total number of access tokens issued.",
+		}),
+	}
+
+	m.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.RpcRequests,
+		m.RpcLatency,
+		m.RpcFails,
+		m.WorkerQueueDepth,
+		m.WorkerTaskDuration,
+		m.WorkerTaskFailures,
+		m.AuthLogins,
+		m.AuthLoginFailures,
+		m.AuthTokensIssued,
+	)
+	return m
+}
+
+// Register the collectors of app
+func (m *Metrics) Register(cs ...prometheus.Collector) error {
+	for _, c := range cs {
+		if err := m.Registry.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Metrics) Handler() gin.HandlerFunc {
+	h := promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{})
+	return func(c *gin.Context) {
+		h.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// EnableMetrics register the /metrics handler, and the db pool stats
+func (cfg *GinExt) EnableMetrics(r *gin.Engine) *Metrics {
+	if cfg.Metrics == nil {
+		cfg.Metrics = NewMetrics()
+		if cfg.DbInstance != nil {
+			if sqlDB, err := cfg.DbInstance.DB(); err == nil {
+				cfg.Metrics.Register(collectors.NewDBStatsCollector(sqlDB, MetricsNamespace))
+			}
+		}
+	}
+	r.GET(ApiMetricsUri, cfg.Metrics.Handler())
+	return cfg.Metrics
+}
+
+func metricsOf(c *gin.Context) *Metrics {
+	if obj, ok := c.Get(ConfigField); ok && obj != nil {
+		return obj.(*GinExt).Metrics
+	}
+	return nil
+}
+
+func (m *Metrics) observeRpc(path, method string, elapsed time.Duration) {
+	if m == nil {
+		return
+	}
+	m.RpcRequests.WithLabelValues(path, method).Inc()
+	m.RpcLatency.WithLabelValues(path).Observe(elapsed.Seconds())
+}
+
+func (m *Metrics) observeRpcFail(path string, code int) {
+	if m == nil {
+		return
+	}
+	m.RpcFails.WithLabelValues(path, strconv.Itoa(code)).Inc()
+}
+
+func (m *Metrics) observeTask(taskType string, elapsed time.Duration, failed bool) {
+	if m == nil {
+		return
+	}
+	m.WorkerTaskDuration.WithLabelValues(taskType).Observe(elapsed.Seconds())
+	if failed {
+		m.WorkerTaskFailures.WithLabelValues(taskType).Inc()
+	}
+}
+
+func (m *Metrics) setQueueDepth(worker string, depth int64) {
+	if m == nil {
+		return
+	}
+	m.WorkerQueueDepth.WithLabelValues(worker).Set(float64(depth))
+}
+
+func (m *Metrics) incLogin(success bool) {
+	if m == nil {
+		return
+	}
+	if success {
+		m.AuthLogins.Inc()
+	} else {
+		m.AuthLoginFailures.Inc()
+	}
+}
+
+func (m *Metrics) incTokenIssued() {
+	if m == nil {
+		return
+	}
+	m.AuthTokensIssued.Inc()
+}
@@ -0,0 +1,88 @@
+package ginext
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	um, r := NewTestUserManager()
+	um.ext.EnableMetrics(r)
+	um.RegisterHandler("/auth", r)
+
+	appCounter := prometheus.NewCounter(prometheus.CounterOpts{Name: "unittest_app_total"})
+	assert.Nil(t, um.ext.Metrics.Register(appCounter))
+	appCounter.Add(3)
+
+	client := NewTestHTTPClient(r)
+	addUser(t, client, r, "bob", "bob@example.org", "hello123")
+	err := client.Call("/auth/login", &LoginForm{UserName: "bob", Password: "bad"}, nil)
+	assert.NotNil(t, err)
+	var token TokenResult
+	err = client.Call("/auth/token", &LoginForm{UserName: "bob", Password: "hello123"}, &token)
+	assert.Nil(t, err)
+
+	// the accesstoken requests are not logins, the invalid token is a failure
+	r.GET("/current", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	for _, v := range []string{token.Token, token.Token, "bad-token"} {
+		req, _ := http.NewRequest("GET", "/current", nil)
+		req.Header.Set("Authorization", "Bearer "+v)
+		NewTestHTTPClient(r).SendReq("/current", req)
+	}
+
+	w := client.Get(ApiMetricsUri)
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, `ginext_rpc_requests_total{method="POST",path="/auth/login"} 1`)
+	assert.Contains(t, body, `ginext_rpc_request_duration_seconds_count{path="/auth/register"} 1`)
+	assert.Contains(t, body, `ginext_rpc_fails_total{code="10003",path="/auth/login"} 1`)
+	assert.Contains(t, body, `ginext_auth_logins_total 1`)
+	assert.Contains(t, body, `ginext_auth_login_failures_total 2`)
+	assert.Contains(t, body, `ginext_auth_tokens_issued_total 1`)
+	assert.Contains(t, body, `go_sql_max_open_connections{db_name="ginext"}`)
+	assert.Contains(t, body, `unittest_app_total 3`)
+}
+
+func TestWorkerMetrics(t *testing.T) {
+	defer Tidyup()
+	wm := NewTestWorkerManager()
+	m := NewMetrics()
+
+	w := NewWorker(wm.db, "metrics worker")
+	w.Metrics = m
+	w.AddHandle("ok", func(t *GinTask) (string, error) {
+		return "", nil
+	})
+	w.AddHandle("fail", func(t *GinTask) (string, error) {
+		return "", errors.New("mock fail")
+	})
+	wm.Add(1, "ok", "", 0)
+	wm.Add(1, "fail", "", 0)
+	assert.Nil(t, w.pullTasks())
+
+	families, err := m.Registry.Gather()
+	assert.Nil(t, err)
+	values := map[string]float64{}
+	for _, f := range families {
+		for _, metric := range f.GetMetric() {
+			switch {
+			case metric.GetCounter() != nil:
+				values[f.GetName()] += metric.GetCounter().GetValue()
+			case metric.GetGauge() != nil:
+				values[f.GetName()] += metric.GetGauge().GetValue()
+			case metric.GetHistogram() != nil:
+				values[f.GetName()] += float64(metric.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	assert.Equal(t, float64(2), values["ginext_worker_queue_depth"])
+	assert.Equal(t, float64(2), values["ginext_worker_task_duration_seconds"])
+	assert.Equal(t, float64(1), values["ginext_worker_task_failures_total"])
+}
@@ -1,6 +1,8 @@
 package ginext
 
 import (
+	"context"
+	"strings"
 	"time"
 )
 
@@ -28,12 +30,92 @@ type GinExtUser struct {
 	WxUnionID string `gorm:"size:100;"`
 	FBAuthID  string `gorm:"size:100;"`
 	GGAuthID  string `gorm:"size:100;"`
+
+	// The sessions with the old version are logged out
+	SessionVersion int
+	// The password is expired by the MaxAge of PasswordPolicy, CreatedAt when nil
+	PasswordChangedAt *time.Time
+
+	// The account deactivated by the user, restored by the password
+	DeactivatedAt *time.Time
+	// The account is deleted by the worker at the time, unless restored
+	DeleteAt *time.Time
+}
+
+// GinOrganization the tenant of users, see organization.go
+type GinOrganization struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Name      string    `json:"name" gorm:"size:128"`
+	OwnerID   uint      `json:"ownerId" gorm:"index"`
+}
+
+// GinMembership the user is a member of organization with the role
+type GinMembership struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	OrgID     uint `gorm:"uniqueIndex:idx_membership_org_user"`
+	Org       GinOrganization
+	UserID    uint `gorm:"uniqueIndex:idx_membership_org_user;index"`
+	User      GinExtUser
+	Role      string `gorm:"size:32"`
+}
+
+// GinInvitation the invitation of organization by email, the code is a GinVerifyCode with the Key
+type GinInvitation struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	OrgID     uint `gorm:"index"`
+	Org       GinOrganization
+	InviterID uint
+	Email     string `gorm:"size:128;index"`
+	Role      string `gorm:"size:32"`
+	Key       string `gorm:"size:64;uniqueIndex"`
+	Status    string `gorm:"size:16;index"`
+	ExpiredAt time.Time
+}
+
+// GinPasswordHistory the old password hashes of user, see PasswordPolicy.HistorySize
+type GinPasswordHistory struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UserID    uint   `gorm:"index"`
+	Password  string `gorm:"size:128"`
+}
+
+// GinAuditLog the append-only log of the security-relevant events
+type GinAuditLog struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"createdAt" gorm:"index"`
+	// The user who did the action, 0 is anonymous or system
+	ActorID uint `json:"actorId" gorm:"index"`
+	// The staff who is impersonating the actor
+	ImpersonatorID uint   `json:"impersonatorId,omitempty"`
+	Action         string `json:"action" gorm:"size:64;index"`
+	TargetType     string `json:"targetType" gorm:"size:64;index"`
+	TargetID       string `json:"targetId" gorm:"size:128;index"`
+	IP             string `json:"ip" gorm:"size:128"`
+	UserAgent      string `json:"userAgent" gorm:"size:200"`
+	// The json of changes
+	Diff string `json:"diff,omitempty"`
 }
 
 type GinExtConfig struct {
-	ID    uint   `gorm:"primarykey"`
-	Key   string `gorm:"size:128;uniqueIndex"`
-	Value string
+	ID          uint      `json:"id" gorm:"primarykey"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	Key         string    `json:"key" gorm:"size:128;uniqueIndex"`
+	Value       string    `json:"value"`
+	Namespace   string    `json:"namespace" gorm:"size:64;index"`
+	Description string    `json:"description" gorm:"size:200"`
+	// Increase on every change, other instances invalidate the cache by it
+	Version int64 `json:"version" gorm:"index"`
+}
+
+// GinExtConfigVersion the counter of GinExtConfig.Version, increased in the transaction of change
+type GinExtConfigVersion struct {
+	ID      uint `gorm:"primarykey"`
+	Version int64
 }
 
 type GinTask struct {
@@ -46,10 +128,25 @@ type GinTask struct {
 	Failed   bool  `gorm:"index"`
 	Context  string
 	Result   string
+	// The failed task is retried with backoff until Retries reach MaxRetries
+	Retries    int
+	MaxRetries int
+	// The trace context of request which queued the task
+	TraceContext string `gorm:"size:512"`
 	// Delay to invoke
 	StartTime *time.Time `gorm:"index"`
 	ExecTime  *time.Time
 	EndTime   *time.Time
+
+	ctx context.Context `gorm:"-"`
+}
+
+// Ctx return the context of the task span, the db queries with it are traced
+func (t *GinTask) Ctx() context.Context {
+	if t.ctx == nil {
+		return context.Background()
+	}
+	return t.ctx
 }
 
 type GinToken struct {
@@ -59,6 +156,10 @@ type GinToken struct {
 	Owner     GinExtUser
 	Token     string `gorm:"size:32;uniqueIndex"`
 	ExpiredAt time.Time
+	// The current organization of the token
+	OrgID uint
+	// The SessionVersion of owner when issued, the token is invalid after ForceLogout
+	SessionVersion int
 }
 
 type GinProfile struct {
@@ -76,15 +177,54 @@ type GinProfile struct {
 }
 
 type GinVerifyCode struct {
-	ID        uint   `gorm:"primarykey"`
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
 	Key       string `gorm:"size:64;uniqueIndex"`
 	Source    string `gorm:"size:200;index"`
+	// The ip requested the code, e.g. the rate limits of sms
+	IP        string `gorm:"size:128;index"`
 	Code      string `gorm:"size:12"`
 	FailCount int
 	Verified  bool
 	ExpiredAt time.Time
 }
 
+// GinCredential the WebAuthn credential of user
+type GinCredential struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	UserID uint `gorm:"index"`
+	User   GinExtUser
+	// The base64url of credential id
+	CredentialID string `gorm:"size:512;uniqueIndex"`
+	// The COSE key
+	PublicKey  []byte
+	SignCount  uint32
+	Transports string `gorm:"size:128"`
+	Name       string `gorm:"size:128"`
+	LastUsed   *time.Time
+}
+
+func (cred *GinCredential) GetTransports() []string {
+	if len(cred.Transports) <= 0 {
+		return nil
+	}
+	return strings.Split(cred.Transports, ",")
+}
+
+func (cred *GinCredential) Result() WebAuthnCredentialResult {
+	return WebAuthnCredentialResult{
+		ID:           cred.ID,
+		CredentialID: cred.CredentialID,
+		Name:         cred.Name,
+		Transports:   cred.GetTransports(),
+		CreatedAt:    cred.CreatedAt,
+		LastUsed:     cred.LastUsed,
+	}
+}
+
 func (u *GinExtUser) GetVisibleName() string {
 	if len(u.DisplayName) > 0 {
 		return u.DisplayName
@@ -0,0 +1,397 @@
+package ginext
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v2"
+)
+
+const ApiOpenAPIJSONUri = "/docs/openapi.json"
+const ApiOpenAPIYAMLUri = "/docs/openapi.yaml"
+
+const OpenAPIVersion = "3.0.3"
+
+// OpenAPISpec build the OpenAPI 3 document from the rpc docs, cfg is optional
+func (reg *RpcRegistry) OpenAPISpec(cfg *GinExt) map[string]interface{} {
+	title := "ginext"
+	sessionName := "ginsession"
+	if cfg != nil {
+		sessionName = cfg.SessionName
+		if cfg.DbInstance != nil {
+			if siteName := cfg.GetValue(Key_SITE_NAME); len(siteName) > 0 {
+				title = siteName
+			}
+		}
+	}
+
+	paths := gin.H{}
+	tags := []gin.H{}
+	currentTag := ""
+	for _, doc := range reg.Docs() {
+		if doc.IsGroup {
+			currentTag = doc.RelativePath
+			tags = append(tags, gin.H{"name": currentTag})
+			continue
+		}
+		uri := openAPIPath(doc.RelativePath)
+		item, ok := paths[uri].(gin.H)
+		if !ok {
+			item = gin.H{}
+			paths[uri] = item
+		}
+		for _, method := range doc.methods() {
+			method = strings.ToLower(method)
+			item[method] = openAPIOperation(doc, currentTag, method)
+		}
+	}
+
+	spec := gin.H{
+		"openapi": OpenAPIVersion,
+		"info": gin.H{
+			"title":   title,
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": gin.H{
+			"securitySchemes": gin.H{
+				"sessionCookie": gin.H{
+					"type": "apiKey",
+					"in":   "cookie",
+					"name": sessionName,
+				},
+				"bearerAuth": gin.H{
+					"type":   "http",
+					"scheme": "bearer",
+				},
+			},
+			"schemas": gin.H{
+				"RpcError": openAPIErrorSchema(),
+			},
+		},
+	}
+	if len(tags) > 0 {
+		spec["tags"] = tags
+	}
+	return spec
+}
+
+func openAPIOperation(doc RpcDoc, tag, method string) gin.H {
+	op := gin.H{
+		"operationId": openAPIOperationID(doc.RelativePath, method),
+	}
+	if len(tag) > 0 {
+		op["tags"] = []string{tag}
+	}
+	if len(doc.DocString) > 0 {
+		op["summary"] = strings.SplitN(strings.TrimSpace(doc.DocString), "\n", 2)[0]
+		op["description"] = doc.DocString
+	}
+
+	if doc.formType != nil {
+		params := openAPIParams(doc.formType, "path")
+		params = append(params, openAPIParams(doc.formType, "header")...)
+		if method == "get" || method == "delete" {
+			params = append(params, openAPIParams(doc.formType, "query")...)
+		} else {
+			op["requestBody"] = gin.H{
+				"content": gin.H{
+					"application/json": gin.H{
+						"schema": openAPIObjectSchema(openAPIBodyFields(doc.formType, doc.Fields)),
+					},
+				},
+			}
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+	}
+
+	var resultSchema gin.H
+	if doc.ResultType.Type != "" {
+		resultSchema = openAPISchema(doc.ResultType)
+	} else {
+		resultSchema = gin.H{}
+	}
+
+	responses := gin.H{
+		"400": openAPIResponse("Bind form fail", gin.H{"$ref": "#/components/schemas/RpcError"}),
+	}
+	if doc.reduceDataField {
+		responses["200"] = openAPIResponse("OK", resultSchema)
+		responses["default"] = openAPIResponse("Fail with the code as http status", gin.H{"$ref": "#/components/schemas/RpcError"})
+	} else {
+		envelope := openAPIObject(gin.H{
+			"code": gin.H{"type": "integer", "example": http.StatusOK},
+			"data": resultSchema,
+			"msg":  gin.H{"type": "string"},
+		}, []string{"code"})
+		responses["200"] = openAPIResponse("OK when code is 200, otherwise see RpcError", envelope)
+	}
+
+	if doc.AuthRequired {
+		op["security"] = []gin.H{{"sessionCookie": []string{}}, {"bearerAuth": []string{}}}
+		responses["401"] = openAPIResponse("Auth required", gin.H{"$ref": "#/components/schemas/RpcError"})
+	}
+	if doc.StaffRequired {
+		op["x-staff-required"] = true
+		responses["403"] = openAPIResponse("Staff required", gin.H{"$ref": "#/components/schemas/RpcError"})
+	}
+	op["responses"] = responses
+	return op
+}
+
+// openAPIOperationID e.g. /auth/login => auth_login, and auth_login_get for GET
+func openAPIOperationID(path, method string) string {
+	id := strings.Trim(path, "/")
+	id = strings.NewReplacer("/", "_", "-", "_", ":", "", "*", "").Replace(id)
+	if method != "post" {
+		id += "_" + method
+	}
+	return id
+}
+
+func openAPIResponse(desc string, schema gin.H) gin.H {
+	return gin.H{
+		"description": desc,
+		"content": gin.H{
+			"application/json": gin.H{
+				"schema": schema,
+			},
+		},
+	}
+}
+
+func openAPIObject(props gin.H, required []string) gin.H {
+	obj := gin.H{
+		"type":       "object",
+		"properties": props,
+	}
+	if len(required) > 0 {
+		obj["required"] = required
+	}
+	return obj
+}
+
+func openAPIObjectSchema(fields []RpcFieldType) gin.H {
+	props := gin.H{}
+	var required []string
+	for _, f := range fields {
+		props[f.Name] = openAPISchema(f)
+		if f.Required {
+			required = append(required, f.Name)
+		}
+	}
+	return openAPIObject(props, required)
+}
+
+func openAPISchema(f RpcFieldType) (schema gin.H) {
+	minKey, maxKey := "minimum", "maximum"
+	switch {
+	case f.Type == "string":
+		schema = gin.H{"type": "string"}
+		minKey, maxKey = "minLength", "maxLength"
+	case f.Type == "boolean":
+		schema = gin.H{"type": "boolean"}
+	case f.Type == "Integer":
+		schema = gin.H{"type": "integer"}
+	case f.Type == "Number":
+		schema = gin.H{"type": "number"}
+	case f.Type == "Date":
+		schema = gin.H{"type": "string", "format": "date-time"}
+	case f.Type == "object":
+		schema = openAPIObjectSchema(f.Fields)
+	case strings.HasPrefix(f.Type, "[]"):
+		schema = gin.H{"type": "array", "items": openAPISchema(f.ElemType())}
+		minKey, maxKey = "minItems", "maxItems"
+	case strings.HasPrefix(f.Type, "map["):
+		schema = gin.H{"type": "object", "additionalProperties": openAPISchema(f.ElemType())}
+		minKey, maxKey = "minProperties", "maxProperties"
+	default:
+		schema = gin.H{}
+	}
+
+	if f.Min != nil {
+		schema[minKey] = *f.Min
+	}
+	if f.Max != nil {
+		schema[maxKey] = *f.Max
+	}
+	if len(f.Desc) > 0 {
+		schema["description"] = f.Desc
+	}
+	if f.CanNull {
+		schema["nullable"] = true
+	}
+	if len(f.Enum) > 0 {
+		vals := make([]interface{}, 0, len(f.Enum))
+		for _, v := range f.Enum {
+			vals = append(vals, openAPIValue(f.Type, v))
+		}
+		schema["enum"] = vals
+	}
+	if len(f.Example) > 0 {
+		schema["example"] = openAPIValue(f.Type, f.Example)
+	}
+	return schema
+}
+
+// openAPIValue convert the value of tag to the type of field, the string is kept when not parsed
+func openAPIValue(fieldType, v string) interface{} {
+	switch fieldType {
+	case "Integer":
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return n
+		}
+	case "Number":
+		if n, err := strconv.ParseFloat(v, 64); err == nil {
+			return n
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return v
+}
+
+// openAPIBodyFields the fields without the `uri` and `header` tag, they are the parameters
+func openAPIBodyFields(rt reflect.Type, fields []RpcFieldType) []RpcFieldType {
+	excluded := map[string]bool{}
+	openAPINonBodyFields(rt, excluded)
+	if len(excluded) <= 0 {
+		return fields
+	}
+	vals := make([]RpcFieldType, 0, len(fields))
+	for _, f := range fields {
+		if !excluded[f.Name] {
+			vals = append(vals, f)
+		}
+	}
+	return vals
+}
+
+func openAPINonBodyFields(rt reflect.Type, excluded map[string]bool) {
+	if rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+	}
+	if rt.Kind() != reflect.Struct {
+		return
+	}
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			openAPINonBodyFields(f.Type, excluded)
+			continue
+		}
+		if len(f.Tag.Get("uri")) <= 0 && len(f.Tag.Get("header")) <= 0 {
+			continue
+		}
+		if name := strings.Split(f.Tag.Get("json"), ",")[0]; len(name) > 0 {
+			excluded[name] = true
+		}
+	}
+}
+
+// openAPIPath e.g. /items/:id => /items/{id}
+func openAPIPath(uri string) string {
+	parts := strings.Split(uri, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") || strings.HasPrefix(part, "*") {
+			parts[i] = "{" + part[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+var openAPIParamTags = map[string]string{
+	"query":  "form",
+	"path":   "uri",
+	"header": "header",
+}
+
+// openAPIParams the path and header params are the fields with `uri` and `header` tag,
+// the query params are bound by the `form` tag, or the field name
+func openAPIParams(rt reflect.Type, in string) (params []gin.H) {
+	if rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+	}
+	if rt.Kind() != reflect.Struct {
+		return nil
+	}
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			params = append(params, openAPIParams(f.Type, in)...)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if in == "query" && (len(f.Tag.Get("uri")) > 0 || len(f.Tag.Get("header")) > 0) {
+			continue
+		}
+		name := strings.Split(f.Tag.Get(openAPIParamTags[in]), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if len(name) <= 0 {
+			if in != "query" {
+				continue
+			}
+			name = f.Name
+		}
+		field := parseResultType(f.Type, name, nil)
+		parseFieldTags(&field, f)
+		params = append(params, gin.H{
+			"name":     name,
+			"in":       in,
+			"required": field.Required || in == "path",
+			"schema":   openAPISchema(field),
+		})
+	}
+	return params
+}
+
+func openAPIErrorSchema() gin.H {
+	lines := []string{"The fail code and message, `code` is not 200"}
+	codes := RpcErrCodes()
+	for _, v := range codes {
+		line := "- " + strconv.Itoa(v.Code) + ": " + v.Desc
+		if len(v.Key) > 0 {
+			line += " (`" + v.Key + "`)"
+		}
+		lines = append(lines, line)
+	}
+
+	schema := openAPIObject(gin.H{
+		"code":    gin.H{"type": "integer"},
+		"key":     gin.H{"type": "string"},
+		"msg":     gin.H{"type": "string"},
+		"details": gin.H{},
+		"fields": gin.H{
+			"type":                 "object",
+			"additionalProperties": gin.H{"type": "array", "items": gin.H{"type": "string"}},
+		},
+	}, []string{"code", "msg"})
+	schema["description"] = strings.Join(lines, "\n")
+	schema["x-error-codes"] = codes
+	return schema
+}
+
+// OpenAPIYAML the yaml of the json document, the structs are marshaled by the json tags
+func (reg *RpcRegistry) OpenAPIYAML(cfg *GinExt) ([]byte, error) {
+	data, err := json.Marshal(reg.OpenAPISpec(cfg))
+	if err != nil {
+		return nil, err
+	}
+	var spec yaml.MapSlice
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(spec)
+}
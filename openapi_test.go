@@ -0,0 +1,141 @@
+package ginext
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+type testOpenAPIForm struct {
+	Kind  string          `json:"kind" form:"kind" binding:"required,oneof=cat dog" example:"cat"`
+	Name  *string         `json:"name" form:"name"`
+	Owner *UserInfoResult `json:"owner" form:"-"`
+}
+
+type testOpenAPIPetForm struct {
+	ID    uint   `json:"id" uri:"id"`
+	Trace string `json:"trace" header:"X-Trace"`
+	Age   int    `json:"age" binding:"oneof=1 2" example:"2"`
+}
+
+func TestOpenAPI(t *testing.T) {
+	cfg := NewGinExt("..")
+	cfg.Init()
+
+	r := gin.Default()
+	cfg.WithGinExt(r)
+
+	RegistryOf(r).AddAppLabel("Mock")
+	RpcDefine(r, &RpcContext{
+		Form:         testOpenAPIForm{},
+		Result:       UserInfoResult{},
+		AuthRequired: true,
+		RelativePath: "/mockapi/pet",
+		Handler: func(c *gin.Context) {
+		},
+		Doc: "Create a pet\nThe kind is cat or dog",
+	})
+	RpcDefine(r, &RpcContext{
+		Form:            RegisterUserForm{},
+		Result:          UserInfoResult{},
+		OnlyPost:        true,
+		ReduceDataField: true,
+		RelativePath:    "/mockapi/reduce",
+		Handler: func(c *gin.Context) {
+		},
+	})
+
+	RpcDefine(r, &RpcContext{
+		Form:         testOpenAPIPetForm{},
+		Result:       true,
+		OnlyPost:     true,
+		RelativePath: "/mockapi/pet/:id",
+		Handler: func(c *gin.Context) {
+		},
+	})
+
+	client := NewTestHTTPClient(r)
+	w := client.Get(ApiOpenAPIJSONUri)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var spec map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &spec)
+	assert.Nil(t, err)
+	assert.Equal(t, OpenAPIVersion, spec["openapi"])
+
+	paths := spec["paths"].(map[string]interface{})
+	pet := paths["/mockapi/pet"].(map[string]interface{})
+	assert.Contains(t, pet, "get")
+
+	post := pet["post"].(map[string]interface{})
+	assert.Equal(t, "mockapi_pet", post["operationId"])
+	assert.Equal(t, "Create a pet", post["summary"])
+	assert.Equal(t, []interface{}{"Mock"}, post["tags"])
+	assert.Equal(t, 2, len(post["security"].([]interface{})))
+
+	body := post["requestBody"].(map[string]interface{})["content"].(map[string]interface{})["application/json"].(map[string]interface{})["schema"].(map[string]interface{})
+	assert.Equal(t, []interface{}{"kind"}, body["required"])
+	props := body["properties"].(map[string]interface{})
+	kind := props["kind"].(map[string]interface{})
+	assert.Equal(t, []interface{}{"cat", "dog"}, kind["enum"])
+	assert.Equal(t, "cat", kind["example"])
+	assert.Equal(t, true, props["name"].(map[string]interface{})["nullable"])
+	owner := props["owner"].(map[string]interface{})
+	assert.Equal(t, "object", owner["type"])
+	assert.Contains(t, owner["properties"], "lastLogin")
+
+	responses := post["responses"].(map[string]interface{})
+	assert.Contains(t, responses, "401")
+	envelope := responses["200"].(map[string]interface{})["content"].(map[string]interface{})["application/json"].(map[string]interface{})["schema"].(map[string]interface{})
+	data := envelope["properties"].(map[string]interface{})["data"].(map[string]interface{})
+	lastLogin := data["properties"].(map[string]interface{})["lastLogin"].(map[string]interface{})
+	assert.Equal(t, "date-time", lastLogin["format"])
+	assert.Equal(t, true, lastLogin["nullable"])
+
+	get := pet["get"].(map[string]interface{})
+	assert.Equal(t, "mockapi_pet_get", get["operationId"])
+	params := get["parameters"].([]interface{})
+	assert.Equal(t, 2, len(params))
+	assert.Equal(t, "kind", params[0].(map[string]interface{})["name"])
+	assert.Equal(t, true, params[0].(map[string]interface{})["required"])
+
+	petID := paths["/mockapi/pet/{id}"].(map[string]interface{})["post"].(map[string]interface{})
+	assert.Equal(t, 2, len(petID["parameters"].([]interface{})))
+	petBody := petID["requestBody"].(map[string]interface{})["content"].(map[string]interface{})["application/json"].(map[string]interface{})["schema"].(map[string]interface{})
+	petProps := petBody["properties"].(map[string]interface{})
+	assert.NotContains(t, petProps, "id")
+	assert.NotContains(t, petProps, "trace")
+	age := petProps["age"].(map[string]interface{})
+	assert.Equal(t, []interface{}{float64(1), float64(2)}, age["enum"])
+	assert.Equal(t, float64(2), age["example"])
+
+	reduce := paths["/mockapi/reduce"].(map[string]interface{})
+	assert.NotContains(t, reduce, "get")
+	reduceResp := reduce["post"].(map[string]interface{})["responses"].(map[string]interface{})
+	assert.Contains(t, reduceResp, "default")
+	reduceSchema := reduceResp["200"].(map[string]interface{})["content"].(map[string]interface{})["application/json"].(map[string]interface{})["schema"].(map[string]interface{})
+	assert.Contains(t, reduceSchema["properties"], "username")
+
+	components := spec["components"].(map[string]interface{})
+	schemes := components["securitySchemes"].(map[string]interface{})
+	assert.Equal(t, cfg.SessionName, schemes["sessionCookie"].(map[string]interface{})["name"])
+	assert.Equal(t, "bearer", schemes["bearerAuth"].(map[string]interface{})["scheme"])
+	rpcError := components["schemas"].(map[string]interface{})["RpcError"].(map[string]interface{})
+	assert.Contains(t, rpcError["description"], "10002: Bad username or password")
+
+	w = client.Get(ApiOpenAPIYAMLUri)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var yamlSpec map[string]interface{}
+	err = yaml.Unmarshal(w.Body.Bytes(), &yamlSpec)
+	assert.Nil(t, err)
+	assert.Equal(t, OpenAPIVersion, yamlSpec["openapi"])
+	assert.Contains(t, yamlSpec["paths"], "/mockapi/pet")
+	yamlError := yamlSpec["components"].(map[interface{}]interface{})["schemas"].(map[interface{}]interface{})["RpcError"].(map[interface{}]interface{})
+	yamlCode := yamlError["x-error-codes"].([]interface{})[0].(map[interface{}]interface{})
+	assert.Contains(t, yamlCode, "code")
+	assert.NotContains(t, yamlCode, "Code")
+}
@@ -0,0 +1,503 @@
+package ginext
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+/*
+	/org/create
+	/org/list
+	/org/switch
+	/org/members
+	/org/member/role
+	/org/member/remove
+	/org/invite
+	/org/invite/accept
+	/org/invite/decline
+*/
+
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+// orgRoleRanks the roles are upgraded by the invitation, not downgraded
+var orgRoleRanks = map[string]int{
+	OrgRoleMember: 1,
+	OrgRoleAdmin:  2,
+	OrgRoleOwner:  3,
+}
+
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationDeclined = "declined"
+)
+
+const defaultOrgInviteExpired = 7 * 24 * time.Hour
+const orgInviteCodeLength = 12
+
+// OrgScoped the models of organization, the crud helpers filter them by the current organization
+// of request, and fill the column of the new objects. The request without current organization is rejected
+type OrgScoped interface {
+	OrgColumn() string
+}
+
+type OrgCreateForm struct {
+	Name string `json:"name" binding:"required,max=128"`
+}
+
+type OrgForm struct {
+	ID uint `json:"id" binding:"required"`
+}
+
+type OrgResult struct {
+	ID      uint   `json:"id"`
+	Name    string `json:"name"`
+	OwnerID uint   `json:"ownerId"`
+	Role    string `json:"role"`
+}
+
+type OrgMemberResult struct {
+	UserID      uint      `json:"userId"`
+	UserName    string    `json:"username"`
+	Email       string    `json:"email"`
+	DisplayName string    `json:"displayName"`
+	Role        string    `json:"role"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+type OrgMemberForm struct {
+	UserID uint   `json:"userId" binding:"required"`
+	Role   string `json:"role" binding:"omitempty,oneof=admin member"`
+}
+
+type OrgInviteForm struct {
+	Email  string `json:"email" binding:"required,email"`
+	Role   string `json:"role" binding:"omitempty,oneof=admin member"`
+	Locale string `json:"locale"`
+}
+
+type OrgInviteResult struct {
+	ID        uint      `json:"id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	ExpiredAt time.Time `json:"expiredAt"`
+}
+
+// OrgInviteTokenForm the token is sent by SigOrgInvite
+type OrgInviteTokenForm struct {
+	Token string `json:"token" binding:"required"`
+}
+
+const docOrgCreate = `Create the organization, the user is the owner and switched to it`
+const docOrgList = `List the organizations of user`
+const docOrgSwitch = `Switch the current organization of session or accesstoken`
+const docOrgMembers = `List the members of current organization`
+const docOrgMemberRole = `Change the role of member, owner or admin only`
+const docOrgMemberRemove = `Remove the member, owner or admin only, the members can remove themselves`
+const docOrgInvite = `Invite the user by email, owner or admin only`
+const docOrgInviteAccept = `Accept the invitation with the token of email, the email of user must be matched`
+const docOrgInviteDecline = `Decline the invitation with the token of email`
+
+func newOrgResult(m *GinMembership) OrgResult {
+	return OrgResult{
+		ID:      m.Org.ID,
+		Name:    m.Org.Name,
+		OwnerID: m.Org.OwnerID,
+		Role:    m.Role,
+	}
+}
+
+// CurrentMembership the membership of current user in the current organization,
+// the organization is from the accesstoken or the session
+func CurrentMembership(c *gin.Context) *GinMembership {
+	if obj, ok := c.Get(OrgIdField); ok && obj != nil {
+		return obj.(*GinMembership)
+	}
+	user := CurrentUser(c)
+	if user == nil {
+		return nil
+	}
+	var orgID uint
+	if token := CurrentToken(c); token != nil && token.OrgID > 0 {
+		orgID = token.OrgID
+	} else {
+		orgID, _ = sessions.Default(c).Get(OrgIdField).(uint)
+	}
+	if orgID <= 0 {
+		return nil
+	}
+
+	um := c.MustGet(UserMangerField).(*UserManager)
+	var m GinMembership
+	if um.db.Where("org_id", orgID).Where("user_id", user.ID).Preload("Org").Take(&m).Error != nil {
+		return nil
+	}
+	c.Set(OrgIdField, &m)
+	return &m
+}
+
+// CurrentOrganization the current organization of user, nil when not selected or not a member
+func CurrentOrganization(c *gin.Context) *GinOrganization {
+	if m := CurrentMembership(c); m != nil {
+		return &m.Org
+	}
+	return nil
+}
+
+// currentOrgID the current organization of request, 0 without the UserManager
+func currentOrgID(c *gin.Context) uint {
+	if c == nil {
+		return 0
+	}
+	if obj, ok := c.Get(OrgIdField); ok && obj != nil {
+		return obj.(*GinMembership).OrgID
+	}
+	if _, ok := c.Get(UserMangerField); !ok {
+		return 0
+	}
+	if _, ok := c.Get(sessions.DefaultKey); !ok {
+		return 0
+	}
+	if m := CurrentMembership(c); m != nil {
+		return m.OrgID
+	}
+	return 0
+}
+
+// setCurrentMembership switch the organization of session, and the accesstoken of request
+func (um *UserManager) setCurrentMembership(c *gin.Context, m *GinMembership) {
+	c.Set(OrgIdField, m)
+	session := sessions.Default(c)
+	session.Set(OrgIdField, m.OrgID)
+	session.Save()
+	if token := CurrentToken(c); token != nil {
+		token.OrgID = m.OrgID
+		um.db.Model(token).UpdateColumn("org_id", m.OrgID)
+	}
+}
+
+// orgScope filter the db by the current organization when the model is OrgScoped,
+// false when the model is OrgScoped and no current organization
+func orgScope(c *gin.Context, db *gorm.DB, model interface{}) (*gorm.DB, uint, bool) {
+	scoped, ok := model.(OrgScoped)
+	if !ok || c == nil {
+		return db, 0, true
+	}
+	orgID := currentOrgID(c)
+	if orgID <= 0 {
+		return db, 0, false
+	}
+	return db.Where(scoped.OrgColumn(), orgID).Session(&gorm.Session{}), orgID, true
+}
+
+// setOrgColumn fill the column of OrgScoped model
+func setOrgColumn(db *gorm.DB, modPtr interface{}, orgID uint) error {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(modPtr); err != nil {
+		return err
+	}
+	field := stmt.Schema.LookUpField(modPtr.(OrgScoped).OrgColumn())
+	if field == nil {
+		return errors.New("bad org column")
+	}
+	return field.Set(reflect.ValueOf(modPtr), orgID)
+}
+
+// deleteOrgColumn delete the org column of vals by the field name or the db name, e.g. OrgID and org_id
+func deleteOrgColumn(db *gorm.DB, modPtr interface{}, vals map[string]interface{}) {
+	column := modPtr.(OrgScoped).OrgColumn()
+	delete(vals, column)
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(modPtr); err != nil {
+		return
+	}
+	field := stmt.Schema.LookUpField(column)
+	if field == nil {
+		return
+	}
+	for k := range vals {
+		if stmt.Schema.LookUpField(k) == field {
+			delete(vals, k)
+		}
+	}
+}
+
+// OrgRequired the current user must be a member of the current organization, with one of roles when not empty
+func (um *UserManager) OrgRequired(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if CurrentUser(c) == nil {
+			RpcAbort(c, ErrAuthRequired)
+			return
+		}
+		m := CurrentMembership(c)
+		if m == nil {
+			RpcAbort(c, ErrOrgRequired)
+			return
+		}
+		if len(roles) > 0 {
+			allowed := false
+			for _, v := range roles {
+				allowed = allowed || v == m.Role
+			}
+			if !allowed {
+				RpcAbort(c, NewRpcErr(ErrCodeNotAllowed, "role not allowed"))
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// CreateOrganization the user is the owner
+func (um *UserManager) CreateOrganization(user *GinExtUser, name string) (m *GinMembership, err error) {
+	m = &GinMembership{
+		UserID: user.ID,
+		Role:   OrgRoleOwner,
+		Org: GinOrganization{
+			Name:    name,
+			OwnerID: user.ID,
+		},
+	}
+	err = um.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&m.Org).Error; err != nil {
+			return err
+		}
+		m.OrgID = m.Org.ID
+		return tx.Omit("Org", "User").Create(m).Error
+	})
+	return m, err
+}
+
+// GetMemberships the organizations of user
+func (um *UserManager) GetMemberships(user *GinExtUser) (vals []GinMembership, err error) {
+	result := um.db.Where("user_id", user.ID).Preload("Org").Order("id").Find(&vals)
+	return vals, result.Error
+}
+
+// AddMember add the user, or upgrade the role of member, the role is never downgraded
+func (um *UserManager) AddMember(orgID uint, user *GinExtUser, role string) (*GinMembership, error) {
+	var m GinMembership
+	if um.db.Where("org_id", orgID).Where("user_id", user.ID).Take(&m).Error == nil {
+		if orgRoleRanks[role] > orgRoleRanks[m.Role] {
+			um.db.Model(&m).UpdateColumn("role", role)
+		}
+	} else {
+		m = GinMembership{OrgID: orgID, UserID: user.ID, Role: role}
+		if err := um.db.Omit("Org", "User").Create(&m).Error; err != nil {
+			return nil, err
+		}
+	}
+	if err := um.db.Preload("Org").Take(&m, m.ID).Error; err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// RegisterOrgHandler the rpc of organizations, require RegisterHandler first
+func (um *UserManager) RegisterOrgHandler(prefix string, r *gin.Engine) {
+	api := um.ext.Registry.Router(r)
+	api.Registry.AddAppLabel("Organization")
+
+	orgAdmin := WithMiddlewares(um.OrgRequired(OrgRoleOwner, OrgRoleAdmin))
+	Rpc(api, prefix+"/create", um.handleOrgCreate, WithAuthRequired(), WithOnlyPost(), WithDoc(docOrgCreate))
+	Rpc(api, prefix+"/list", um.handleOrgList, WithAuthRequired(), WithOnlyPost(), WithDoc(docOrgList))
+	Rpc(api, prefix+"/switch", um.handleOrgSwitch, WithAuthRequired(), WithOnlyPost(), WithDoc(docOrgSwitch))
+	Rpc(api, prefix+"/members", um.handleOrgMembers, WithMiddlewares(um.OrgRequired()), WithOnlyPost(), WithDoc(docOrgMembers))
+	Rpc(api, prefix+"/member/role", um.handleOrgMemberRole, orgAdmin, WithOnlyPost(), WithDoc(docOrgMemberRole))
+	Rpc(api, prefix+"/member/remove", um.handleOrgMemberRemove, WithMiddlewares(um.OrgRequired()), WithOnlyPost(), WithDoc(docOrgMemberRemove))
+	Rpc(api, prefix+"/invite", um.handleOrgInvite, orgAdmin, WithOnlyPost(), WithDoc(docOrgInvite))
+	Rpc(api, prefix+"/invite/accept", um.handleOrgInviteAccept, WithAuthRequired(), WithOnlyPost(), WithDoc(docOrgInviteAccept))
+	Rpc(api, prefix+"/invite/decline", um.handleOrgInviteDecline, WithOnlyPost(), WithDoc(docOrgInviteDecline))
+}
+
+func (um *UserManager) handleOrgCreate(c *gin.Context, form *OrgCreateForm) (r OrgResult, err error) {
+	m, err := um.CreateOrganization(CurrentUser(c), form.Name)
+	if err != nil {
+		return r, NewRpcErr(ErrCodeServerError, "create organization fail")
+	}
+	um.setCurrentMembership(c, m)
+	return newOrgResult(m), nil
+}
+
+func (um *UserManager) handleOrgList(c *gin.Context, form *struct{}) ([]OrgResult, error) {
+	vals, err := um.GetMemberships(CurrentUser(c))
+	if err != nil {
+		return nil, err
+	}
+	r := make([]OrgResult, 0, len(vals))
+	for i := range vals {
+		r = append(r, newOrgResult(&vals[i]))
+	}
+	return r, nil
+}
+
+func (um *UserManager) handleOrgSwitch(c *gin.Context, form *OrgForm) (r OrgResult, err error) {
+	var m GinMembership
+	if um.db.Where("org_id", form.ID).Where("user_id", CurrentUser(c).ID).Preload("Org").Take(&m).Error != nil {
+		return r, NewRpcErr(ErrCodeNotAllowed, "not a member")
+	}
+	um.setCurrentMembership(c, &m)
+	return newOrgResult(&m), nil
+}
+
+func (um *UserManager) handleOrgMembers(c *gin.Context, form *struct{}) ([]OrgMemberResult, error) {
+	var vals []GinMembership
+	if err := um.db.Where("org_id", CurrentMembership(c).OrgID).Preload("User").Order("id").Find(&vals).Error; err != nil {
+		return nil, err
+	}
+	r := make([]OrgMemberResult, 0, len(vals))
+	for _, v := range vals {
+		r = append(r, OrgMemberResult{
+			UserID:      v.UserID,
+			UserName:    v.User.UserName,
+			Email:       v.User.Email,
+			DisplayName: v.User.DisplayName,
+			Role:        v.Role,
+			CreatedAt:   v.CreatedAt,
+		})
+	}
+	return r, nil
+}
+
+// orgMember the member of current organization, the owner is not changeable
+func (um *UserManager) orgMember(c *gin.Context, userID uint) (*GinMembership, error) {
+	var m GinMembership
+	if um.db.Where("org_id", CurrentMembership(c).OrgID).Where("user_id", userID).Take(&m).Error != nil {
+		return nil, NewRpcErr(ErrCodeInvalidParams, "member not found")
+	}
+	if m.Role == OrgRoleOwner {
+		return nil, NewRpcErr(ErrCodeNotAllowed, "owner is not changeable")
+	}
+	return &m, nil
+}
+
+// handleOrgMemberRole only the owner promotes to admin or changes the admins, same as the invitation of admin
+func (um *UserManager) handleOrgMemberRole(c *gin.Context, form *OrgMemberForm) (bool, error) {
+	m, err := um.orgMember(c, form.UserID)
+	if err != nil {
+		return false, err
+	}
+	role := form.Role
+	if len(role) <= 0 {
+		role = OrgRoleMember
+	}
+	if (role == OrgRoleAdmin || m.Role == OrgRoleAdmin) && CurrentMembership(c).Role != OrgRoleOwner {
+		return false, NewRpcErr(ErrCodeNotAllowed, "only owner can change admin")
+	}
+	return true, um.db.Model(m).UpdateColumn("role", role).Error
+}
+
+func (um *UserManager) handleOrgMemberRemove(c *gin.Context, form *OrgMemberForm) (bool, error) {
+	current := CurrentMembership(c)
+	if form.UserID != current.UserID && current.Role != OrgRoleOwner && current.Role != OrgRoleAdmin {
+		return false, NewRpcErr(ErrCodeNotAllowed, "role not allowed")
+	}
+	m, err := um.orgMember(c, form.UserID)
+	if err != nil {
+		return false, err
+	}
+	if m.UserID != current.UserID && m.Role == OrgRoleAdmin && current.Role != OrgRoleOwner {
+		return false, NewRpcErr(ErrCodeNotAllowed, "only owner can remove admin")
+	}
+	return true, um.db.Delete(m).Error
+}
+
+// handleOrgInvite the token of invitation is sent by SigOrgInvite
+func (um *UserManager) handleOrgInvite(c *gin.Context, form *OrgInviteForm) (r OrgInviteResult, err error) {
+	user := CurrentUser(c)
+	m := CurrentMembership(c)
+	email := strings.ToLower(form.Email)
+	role := form.Role
+	if len(role) <= 0 {
+		role = OrgRoleMember
+	}
+	if role == OrgRoleAdmin && m.Role != OrgRoleOwner {
+		return r, NewRpcErr(ErrCodeNotAllowed, "only owner can invite admin")
+	}
+	if member, err := um.GetByEmail(email); err == nil {
+		var count int64
+		um.db.Model(&GinMembership{}).Where("org_id", m.OrgID).Where("user_id", member.ID).Count(&count)
+		if count > 0 {
+			return r, NewRpcErr(ErrCodeInvalidParams, "user is a member")
+		}
+	}
+
+	key, code := um.newVerifyCode(nil, email, RandText(orgInviteCodeLength), um.OrgInviteExpired)
+	if len(key) <= 0 {
+		return r, NewRpcErr(ErrCodeServerError, "create verify code fail")
+	}
+	invitation := GinInvitation{
+		OrgID:     m.OrgID,
+		InviterID: user.ID,
+		Email:     email,
+		Role:      role,
+		Key:       key,
+		Status:    InvitationPending,
+		ExpiredAt: time.Now().Add(um.OrgInviteExpired),
+	}
+	if err := um.db.Omit("Org").Create(&invitation).Error; err != nil {
+		return r, err
+	}
+
+	Sig().Emit(SigOrgInvite, user, &m.Org, email, key+"."+code, CurrentLocale(c), c)
+	return OrgInviteResult{
+		ID:        invitation.ID,
+		Email:     invitation.Email,
+		Role:      invitation.Role,
+		ExpiredAt: invitation.ExpiredAt,
+	}, nil
+}
+
+// useInvitation verify the token and change the status of pending invitation,
+// the email of invitation must be matched when not empty
+func (um *UserManager) useInvitation(token, email, status string) (*GinInvitation, error) {
+	vals := strings.Split(token, ".")
+	if len(vals) != 2 {
+		return nil, NewRpcErr(ErrCodeBadVerifyCode, "bad invitation")
+	}
+	var invitation GinInvitation
+	if um.db.Where("key", vals[0]).Where("status", InvitationPending).Take(&invitation).Error != nil {
+		return nil, NewRpcErr(ErrCodeBadVerifyCode, "bad invitation")
+	}
+	if len(email) > 0 && !strings.EqualFold(email, invitation.Email) {
+		return nil, NewRpcErr(ErrCodeNotAllowed, "invitation of other email")
+	}
+	if !um.verifyCode(invitation.Key, invitation.Email, vals[1]) {
+		return nil, NewRpcErr(ErrCodeBadVerifyCode, "bad invitation")
+	}
+	if err := um.db.Model(&invitation).UpdateColumn("status", status).Error; err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+func (um *UserManager) handleOrgInviteAccept(c *gin.Context, form *OrgInviteTokenForm) (r OrgResult, err error) {
+	user := CurrentUser(c)
+	invitation, err := um.useInvitation(form.Token, user.Email, InvitationAccepted)
+	if err != nil {
+		return r, err
+	}
+	m, err := um.AddMember(invitation.OrgID, user, invitation.Role)
+	if err != nil {
+		return r, err
+	}
+	um.setCurrentMembership(c, m)
+	return newOrgResult(m), nil
+}
+
+func (um *UserManager) handleOrgInviteDecline(c *gin.Context, form *OrgInviteTokenForm) (bool, error) {
+	if _, err := um.useInvitation(form.Token, "", InvitationDeclined); err != nil {
+		return false, err
+	}
+	return true, nil
+}
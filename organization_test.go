@@ -0,0 +1,204 @@
+package ginext
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type orgObj struct {
+	ID    uint `gorm:"primarykey"`
+	OrgID uint
+	Title string
+}
+
+func (orgObj) OrgColumn() string {
+	return "org_id"
+}
+
+type orgObjListResult struct {
+	PaginationResult
+	Items []orgObj `json:"items"`
+}
+
+func TestOrganization(t *testing.T) {
+	um, r := NewTestUserManager()
+	um.RegisterHandler("/auth", r)
+	um.RegisterOrgHandler("/org", r)
+	alice := NewTestHTTPClient(r)
+	bob := NewTestHTTPClient(r)
+	addUser(t, alice, r, "alice", "alice@example.org", "hello123")
+	addUser(t, bob, r, "bob", "bob@example.org", "hello123")
+
+	var tokens, inviters []string
+	sid := Sig().Connect(SigOrgInvite, func(sender interface{}, params ...interface{}) {
+		inviters = append(inviters, sender.(*GinExtUser).UserName)
+		assert.Equal(t, "acme", params[0].(*GinOrganization).Name)
+		tokens = append(tokens, params[2].(string))
+	})
+	defer Sig().Disconnect(SigOrgInvite, sid)
+
+	var info UserInfoResult
+	assert.Nil(t, alice.Call("/auth/login", LoginForm{UserName: "alice", Password: "hello123"}, &info))
+	assert.Nil(t, bob.Call("/auth/login", LoginForm{UserName: "bob", Password: "hello123"}, &info))
+
+	// no current organization
+	w := alice.Post("/org/members", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	var org OrgResult
+	assert.Nil(t, alice.Call("/org/create", OrgCreateForm{Name: "acme"}, &org))
+	assert.Equal(t, OrgRoleOwner, org.Role)
+
+	err := bob.Call("/org/switch", OrgForm{ID: org.ID}, nil)
+	assert.NotNil(t, err)
+	err = bob.Call("/org/invite", OrgInviteForm{Email: "alice@example.org"}, nil)
+	assert.NotNil(t, err)
+
+	var invite OrgInviteResult
+	assert.Nil(t, alice.Call("/org/invite", OrgInviteForm{Email: "Bob@example.org"}, &invite))
+	assert.Equal(t, "bob@example.org", invite.Email)
+	assert.Equal(t, OrgRoleMember, invite.Role)
+	assert.Equal(t, 1, len(tokens))
+
+	// the invitation of other email
+	err = alice.Call("/org/invite/accept", OrgInviteTokenForm{Token: tokens[0]}, nil)
+	assert.NotNil(t, err)
+	err = bob.Call("/org/invite/accept", OrgInviteTokenForm{Token: tokens[0] + "x"}, nil)
+	assert.NotNil(t, err)
+
+	var joined OrgResult
+	assert.Nil(t, bob.Call("/org/invite/accept", OrgInviteTokenForm{Token: tokens[0]}, &joined))
+	assert.Equal(t, org.ID, joined.ID)
+	assert.Equal(t, OrgRoleMember, joined.Role)
+	err = bob.Call("/org/invite/accept", OrgInviteTokenForm{Token: tokens[0]}, nil)
+	assert.NotNil(t, err)
+
+	var members []OrgMemberResult
+	assert.Nil(t, bob.Call("/org/members", nil, &members))
+	assert.Equal(t, 2, len(members))
+	assert.Equal(t, "bob", members[1].UserName)
+
+	// the member can not invite
+	err = bob.Call("/org/invite", OrgInviteForm{Email: "carol@example.org"}, nil)
+	assert.NotNil(t, err)
+	assert.Nil(t, alice.Call("/org/member/role", OrgMemberForm{UserID: members[1].UserID, Role: OrgRoleAdmin}, nil))
+	assert.Nil(t, bob.Call("/org/invite", OrgInviteForm{Email: "carol@example.org"}, nil))
+	// only owner invites admin
+	err = bob.Call("/org/invite", OrgInviteForm{Email: "dave@example.org", Role: OrgRoleAdmin}, nil)
+	assert.NotNil(t, err)
+	err = bob.Call("/org/member/remove", OrgMemberForm{UserID: members[0].UserID}, nil)
+	assert.NotNil(t, err)
+
+	// only owner changes the admins
+	erin := NewTestHTTPClient(r)
+	addUser(t, erin, r, "erin", "erin@example.org", "hello123")
+	erinUser, _ := um.Get("erin")
+	_, err = um.AddMember(org.ID, erinUser, OrgRoleMember)
+	assert.Nil(t, err)
+	err = bob.Call("/org/member/role", OrgMemberForm{UserID: erinUser.ID, Role: OrgRoleAdmin}, nil)
+	assert.NotNil(t, err)
+	assert.Nil(t, alice.Call("/org/member/role", OrgMemberForm{UserID: erinUser.ID, Role: OrgRoleAdmin}, nil))
+	err = bob.Call("/org/member/role", OrgMemberForm{UserID: erinUser.ID, Role: OrgRoleMember}, nil)
+	assert.NotNil(t, err)
+	err = bob.Call("/org/member/remove", OrgMemberForm{UserID: erinUser.ID}, nil)
+	assert.NotNil(t, err)
+	// the invitation never downgrades
+	m, err := um.AddMember(org.ID, erinUser, OrgRoleMember)
+	assert.Nil(t, err)
+	assert.Equal(t, OrgRoleAdmin, m.Role)
+	assert.Nil(t, alice.Call("/org/member/remove", OrgMemberForm{UserID: erinUser.ID}, nil))
+
+	assert.Equal(t, []string{"alice", "bob"}, inviters)
+	assert.Nil(t, bob.Call("/org/invite/decline", OrgInviteTokenForm{Token: tokens[1]}, nil))
+	var invitation GinInvitation
+	um.db.Where("email", "carol@example.org").Take(&invitation)
+	assert.Equal(t, InvitationDeclined, invitation.Status)
+
+	var orgs []OrgResult
+	assert.Nil(t, bob.Call("/org/list", nil, &orgs))
+	assert.Equal(t, 1, len(orgs))
+
+	// leave the organization
+	assert.Nil(t, bob.Call("/org/member/remove", OrgMemberForm{UserID: members[1].UserID}, nil))
+	w = bob.Post("/org/members", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// the current organization is cleared by login
+	assert.Nil(t, alice.Call("/auth/login", LoginForm{UserName: "alice", Password: "hello123"}, &info))
+	w = alice.Post("/org/members", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestOrgScopedCRUD(t *testing.T) {
+	um, r := NewTestUserManager()
+	um.db.AutoMigrate(&orgObj{})
+	um.RegisterHandler("/auth", r)
+	um.RegisterOrgHandler("/org", r)
+	r.POST("/obj/create", func(c *gin.Context) {
+		var obj orgObj
+		c.BindJSON(&obj)
+		NewObject(c, um.db, &obj)
+	})
+	r.POST("/obj/list", func(c *gin.Context) {
+		var result orgObjListResult
+		ListObject(c, um.db.Model(&orgObj{}), &result, nil, "id", "")
+	})
+	r.POST("/obj/edit", func(c *gin.Context) {
+		var obj orgObj
+		c.BindJSON(&obj)
+		EditObject(c, um.db, &orgObj{}, obj.ID, map[string]interface{}{"title": obj.Title, "org_id": obj.OrgID})
+	})
+	r.POST("/obj/move", func(c *gin.Context) {
+		var obj orgObj
+		c.BindJSON(&obj)
+		EditObject(c, um.db, &orgObj{}, obj.ID, map[string]interface{}{"OrgID": obj.OrgID})
+	})
+	r.POST("/obj/delete", func(c *gin.Context) {
+		var obj orgObj
+		c.BindJSON(&obj)
+		DeleteObject(c, um.db, &orgObj{}, obj.ID, false)
+	})
+
+	client := NewTestHTTPClient(r)
+	addUser(t, client, r, "alice", "alice@example.org", "hello123")
+	var info UserInfoResult
+	assert.Nil(t, client.Call("/auth/login", LoginForm{UserName: "alice", Password: "hello123"}, &info))
+
+	w := client.Post("/obj/create", map[string]interface{}{"Title": "hello"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = client.Post("/obj/list", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	var acme, other OrgResult
+	assert.Nil(t, client.Call("/org/create", OrgCreateForm{Name: "acme"}, &acme))
+	var obj orgObj
+	assert.Nil(t, client.Call("/obj/create", map[string]interface{}{"Title": "hello", "OrgID": 1000}, &obj))
+	assert.Equal(t, acme.ID, obj.OrgID)
+
+	assert.Nil(t, client.Call("/org/create", OrgCreateForm{Name: "other"}, &other))
+	var result orgObjListResult
+	assert.Nil(t, client.Call("/obj/list", nil, &result))
+	assert.Equal(t, 0, result.TotalCount)
+
+	var ok bool
+	assert.Nil(t, client.Call("/obj/delete", orgObj{ID: obj.ID}, &ok))
+	assert.False(t, ok)
+	err := client.Call("/obj/edit", orgObj{ID: obj.ID, Title: "world"}, nil)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, client.Call("/org/switch", OrgForm{ID: acme.ID}, nil))
+	assert.Nil(t, client.Call("/obj/list", nil, &result))
+	assert.Equal(t, 1, result.TotalCount)
+	var edited orgObj
+	assert.Nil(t, client.Call("/obj/edit", orgObj{ID: obj.ID, Title: "world", OrgID: other.ID}, &edited))
+	assert.Equal(t, "world", edited.Title)
+	assert.Equal(t, acme.ID, edited.OrgID)
+	// the field name of org column
+	assert.Nil(t, client.Call("/obj/move", orgObj{ID: obj.ID, OrgID: other.ID}, &edited))
+	assert.Equal(t, acme.ID, edited.OrgID)
+	assert.Nil(t, client.Call("/obj/delete", orgObj{ID: obj.ID}, &ok))
+	assert.True(t, ok)
+}
@@ -0,0 +1,325 @@
+package ginext
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The reasons of PasswordPolicyError
+const (
+	PasswordTooShort = "too_short"
+	PasswordTooLong  = "too_long"
+	PasswordNoLetter = "no_letter"
+	PasswordNoUpper  = "no_upper"
+	PasswordNoLower  = "no_lower"
+	PasswordNoDigit  = "no_digit"
+	PasswordNoSymbol = "no_symbol"
+	PasswordUserInfo = "user_info"
+	PasswordBanned   = "banned"
+	PasswordBreached = "breached"
+	PasswordReused   = "reused"
+)
+
+// PasswordRangeSource return the suffixes of the SHA-1 hashes with the 5 chars prefix, and the breach counts.
+// Only the prefix of hash is sent to the source (k-anonymity), e.g. LocalPasswordRange or the Pwned Passwords api
+type PasswordRangeSource interface {
+	Range(prefix string) (map[string]int, error)
+}
+
+// PasswordPolicy the rules of new passwords, checked by CheckNewPassword in all the handlers set the password
+type PasswordPolicy struct {
+	MinLength     int
+	MaxLength     int
+	RequireLetter bool
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// The password contains the username or the name of email is rejected
+	DisallowUserInfo bool
+	// The lower case passwords of the common password list
+	Banned map[string]bool
+	// The password found in the breached dataset is rejected
+	Breached PasswordRangeSource
+	// The last HistorySize passwords can not be reused
+	HistorySize int
+	// The password older than MaxAge must be changed at the next password login or token request, 0 is never expired.
+	// The magic link, sms code and passkey logins are exempted, they don't present the password
+	// and the user may not know it, the password is still renewed before it is used again
+	MaxAge time.Duration
+}
+
+// PasswordPolicyError the password violates the rules of policy
+type PasswordPolicyError struct {
+	Policy  *PasswordPolicy
+	Reasons []string
+}
+
+var passwordReasonMessages = map[string]string{
+	PasswordNoLetter: "contain letters",
+	PasswordNoUpper:  "contain upper case letters",
+	PasswordNoLower:  "contain lower case letters",
+	PasswordNoDigit:  "contain digits",
+	PasswordNoSymbol: "contain symbols",
+	PasswordUserInfo: "not contain the username or email",
+	PasswordBanned:   "not be a common password",
+	PasswordBreached: "not be a breached password",
+	PasswordReused:   "not be a recently used password",
+}
+
+func (e *PasswordPolicyError) Error() string {
+	msgs := make([]string, 0, len(e.Reasons))
+	for _, v := range e.Reasons {
+		switch v {
+		case PasswordTooShort:
+			msgs = append(msgs, fmt.Sprintf("be at least %d characters", e.Policy.MinLength))
+		case PasswordTooLong:
+			msgs = append(msgs, fmt.Sprintf("be at most %d characters", e.Policy.MaxLength))
+		default:
+			msgs = append(msgs, passwordReasonMessages[v])
+		}
+	}
+	return "password must " + strings.Join(msgs, ", ")
+}
+
+// DefaultPasswordPolicy at least 8 characters with letters and digits, as IsStrongPassword
+func DefaultPasswordPolicy() *PasswordPolicy {
+	return &PasswordPolicy{
+		MinLength:        8,
+		RequireLetter:    true,
+		RequireDigit:     true,
+		DisallowUserInfo: true,
+	}
+}
+
+// LoadBanned add the passwords of file into Banned, one password per line, # is comment
+func (p *PasswordPolicy) LoadBanned(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if p.Banned == nil {
+		p.Banned = map[string]bool{}
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) <= 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.Banned[strings.ToLower(line)] = true
+	}
+	return scanner.Err()
+}
+
+// Check the password without the history, user is the owner or the registering user, could be nil
+func (p *PasswordPolicy) Check(user *GinExtUser, password string) error {
+	var reasons []string
+	length := len([]rune(password))
+	if p.MinLength > 0 && length < p.MinLength {
+		reasons = append(reasons, PasswordTooShort)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		reasons = append(reasons, PasswordTooLong)
+	}
+
+	var hasUpper, hasLower, hasLetter, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper, hasLetter = true, true
+		case unicode.IsLower(r):
+			hasLower, hasLetter = true, true
+		case unicode.IsLetter(r):
+			hasLetter = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+	if p.RequireLetter && !hasLetter {
+		reasons = append(reasons, PasswordNoLetter)
+	}
+	if p.RequireUpper && !hasUpper {
+		reasons = append(reasons, PasswordNoUpper)
+	}
+	if p.RequireLower && !hasLower {
+		reasons = append(reasons, PasswordNoLower)
+	}
+	if p.RequireDigit && !hasDigit {
+		reasons = append(reasons, PasswordNoDigit)
+	}
+	if p.RequireSymbol && !hasSymbol {
+		reasons = append(reasons, PasswordNoSymbol)
+	}
+
+	lowerVal := strings.ToLower(password)
+	if p.DisallowUserInfo && user != nil {
+		infos := []string{strings.ToLower(user.UserName)}
+		if email := strings.ToLower(user.Email); len(email) > 0 {
+			infos = append(infos, strings.SplitN(email, "@", 2)[0])
+		}
+		for _, v := range infos {
+			// the short names are common substrings
+			if len(v) >= 3 && strings.Contains(lowerVal, v) {
+				reasons = append(reasons, PasswordUserInfo)
+				break
+			}
+		}
+	}
+	if p.Banned[lowerVal] {
+		reasons = append(reasons, PasswordBanned)
+	}
+	if p.Breached != nil && len(password) > 0 {
+		if count, err := BreachedCount(p.Breached, password); err == nil && count > 0 {
+			reasons = append(reasons, PasswordBreached)
+		}
+	}
+
+	if len(reasons) > 0 {
+		return &PasswordPolicyError{Policy: p, Reasons: reasons}
+	}
+	return nil
+}
+
+// BreachedCount how many times the password is breached, only the hash prefix is sent to the source
+func BreachedCount(source PasswordRangeSource, password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	suffixes, err := source.Range(hash[:5])
+	if err != nil {
+		return 0, err
+	}
+	return suffixes[hash[5:]], nil
+}
+
+// LocalPasswordRange the local dataset of breached passwords, e.g. the Pwned Passwords SHA-1 file
+type LocalPasswordRange struct {
+	ranges map[string]map[string]int
+}
+
+// LoadPasswordRange load the dataset of lines `<SHA-1 hex>[:count]`
+func LoadPasswordRange(name string) (*LocalPasswordRange, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := &LocalPasswordRange{ranges: map[string]map[string]int{}}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		vals := strings.SplitN(strings.TrimSpace(scanner.Text()), ":", 2)
+		hash := strings.ToUpper(vals[0])
+		if len(hash) != sha1.Size*2 {
+			continue
+		}
+		count := 1
+		if len(vals) == 2 {
+			if n, err := strconv.Atoi(vals[1]); err == nil {
+				count = n
+			}
+		}
+		prefix := hash[:5]
+		if r.ranges[prefix] == nil {
+			r.ranges[prefix] = map[string]int{}
+		}
+		r.ranges[prefix][hash[5:]] = count
+	}
+	return r, scanner.Err()
+}
+
+func (r *LocalPasswordRange) Range(prefix string) (map[string]int, error) {
+	return r.ranges[strings.ToUpper(prefix)], nil
+}
+
+// passwordPolicy DefaultPasswordPolicy when not set
+func (um *UserManager) passwordPolicy() *PasswordPolicy {
+	if um.PasswordPolicy == nil {
+		return DefaultPasswordPolicy()
+	}
+	return um.PasswordPolicy
+}
+
+// CheckNewPassword the password of user by the policy and the history
+func (um *UserManager) CheckNewPassword(user *GinExtUser, password string) error {
+	policy := um.passwordPolicy()
+	err := policy.Check(user, password)
+	if policy.HistorySize <= 0 || user == nil || user.ID <= 0 {
+		return err
+	}
+
+	reused := um.CheckPassword(user, password)
+	if !reused {
+		var hashes []string
+		um.db.Model(&GinPasswordHistory{}).Where("user_id", user.ID).Order("id DESC").Limit(policy.HistorySize).Pluck("password", &hashes)
+		hashVal := um.hashPassword(password)
+		for _, v := range hashes {
+			if v == hashVal {
+				reused = true
+				break
+			}
+		}
+	}
+	if !reused {
+		return err
+	}
+	if perr, ok := err.(*PasswordPolicyError); ok {
+		perr.Reasons = append(perr.Reasons, PasswordReused)
+		return perr
+	}
+	return &PasswordPolicyError{Policy: policy, Reasons: []string{PasswordReused}}
+}
+
+// IsPasswordExpired the password is older than the MaxAge of policy, checked only by the logins with password
+func (um *UserManager) IsPasswordExpired(user *GinExtUser) bool {
+	policy := um.passwordPolicy()
+	if policy.MaxAge <= 0 || len(user.Password) <= 0 {
+		return false
+	}
+	changedAt := user.CreatedAt
+	if user.PasswordChangedAt != nil {
+		changedAt = *user.PasswordChangedAt
+	}
+	return time.Since(changedAt) > policy.MaxAge
+}
+
+// savePasswordHistory keep the old password of user, the history beyond HistorySize is deleted
+func (um *UserManager) savePasswordHistory(user *GinExtUser) {
+	size := um.passwordPolicy().HistorySize
+	if size <= 0 || len(user.Password) <= 0 {
+		return
+	}
+	um.db.Create(&GinPasswordHistory{UserID: user.ID, Password: user.Password})
+
+	var ids []uint
+	um.db.Model(&GinPasswordHistory{}).Where("user_id", user.ID).Order("id DESC").Pluck("id", &ids)
+	if len(ids) > size {
+		um.db.Delete(&GinPasswordHistory{}, ids[size:])
+	}
+}
+
+// weakPasswordErr the reasons of policy error are sent in details
+func weakPasswordErr(err error) *RpcErr {
+	rpcErr := NewRpcErr(ErrCodeWeakPassword, err.Error())
+	if perr, ok := err.(*PasswordPolicyError); ok {
+		rpcErr = rpcErr.WithDetails(gin.H{"reasons": perr.Reasons})
+	}
+	return rpcErr
+}
+
+func abortWeakPassword(c *gin.Context, err error) {
+	RpcAbort(c, weakPasswordErr(err))
+}
@@ -0,0 +1,119 @@
+package ginext
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	policy := &PasswordPolicy{
+		MinLength:        10,
+		RequireUpper:     true,
+		RequireLower:     true,
+		RequireDigit:     true,
+		RequireSymbol:    true,
+		DisallowUserInfo: true,
+	}
+	assert.Nil(t, policy.Check(nil, "Hello-World-42"))
+
+	err := policy.Check(nil, "hello")
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{PasswordTooShort, PasswordNoUpper, PasswordNoDigit, PasswordNoSymbol}, err.(*PasswordPolicyError).Reasons)
+	assert.Equal(t, "password must be at least 10 characters, contain upper case letters, contain digits, contain symbols", err.Error())
+
+	user := &GinExtUser{UserName: "bob", Email: "alice.smith@example.org"}
+	err = policy.Check(user, "Bob-Secret-42")
+	assert.Equal(t, []string{PasswordUserInfo}, err.(*PasswordPolicyError).Reasons)
+	err = policy.Check(user, "Alice.Smith-42")
+	assert.Equal(t, []string{PasswordUserInfo}, err.(*PasswordPolicyError).Reasons)
+
+	dir := t.TempDir()
+	banned := filepath.Join(dir, "banned.txt")
+	os.WriteFile(banned, []byte("# common passwords\nPassword123!\nqwerty\n"), 0644)
+	assert.Nil(t, policy.LoadBanned(banned))
+	err = policy.Check(nil, "password123!")
+	assert.Equal(t, []string{PasswordNoUpper, PasswordBanned}, err.(*PasswordPolicyError).Reasons)
+
+	sum := sha1.Sum([]byte("Breached-Pass-1"))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	dataset := filepath.Join(dir, "pwned.txt")
+	os.WriteFile(dataset, []byte("0000000000000000000000000000000000000000:3\n"+hash+":42\nbad line\n"), 0644)
+	source, err := LoadPasswordRange(dataset)
+	assert.Nil(t, err)
+	count, err := BreachedCount(source, "Breached-Pass-1")
+	assert.Nil(t, err)
+	assert.Equal(t, 42, count)
+	policy.Breached = source
+	err = policy.Check(nil, "Breached-Pass-1")
+	assert.Equal(t, []string{PasswordBreached}, err.(*PasswordPolicyError).Reasons)
+	assert.Nil(t, policy.Check(nil, "Breached-Pass-2"))
+}
+
+func TestPasswordHistoryAndExpiry(t *testing.T) {
+	um, r := NewTestUserManager()
+	um.PasswordPolicy = DefaultPasswordPolicy()
+	um.PasswordPolicy.HistorySize = 2
+	um.RegisterHandler("/auth", r)
+	client := NewTestHTTPClient(r)
+
+	w := client.Post("/auth/register", map[string]interface{}{"email": "bob@example.org", "password": "bob12345"})
+	var body RpcErr
+	json.Unmarshal(w.Body.Bytes(), &body)
+	assert.Equal(t, ErrCodeWeakPassword, body.Code)
+	assert.Equal(t, map[string]interface{}{"reasons": []interface{}{PasswordUserInfo}}, body.Details)
+
+	addUser(t, client, r, "bob", "bob@example.org", "hello123")
+	var info UserInfoResult
+	err := client.Call("/auth/login", LoginForm{UserName: "bob", Password: "hello123"}, &info)
+	assert.Nil(t, err)
+
+	current := "hello123"
+	change := func(password string) *RpcErr {
+		w := client.Post("/auth/password/change", map[string]interface{}{"oldPassword": current, "password": password})
+		var body RpcErr
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if body.Code == 200 {
+			current = password
+		}
+		return &body
+	}
+	assert.Equal(t, ErrCodeWeakPassword, change("hello123").Code)
+	assert.Equal(t, 200, change("world789").Code)
+	assert.Equal(t, 200, change("hello456").Code)
+	assert.Equal(t, ErrCodeWeakPassword, change("hello123").Code)
+	assert.Equal(t, ErrCodeWeakPassword, change("world789").Code)
+	assert.Equal(t, 200, change("world456").Code)
+	// out of the history
+	assert.Equal(t, 200, change("hello123").Code)
+
+	var count int64
+	um.db.Model(&GinPasswordHistory{}).Count(&count)
+	assert.Equal(t, int64(2), count)
+
+	// expired
+	um.PasswordPolicy.MaxAge = time.Hour
+	bob, _ := um.Get("bob")
+	um.db.Model(bob).Update("PasswordChangedAt", time.Now().Add(-2*time.Hour))
+	w = client.Post("/auth/login", map[string]interface{}{"username": "bob", "password": "hello123"})
+	json.Unmarshal(w.Body.Bytes(), &body)
+	assert.Equal(t, ErrCodePasswordExpired, body.Code)
+
+	err = client.Call("/auth/password/renew", PasswordRenewForm{UserName: "bob", Password: "bad", NewPassword: "renew789"}, &info)
+	assert.NotNil(t, err)
+	w = client.Post("/auth/password/renew", map[string]interface{}{"username": "bob", "password": "hello123", "newPassword": "hello123"})
+	json.Unmarshal(w.Body.Bytes(), &body)
+	assert.Equal(t, ErrCodeWeakPassword, body.Code)
+	err = client.Call("/auth/password/renew", PasswordRenewForm{UserName: "bob", Password: "hello123", NewPassword: "renew789"}, &info)
+	assert.Nil(t, err)
+	assert.Equal(t, "bob", info.UserName)
+	err = client.Call("/auth/login", LoginForm{UserName: "bob", Password: "renew789"}, &info)
+	assert.Nil(t, err)
+}
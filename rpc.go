@@ -1,22 +1,33 @@
 package ginext
 
 import (
-	"fmt"
-	"log"
+	"errors"
 	"net/http"
+	"path"
 	"reflect"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
 )
 
 type RpcContext struct {
 	AuthRequired    bool
+	StaffRequired   bool
 	OnlyPost        bool
+	CsrfExempt      bool
 	ReduceDataField bool
 	Form            interface{}
 	Result          interface{}
 	RelativePath    string
-	Handler         gin.HandlerFunc
+	// The http methods, default is POST and GET (unless OnlyPost).
+	// The default GET is checked by csrf as POST, only the declared safe methods are exempt
+	Methods []string
+	// Run after the csrf and auth checks, before the form is bound, e.g. the rate limit of the rpc
+	Middlewares []gin.HandlerFunc
+	Handler     gin.HandlerFunc
 	//Markdown Document
 	Doc string
 }
@@ -25,7 +36,7 @@ func RpcOk(c *gin.Context, obj interface{}) {
 	resultType, ok := c.Get(RpcResultField)
 	if ok && resultType != nil {
 		if reflect.TypeOf(resultType) != reflect.TypeOf(obj) {
-			log.Printf("incorrect result type %s required:%s != result:%s", c.Request.URL.Path, reflect.TypeOf(resultType), reflect.TypeOf(obj))
+			CurrentLogger(c).Warn("incorrect result type", "path", c.Request.URL.Path, "required", reflect.TypeOf(resultType).String(), "result", reflect.TypeOf(obj).String())
 		}
 	}
 
@@ -39,86 +50,218 @@ func RpcOk(c *gin.Context, obj interface{}) {
 	})
 }
 
+// RpcFail send the fail code, the key and status are from the registered code
 func RpcFail(c *gin.Context, failCode int, msg string) {
-	if _, ok := c.Get(RpcReduceDataField); ok {
-		c.AbortWithStatusJSON(failCode, gin.H{
-			"msg": msg,
-		})
-		return
-	}
-
-	c.AbortWithStatusJSON(http.StatusOK, gin.H{
-		"code": failCode,
-		"msg":  msg,
-	})
+	RpcAbort(c, NewRpcErr(failCode, msg))
 }
 
+// RpcError send the error as RpcAbort, the error not RpcErr is logged and sent as 400
 func RpcError(c *gin.Context, err error) {
-	errMsg := fmt.Sprintf("[RpcError]\t%s\t%s\t%s\n", c.ClientIP(), c.Request.URL.Path, err.Error())
-	log.Default().Output(2, errMsg)
-	c.AbortWithStatusJSON(http.StatusOK, gin.H{
-		"code": http.StatusBadRequest,
-		"msg":  err.Error(),
-	})
+	RpcAbort(c, err)
 }
 
-func RpcDefine(r *gin.Engine, ctx *RpcContext) {
-	funcObj := func(c *gin.Context) {
+func rpcPath(c *gin.Context) string {
+	if path := c.GetString(RpcPathField); len(path) > 0 {
+		return path
+	}
+	return c.Request.URL.Path
+}
+
+// RpcDefine register the rpc on the engine or router group.
+// The form is bound from the json body (the query for GET and DELETE),
+// then the `uri` tag from the path params like /items/:id, and the `header` tag from the headers.
+// The middlewares run after the csrf and auth checks, and before the form is bound.
+func RpcDefine(r gin.IRoutes, ctx *RpcContext) {
+	fullPath := rpcFullPath(r, ctx.RelativePath)
+	guardObj := func(c *gin.Context) {
+		if m := metricsOf(c); m != nil {
+			start := time.Now()
+			defer func() {
+				m.observeRpc(fullPath, c.Request.Method, time.Since(start))
+			}()
+		}
+		span := startRpcSpan(c, fullPath)
+		defer span.End()
+
+		c.Set(RpcPathField, fullPath)
 		c.Set(RpcResultField, ctx.Result)
 		if ctx.ReduceDataField {
 			c.Set(RpcReduceDataField, ctx.ReduceDataField)
 		}
 
-		if ctx.AuthRequired {
-			if CurrentUser(c) == nil {
-				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-					"error": "auth required",
-				})
+		// the default GET of rpc is the same handler as POST, so only the declared methods are safe
+		safe := len(ctx.Methods) > 0 && isSafeMethod(c.Request.Method)
+		if !ctx.CsrfExempt && !verifyCsrfToken(c, safe) {
+			abortCsrfFail(c)
+			return
+		}
+
+		if ctx.AuthRequired || ctx.StaffRequired {
+			user := CurrentUser(c)
+			if user == nil {
+				RpcAbort(c, ErrAuthRequired)
+				return
+			}
+			if ctx.StaffRequired && !user.IsStaff {
+				RpcAbort(c, ErrStaffRequired)
 				return
 			}
 		}
+		// the metrics and span are ended after the middlewares and handler
+		c.Next()
+	}
 
+	funcObj := func(c *gin.Context) {
 		if ctx.Form != nil {
-			// Init Form object
-			form := reflect.New(reflect.TypeOf(ctx.Form)).Interface()
-			var err error
-
-			if c.Request.Method == "POST" && c.Request.ContentLength > 0 {
-				err = c.BindJSON(&form)
-			} else if c.Request.Method == "GET" {
-				err = c.ShouldBindQuery(form)
-			}
+			bindSpan := startChildSpan(c, "rpc.bind", false)
+			form, err := bindRpcForm(c, reflect.TypeOf(ctx.Form))
+			bindSpan.End()
 
 			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{
-					"type":  "json bind",
-					"error": err.Error(),
-				})
+				RpcAbort(c, bindFormError(c, err))
 				return
 			}
 
 			//If form decode fail, the `form` will be nil.
 			if form == nil {
-				c.JSON(http.StatusBadRequest, gin.H{
-					"error": "invalid requst body",
-				})
+				RpcAbort(c, ErrBindForm.WithMsg("invalid requst body"))
 				return
 			}
 
 			c.Set(RpcFormField, form)
 		}
+		handlerSpan := startChildSpan(c, "rpc.handler", true)
+		defer handlerSpan.End()
 		ctx.Handler(c)
 	}
 
-	r.POST(ctx.RelativePath, funcObj)
-	if !ctx.OnlyPost {
-		r.GET(ctx.RelativePath, funcObj)
+	handlers := append(append([]gin.HandlerFunc{guardObj}, ctx.Middlewares...), funcObj)
+	for _, method := range ctx.methods() {
+		r.Handle(method, ctx.RelativePath, handlers...)
+	}
+	doc := NewRpcDoc(ctx)
+	doc.RelativePath = fullPath
+	RegistryOf(r).Add(doc)
+}
+
+func (ctx *RpcContext) methods() []string {
+	if len(ctx.Methods) > 0 {
+		methods := make([]string, 0, len(ctx.Methods))
+		for _, m := range ctx.Methods {
+			methods = append(methods, strings.ToUpper(m))
+		}
+		return methods
+	}
+	if ctx.OnlyPost {
+		return []string{http.MethodPost}
+	}
+	return []string{http.MethodPost, http.MethodGet}
+}
+
+// rpcFullPath join the base path of group, e.g. /api + /items/:id => /api/items/:id
+func rpcFullPath(r gin.IRoutes, relativePath string) string {
+	g, ok := r.(interface{ BasePath() string })
+	if !ok {
+		return relativePath
+	}
+	fullPath := path.Join(g.BasePath(), relativePath)
+	if strings.HasSuffix(relativePath, "/") && !strings.HasSuffix(fullPath, "/") {
+		fullPath += "/"
+	}
+	return fullPath
+}
+
+// bindRpcForm return nil form when the json body is null, the form is validated after all bound
+func bindRpcForm(c *gin.Context, formType reflect.Type) (interface{}, error) {
+	form := reflect.New(formType).Interface()
+
+	method := c.Request.Method
+	if method == http.MethodGet || (method == http.MethodDelete && c.Request.ContentLength <= 0) {
+		if err := skipValidation(c.ShouldBindQuery(form)); err != nil {
+			return nil, err
+		}
+	} else if c.Request.ContentLength > 0 {
+		// bind with the pointer of form, the form is nil when body is `null`
+		if err := skipValidation(c.ShouldBindWith(&form, binding.JSON)); err != nil {
+			return nil, err
+		}
+		if form == nil {
+			return nil, nil
+		}
+	}
+
+	if len(c.Params) > 0 {
+		if err := bindTaggedFields(form, "uri", func(obj interface{}) error {
+			return c.ShouldBindUri(obj)
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if err := bindTaggedFields(form, "header", func(obj interface{}) error {
+		return c.ShouldBindHeader(obj)
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := validateForm(c, form); err != nil {
+		return nil, err
+	}
+	return form, nil
+}
+
+// skipValidation the gin binding validate the form, but only part of the form is bound
+func skipValidation(err error) error {
+	var errs validator.ValidationErrors
+	if errors.As(err, &errs) {
+		return nil
+	}
+	return err
+}
+
+// bindTaggedFields bind into a new form, and copy only the fields with tag,
+// the gin binding use the field name when the tag is empty.
+func bindTaggedFields(form interface{}, tag string, bind func(obj interface{}) error) error {
+	dst := reflect.ValueOf(form).Elem()
+	if dst.Kind() != reflect.Struct || !hasTaggedField(dst.Type(), tag) {
+		return nil
+	}
+	src := reflect.New(dst.Type())
+	if err := skipValidation(bind(src.Interface())); err != nil {
+		return err
+	}
+	copyTaggedFields(dst, src.Elem(), tag)
+	return nil
+}
+
+func hasTaggedField(rt reflect.Type, tag string) bool {
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct && hasTaggedField(f.Type, tag) {
+			return true
+		}
+		if v := f.Tag.Get(tag); len(v) > 0 && v != "-" {
+			return true
+		}
+	}
+	return false
+}
+
+func copyTaggedFields(dst, src reflect.Value, tag string) {
+	rt := dst.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			copyTaggedFields(dst.Field(i), src.Field(i), tag)
+			continue
+		}
+		if v := f.Tag.Get(tag); len(v) > 0 && v != "-" && f.PkgPath == "" {
+			dst.Field(i).Set(src.Field(i))
+		}
 	}
-	AddDoc(ctx)
 }
 
-func RpcDefines(r *gin.Engine, appLabel string, ctxs []RpcContext) {
-	AddDocAppLabel(appLabel)
+func RpcDefines(r gin.IRoutes, appLabel string, ctxs []RpcContext) {
+	RegistryOf(r).AddAppLabel(appLabel)
 	for i := 0; i < len(ctxs); i++ {
 		RpcDefine(r, &ctxs[i])
 	}
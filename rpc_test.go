@@ -0,0 +1,152 @@
+package ginext
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type testItemForm struct {
+	ID      uint   `json:"-" uri:"id" binding:"required"`
+	Name    string `json:"name" form:"name" binding:"required"`
+	TraceID string `json:"-" header:"X-Trace-Id"`
+}
+
+func TestRpcDefineGroup(t *testing.T) {
+	r := gin.New()
+	api := r.Group("/api")
+	api.Use(func(c *gin.Context) {
+		c.Header("X-Group", "api")
+	})
+
+	var middlewareCalled int
+	RpcDefine(api, &RpcContext{
+		Form:         testItemForm{},
+		RelativePath: "/items/:id",
+		Methods:      []string{"put", http.MethodDelete},
+		Middlewares: []gin.HandlerFunc{func(c *gin.Context) {
+			middlewareCalled++
+		}},
+		Handler: func(c *gin.Context) {
+			form := c.MustGet(RpcFormField).(*testItemForm)
+			RpcOk(c, gin.H{
+				"id":    form.ID,
+				"name":  form.Name,
+				"trace": form.TraceID,
+				"path":  c.GetString(RpcPathField),
+			})
+		},
+	})
+
+	client := NewTestHTTPClient(r)
+	req, _ := http.NewRequest(http.MethodPut, "/api/items/42", bytes.NewBufferString(`{"name":"bob"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Trace-Id", "t1")
+	w := client.SendReq("/api/items/42", req)
+	assert.Equal(t, "api", w.Header().Get("X-Group"), w.Body.String())
+	resp := client.CheckResponse(t, w)
+	data := resp["data"].(map[string]interface{})
+	assert.Equal(t, float64(42), data["id"])
+	assert.Equal(t, "bob", data["name"])
+	assert.Equal(t, "t1", data["trace"])
+	assert.Equal(t, "/api/items/:id", data["path"])
+	assert.Equal(t, 1, middlewareCalled)
+
+	// DELETE is bound from query
+	req, _ = http.NewRequest(http.MethodDelete, "/api/items/7?name=alice", nil)
+	w = client.SendReq("/api/items/7", req)
+	data = client.CheckResponse(t, w)["data"].(map[string]interface{})
+	assert.Equal(t, float64(7), data["id"])
+	assert.Equal(t, "alice", data["name"])
+	assert.Equal(t, "", data["trace"])
+
+	// validated after all bound
+	req, _ = http.NewRequest(http.MethodPut, "/api/items/42", bytes.NewBufferString(`{}`))
+	w = client.SendReq("/api/items/42", req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var bindErr RpcErr
+	json.Unmarshal(w.Body.Bytes(), &bindErr)
+	assert.Equal(t, http.StatusBadRequest, bindErr.Code)
+	assert.Equal(t, "bind_error", bindErr.Key)
+	assert.Equal(t, "name is required", bindErr.Msg)
+	assert.Equal(t, map[string][]string{"name": {"is required"}}, bindErr.Fields)
+
+	// only the methods
+	req, _ = http.NewRequest(http.MethodPost, "/api/items/42", bytes.NewBufferString(`{"name":"bob"}`))
+	w = client.SendReq("/api/items/42", req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, 3, middlewareCalled)
+
+	// the group use the registry of engine, with the full path
+	doc, ok := RegistryOf(r).Lookup("/api/items/:id")
+	assert.True(t, ok)
+	assert.Equal(t, []string{http.MethodPut, http.MethodDelete}, doc.Methods)
+
+	spec := RegistryOf(r).OpenAPISpec(nil)
+	item := spec["paths"].(gin.H)["/api/items/{id}"].(gin.H)
+	assert.NotContains(t, item, "post")
+	put := item["put"].(gin.H)
+	assert.Contains(t, put, "requestBody")
+	params := put["parameters"].([]gin.H)
+	assert.Equal(t, 2, len(params))
+	assert.Equal(t, "id", params[0]["name"])
+	assert.Equal(t, "path", params[0]["in"])
+	assert.Equal(t, true, params[0]["required"])
+	assert.Equal(t, "X-Trace-Id", params[1]["name"])
+	assert.Equal(t, "header", params[1]["in"])
+
+	del := item["delete"].(gin.H)
+	assert.NotContains(t, del, "requestBody")
+	params = del["parameters"].([]gin.H)
+	assert.Equal(t, 3, len(params))
+	assert.Equal(t, "name", params[2]["name"])
+	assert.Equal(t, "query", params[2]["in"])
+}
+
+func TestRpcDefineBindJSON(t *testing.T) {
+	r := gin.New()
+	RpcDefine(r, &RpcContext{
+		Form:         testItemForm{},
+		RelativePath: "/items",
+		Handler: func(c *gin.Context) {
+			RpcOk(c, true)
+		},
+	})
+
+	client := NewTestHTTPClient(r)
+	w := client.PostRaw("/items", []byte(`null`))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid requst body")
+
+	// the uri field is required, but not in the path
+	w = client.Post("/items", map[string]interface{}{"name": "bob"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "ID")
+
+	assert.Equal(t, []string{http.MethodPost, http.MethodGet}, NewRpcDoc(&RpcContext{}).Methods)
+	assert.Equal(t, []string{http.MethodPost}, NewRpcDoc(&RpcContext{OnlyPost: true}).Methods)
+	assert.Equal(t, "/api/items/", rpcFullPath(r.Group("/api"), "/items/"))
+}
+
+func TestRpcMiddlewaresAfterAuth(t *testing.T) {
+	_, r := NewTestUserManager()
+	var middlewareCalled int
+	RpcDefine(r, &RpcContext{
+		AuthRequired: true,
+		RelativePath: "/private",
+		Middlewares: []gin.HandlerFunc{func(c *gin.Context) {
+			middlewareCalled++
+		}},
+		Handler: func(c *gin.Context) {
+			RpcOk(c, true)
+		},
+	})
+	client := NewTestHTTPClient(r)
+	w := client.Post("/private", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, 0, middlewareCalled)
+}
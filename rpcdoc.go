@@ -2,36 +2,75 @@ package ginext
 
 import (
 	_ "embed"
+	"encoding"
+	"encoding/json"
 	"net/http"
 	"reflect"
+	"strconv"
 	"strings"
-
-	"github.com/gin-gonic/gin"
 )
 
 const ApiDocsJSONUri = "/docs/api.json"
 const ApiDocsUri = "/docs/api"
 
+// RpcFieldType the type of field, Type is one of:
+//
+//	string, boolean, Integer, Number, Date, any, object, []T, map[string]T
 type RpcFieldType struct {
-	Name     string         `json:"name"`
-	Type     string         `json:"type"`
-	Required bool           `json:"required"`
-	CanNull  bool           `json:"canNull"`
-	Fields   []RpcFieldType `json:"fields,omitempty"`
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Required bool   `json:"required"`
+	CanNull  bool   `json:"canNull"`
+	// omitempty, the field may be absent
+	Optional bool     `json:"optional,omitempty"`
+	Desc     string   `json:"desc,omitempty"`
+	Enum     []string `json:"enum,omitempty"`
+	Example  string   `json:"example,omitempty"`
+	// min/max of number, or the length of string and array
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+	// The element of []T and map[string]T, the fields of object element are in Fields
+	Elem   *RpcFieldType  `json:"elem,omitempty"`
+	Fields []RpcFieldType `json:"fields,omitempty"`
 }
 
 type RpcDoc struct {
-	AuthRequired bool `json:"authRequired"`
-	OnlyPost     bool `json:"onlyPost"`
+	AuthRequired  bool `json:"authRequired"`
+	StaffRequired bool `json:"staffRequired"`
+	OnlyPost      bool `json:"onlyPost"`
+	// The http methods, e.g. ["POST", "GET"]
+	Methods []string `json:"methods,omitempty"`
 	//Form
 	Fields       []RpcFieldType `json:"fields,omitempty"`
 	ResultType   RpcFieldType   `json:"resultType,omitempty"`
 	RelativePath string         `json:"uri"`
 	DocString    string         `json:"doc"`
 	IsGroup      bool           `json:"isGroup"`
+
+	reduceDataField bool
+	formType        reflect.Type
+	resultType      reflect.Type
+	appLabel        string
 }
 
-var rpcDocs []RpcDoc
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// The sql.Null* are described as the nullable value
+var sqlNullTypes = map[string]string{
+	"NullString":  "string",
+	"NullBool":    "boolean",
+	"NullByte":    "Integer",
+	"NullInt16":   "Integer",
+	"NullInt32":   "Integer",
+	"NullInt64":   "Integer",
+	"NullFloat64": "Number",
+	"NullTime":    "Date",
+}
+
+func implements(rt, it reflect.Type) bool {
+	return rt.Implements(it) || reflect.PtrTo(rt).Implements(it)
+}
 
 func parseResultType(rt reflect.Type, name string, stacks []string) (val RpcFieldType) {
 	val.Name = name
@@ -40,177 +79,199 @@ func parseResultType(rt reflect.Type, name string, stacks []string) (val RpcFiel
 		val.CanNull = true
 		rt = rt.Elem()
 	}
-	if rt.Name() == "NullTime" {
+
+	if typ, ok := sqlNullTypes[rt.Name()]; ok && rt.Kind() == reflect.Struct {
 		val.CanNull = true
-		val.Type = "Date"
+		val.Type = typ
 		return val
-	} else if rt.Name() == "Time" {
+	}
+	if rt.Name() == "Time" && rt.PkgPath() == "time" {
 		val.Type = "Date"
 		return val
-	} else if rt.Kind() == reflect.Struct {
-		val.Type = "object"
-	} else if rt.Kind() == reflect.Array || rt.Kind() == reflect.Slice {
-		val.Type = "[]object"
-		rt = rt.Elem()
-	} else if rt.Kind() == reflect.Ptr {
-		val.Type = "object"
 	}
-
-	if rt.Kind() == reflect.Struct {
-		for _, v := range stacks {
-			if rt.Name() == v {
-				val.Type = "object"
-				return val
-			}
-		}
-
-		stacks = append(stacks, rt.Name())
-		for i := 0; i < rt.NumField(); i++ {
-			f := rt.Field(i)
-			jsonTag := f.Tag.Get("json")
-			if f.Anonymous && f.Type.Kind() == reflect.Struct {
-				//
-				embedRT := parseResultType(f.Type, "", stacks)
-				val.Fields = append(val.Fields, embedRT.Fields...)
-				continue
-			}
-			if len(jsonTag) <= 0 || jsonTag == "-" {
-				continue
-			}
-
-			fieldName := strings.Split(jsonTag, ",")[0]
-			if f.Type.Name() == "Time" {
-				fieldRT := RpcFieldType{
-					Name: fieldName,
-					Type: "Date",
-				}
-				val.Fields = append(val.Fields, fieldRT)
-			} else if f.Type.Name() == "NullTime" {
-				fieldRT := RpcFieldType{
-					Name: fieldName,
-					Type: "Date",
-				}
-				fieldRT.CanNull = true
-				val.Fields = append(val.Fields, fieldRT)
-			} else {
-				fieldRT := parseResultType(f.Type, fieldName, stacks)
-				val.Fields = append(val.Fields, fieldRT)
-			}
-		}
+	if implements(rt, jsonMarshalerType) {
+		// json.RawMessage and the custom types, can't guess the output
+		val.Type = "any"
+		return val
+	}
+	if implements(rt, textMarshalerType) {
+		val.Type = "string"
 		return val
 	}
 
 	switch rt.Kind() {
+	case reflect.Struct:
+		val.Type = "object"
+		if len(rt.Name()) > 0 {
+			for _, v := range stacks {
+				if rt.Name() == v {
+					return val
+				}
+			}
+			stacks = append(stacks, rt.Name())
+		}
+		val.Fields = parseStructFields(rt, stacks)
+	case reflect.Array, reflect.Slice:
+		if rt.Kind() == reflect.Slice && rt.Elem().Kind() == reflect.Uint8 {
+			// base64 string
+			val.Type = "string"
+			return val
+		}
+		elem := parseResultType(rt.Elem(), "", stacks)
+		val.Type = "[]" + elem.Type
+		val.Fields = elem.Fields
+		elem.Fields = nil
+		val.Elem = &elem
+	case reflect.Map:
+		elem := parseResultType(rt.Elem(), "", stacks)
+		val.Type = "map[string]" + elem.Type
+		val.Elem = &elem
 	case reflect.Bool:
 		val.Type = "boolean"
 	case reflect.String:
 		val.Type = "string"
-	case reflect.Map:
-		val.Type = "{}"
-	case reflect.Int:
-		fallthrough
-	case reflect.Int8:
-		fallthrough
-	case reflect.Int16:
-		fallthrough
-	case reflect.Int32:
-		fallthrough
-	case reflect.Int64:
-		fallthrough
-	case reflect.Uint:
-		fallthrough
-	case reflect.Uint8:
-		fallthrough
-	case reflect.Uint16:
-		fallthrough
-	case reflect.Uint32:
-		fallthrough
-	case reflect.Uint64:
-		fallthrough
-	case reflect.Uintptr:
-		fallthrough
-	case reflect.Float32:
-		fallthrough
-	case reflect.Float64:
-		fallthrough
-	case reflect.Complex64:
-		fallthrough
-	case reflect.Complex128:
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
 		val.Type = "Integer"
+	case reflect.Float32, reflect.Float64:
+		val.Type = "Number"
+	default:
+		val.Type = "any"
 	}
 	return val
 }
 
-func parseFileds(rt reflect.Type) []RpcFieldType {
-	if rt.Kind() != reflect.Struct {
-		return nil
+// ElemType return the element of []T and map[string]T, with the fields of object
+func (f RpcFieldType) ElemType() RpcFieldType {
+	if f.Elem == nil {
+		return RpcFieldType{Type: "any"}
 	}
+	elem := *f.Elem
+	if elem.Type == "object" && len(elem.Fields) <= 0 {
+		elem.Fields = f.Fields
+	}
+	return elem
+}
+
+func parseStructFields(rt reflect.Type, stacks []string) []RpcFieldType {
 	var docFields []RpcFieldType
 	for i := 0; i < rt.NumField(); i++ {
 		f := rt.Field(i)
 
 		if f.Anonymous && f.Type.Kind() == reflect.Struct {
 			//
-			embedRT := parseFileds(f.Type)
+			embedRT := parseStructFields(f.Type, stacks)
 			docFields = append(docFields, embedRT...)
 			continue
 		}
 
 		jsonTag := f.Tag.Get("json")
-
 		if len(jsonTag) <= 0 || jsonTag == "-" {
 			continue
 		}
 
-		fieldName := strings.Split(jsonTag, ",")[0]
-		docField := parseResultType(f.Type, fieldName, nil)
-		if f.Tag.Get("binding") == "required" {
-			docField.Required = true
+		opts := strings.Split(jsonTag, ",")
+		docField := parseResultType(f.Type, opts[0], stacks)
+		for _, opt := range opts[1:] {
+			switch opt {
+			case "omitempty":
+				docField.Optional = true
+			case "string":
+				docField.Type = "string"
+			}
 		}
+		parseFieldTags(&docField, f)
 		docFields = append(docFields, docField)
 	}
-
 	return docFields
 }
 
-func AddDocAppLabel(label string) {
-	doc := RpcDoc{
-		IsGroup:      true,
-		RelativePath: label,
+func parseFileds(rt reflect.Type) []RpcFieldType {
+	if rt.Kind() != reflect.Struct {
+		return nil
 	}
-	rpcDocs = append(rpcDocs, doc)
+	return parseResultType(rt, "", nil).Fields
 }
 
+// parseFieldTags read the rules of binding and validate, the example and doc.
+// e.g. `binding:"required,oneof=a b" example:"a" doc:"The kind of pet"`
+func parseFieldTags(docField *RpcFieldType, f reflect.StructField) {
+	rules := strings.Split(f.Tag.Get("binding"), ",")
+	rules = append(rules, strings.Split(f.Tag.Get("validate"), ",")...)
+	for _, rule := range rules {
+		key, arg, _ := strings.Cut(rule, "=")
+		switch key {
+		case "required":
+			docField.Required = true
+		case "oneof":
+			docField.Enum = strings.Fields(arg)
+		case "min", "gte":
+			docField.Min = parseRuleNumber(arg)
+		case "max", "lte":
+			docField.Max = parseRuleNumber(arg)
+		case "len":
+			docField.Min = parseRuleNumber(arg)
+			docField.Max = parseRuleNumber(arg)
+		}
+	}
+	docField.Example = f.Tag.Get("example")
+	docField.Desc = f.Tag.Get("doc")
+}
+
+func parseRuleNumber(arg string) *float64 {
+	v, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		return nil
+	}
+	return &v
+}
+
+// Deprecated: use RegistryOf(r).AddAppLabel, the label is added into DefaultRpcRegistry
+func AddDocAppLabel(label string) {
+	defaultRpcRegistry.AddAppLabel(label)
+}
+
+// Deprecated: RpcDefine add the doc into the registry of router, the doc is added into DefaultRpcRegistry
 func AddDoc(ctx *RpcContext) {
+	defaultRpcRegistry.Add(NewRpcDoc(ctx))
+}
+
+func NewRpcDoc(ctx *RpcContext) RpcDoc {
 	doc := RpcDoc{
-		AuthRequired: ctx.AuthRequired,
-		OnlyPost:     ctx.OnlyPost,
-		RelativePath: ctx.RelativePath,
+		AuthRequired:  ctx.AuthRequired || ctx.StaffRequired,
+		StaffRequired: ctx.StaffRequired,
+		OnlyPost:      ctx.OnlyPost,
+		Methods:       ctx.methods(),
+		RelativePath:  ctx.RelativePath,
+
+		reduceDataField: ctx.ReduceDataField,
 	}
 	if ctx.Form != nil {
+		doc.formType = reflect.TypeOf(ctx.Form)
 		doc.Fields = parseFileds(reflect.TypeOf(ctx.Form))
 	}
 
 	if ctx.Result != nil {
+		doc.resultType = reflect.TypeOf(ctx.Result)
 		doc.ResultType = parseResultType(reflect.TypeOf(ctx.Result), "", nil)
 	}
 
 	if len(ctx.Doc) > 0 {
 		doc.DocString = ctx.Doc
 	}
-	rpcDocs = append(rpcDocs, doc)
+	return doc
 }
 
 //go:embed assets/rpcdoc.html
 var rpcDocHtml string
 
-func registerDocHandler(r *gin.Engine) {
-	rpcDocs = make([]RpcDoc, 0)
-	r.GET(ApiDocsJSONUri, func(c *gin.Context) {
-		c.JSON(http.StatusOK, rpcDocs)
-	})
-
-	r.GET(ApiDocsUri, func(c *gin.Context) {
-		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(rpcDocHtml))
-	})
+// methods of the doc, the doc not from RpcContext is POST and GET unless OnlyPost
+func (doc RpcDoc) methods() []string {
+	if len(doc.Methods) > 0 {
+		return doc.Methods
+	}
+	if doc.OnlyPost {
+		return []string{http.MethodPost}
+	}
+	return []string{http.MethodPost, http.MethodGet}
 }
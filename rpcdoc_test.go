@@ -29,8 +29,9 @@ func TestDocString(t *testing.T) {
 
 	r := gin.Default()
 	cfg.WithGinExt(r)
+	api := cfg.Registry.Router(r)
 
-	RpcDefine(r, &RpcContext{
+	RpcDefine(api, &RpcContext{
 		Form:         RegisterUserForm{},
 		Result:       UserInfoResult{},
 		OnlyPost:     true,
@@ -39,7 +40,7 @@ func TestDocString(t *testing.T) {
 		},
 	})
 
-	RpcDefine(r, &RpcContext{
+	RpcDefine(api, &RpcContext{
 		Form:         RegisterUserForm{},
 		Result:       testEmbedResult{},
 		OnlyPost:     true,
@@ -48,7 +49,7 @@ func TestDocString(t *testing.T) {
 		},
 	})
 
-	RpcDefine(r, &RpcContext{
+	RpcDefine(api, &RpcContext{
 		Form:         testStructFieldForm{},
 		OnlyPost:     true,
 		RelativePath: "/mockapi/struct_field",
@@ -61,8 +62,14 @@ func TestDocString(t *testing.T) {
 	w := client.Get(ApiDocsJSONUri)
 	assert.Equal(t, http.StatusOK, w.Code)
 
-	var rpcDocs []RpcDoc
-	json.Unmarshal(w.Body.Bytes(), &rpcDocs)
+	var servedDocs, rpcDocs []RpcDoc
+	json.Unmarshal(w.Body.Bytes(), &servedDocs)
+	// the docs of DefaultRpcRegistry are served too
+	for _, v := range servedDocs {
+		if v.RelativePath == "/mockapi/register" || v.RelativePath == "/mockapi/embed" || v.RelativePath == "/mockapi/struct_field" {
+			rpcDocs = append(rpcDocs, v)
+		}
+	}
 
 	assert.NotNil(t, rpcDocs)
 	assert.Equal(t, len(rpcDocs), 3)
@@ -113,3 +120,82 @@ func TestParseField(t *testing.T) {
 	assert.Equal(t, "keyword", fields[0].Name)
 	assert.Equal(t, "val", fields[4].Name)
 }
+
+type testRichPrice float64
+
+type testRichJSON struct{}
+
+func (testRichJSON) MarshalJSON() ([]byte, error) {
+	return []byte(`"custom"`), nil
+}
+
+type testRichForm struct {
+	Count   int                 `json:"count" binding:"min=1,max=10"`
+	Price   float64             `json:"price"`
+	Rate    testRichPrice       `json:"rate,omitempty"`
+	Tags    []string            `json:"tags" binding:"max=5" doc:"The tags of item"`
+	Scores  map[string]int      `json:"scores"`
+	Items   []*UserInfoResult   `json:"items"`
+	Extra   json.RawMessage     `json:"extra"`
+	Custom  testRichJSON        `json:"custom"`
+	Name    sql.NullString      `json:"name"`
+	Age     sql.NullInt64       `json:"age"`
+	Code    string              `json:"code" validate:"len=6"`
+	ID      int64               `json:"id,string"`
+	Blob    []byte              `json:"blob"`
+	Any     interface{}         `json:"any"`
+	Nested  map[string][]string `json:"nested"`
+	private string
+}
+
+func TestParseRichField(t *testing.T) {
+	fields := parseFileds(reflect.TypeOf(testRichForm{}))
+	assert.Equal(t, 15, len(fields))
+	byName := map[string]RpcFieldType{}
+	for _, f := range fields {
+		byName[f.Name] = f
+	}
+
+	assert.Equal(t, "Integer", byName["count"].Type)
+	assert.Equal(t, float64(1), *byName["count"].Min)
+	assert.Equal(t, float64(10), *byName["count"].Max)
+	assert.Equal(t, "Number", byName["price"].Type)
+	assert.Equal(t, "Number", byName["rate"].Type)
+	assert.True(t, byName["rate"].Optional)
+
+	assert.Equal(t, "[]string", byName["tags"].Type)
+	assert.Equal(t, "string", byName["tags"].Elem.Type)
+	assert.Equal(t, "The tags of item", byName["tags"].Desc)
+	assert.Equal(t, float64(5), *byName["tags"].Max)
+
+	assert.Equal(t, "map[string]Integer", byName["scores"].Type)
+	assert.Equal(t, "Integer", byName["scores"].Elem.Type)
+
+	assert.Equal(t, "[]object", byName["items"].Type)
+	assert.Equal(t, 3, len(byName["items"].Fields))
+	assert.True(t, byName["items"].Elem.CanNull)
+	assert.Equal(t, 3, len(byName["items"].ElemType().Fields))
+
+	assert.Equal(t, "any", byName["extra"].Type)
+	assert.Equal(t, "any", byName["custom"].Type)
+	assert.Equal(t, "string", byName["name"].Type)
+	assert.True(t, byName["name"].CanNull)
+	assert.Equal(t, "Integer", byName["age"].Type)
+	assert.True(t, byName["age"].CanNull)
+	assert.Equal(t, float64(6), *byName["code"].Min)
+	assert.Equal(t, float64(6), *byName["code"].Max)
+	assert.Equal(t, "string", byName["id"].Type)
+	assert.Equal(t, "string", byName["blob"].Type)
+	assert.Equal(t, "any", byName["any"].Type)
+	assert.Equal(t, "map[string][]string", byName["nested"].Type)
+	assert.Equal(t, "string", byName["nested"].ElemType().ElemType().Type)
+
+	schema := openAPISchema(byName["tags"])
+	assert.Equal(t, "array", schema["type"])
+	assert.Equal(t, float64(5), schema["maxItems"])
+	assert.Equal(t, "The tags of item", schema["description"])
+	schema = openAPISchema(byName["scores"])
+	assert.Equal(t, gin.H{"type": "integer"}, schema["additionalProperties"])
+	assert.Equal(t, "Record<string, number>", tsType(byName["scores"], false, ""))
+	assert.Equal(t, "Array<string>", tsType(byName["tags"], false, ""))
+}
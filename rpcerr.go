@@ -0,0 +1,229 @@
+package ginext
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The fail codes of auth handlers
+const (
+	ErrCodeUsernameExists = 10000 + iota
+	ErrCodeEmailExists
+	ErrCodeBadPassword
+	ErrCodeInvalidParams
+	ErrCodeNotAllowed
+	ErrCodeActiveRequired
+	ErrCodeBadVerifyCode
+	ErrCodeServerError
+	ErrCodePhoneExists
+	ErrCodeTooManyRequests
+	ErrCodeWebAuthnFail
+	ErrCodeWeakPassword
+	ErrCodePasswordExpired
+)
+
+// The errors of RpcDefine, sent with the http status
+var (
+	ErrBindForm      = &RpcErr{Code: http.StatusBadRequest, Key: "bind_error", Msg: "bind form fail", Status: http.StatusBadRequest}
+	ErrAuthRequired  = &RpcErr{Code: http.StatusUnauthorized, Key: "auth_required", Msg: "auth required", Status: http.StatusUnauthorized}
+	ErrStaffRequired = &RpcErr{Code: http.StatusForbidden, Key: "staff_required", Msg: "staff required", Status: http.StatusForbidden}
+	ErrCsrfFail      = &RpcErr{Code: http.StatusForbidden, Key: "csrf_fail", Msg: "invalid csrf token", Status: http.StatusForbidden}
+	// The error of WebAuthnRequired
+	ErrWebAuthnRequired = &RpcErr{Code: http.StatusForbidden, Key: "webauthn_required", Msg: "webauthn required", Status: http.StatusForbidden}
+	// The error of OrgRequired and the OrgScoped models without the current organization
+	ErrOrgRequired = &RpcErr{Code: http.StatusForbidden, Key: "org_required", Msg: "organization required", Status: http.StatusForbidden}
+)
+
+// The errors of auth handlers, the msg is translated by the key
+var (
+	ErrUsernameExists  = &RpcErr{Code: ErrCodeUsernameExists, Key: "username_exists", Msg: "username is exists"}
+	ErrEmailExists     = &RpcErr{Code: ErrCodeEmailExists, Key: "email_exists", Msg: "email is exists"}
+	ErrPhoneExists     = &RpcErr{Code: ErrCodePhoneExists, Key: "phone_exists", Msg: "phone is exists"}
+	ErrBadPassword     = &RpcErr{Code: ErrCodeBadPassword, Key: "bad_password", Msg: "bad username or password"}
+	ErrBadVerifyCode   = &RpcErr{Code: ErrCodeBadVerifyCode, Key: "bad_verify_code", Msg: "bad verifycode"}
+	ErrUserNotAllowed  = &RpcErr{Code: ErrCodeNotAllowed, Key: "not_allowed", Msg: "user is not allow login"}
+	ErrActiveRequired  = &RpcErr{Code: ErrCodeActiveRequired, Key: "active_required", Msg: "user need actived first"}
+	ErrPasswordExpired = &RpcErr{Code: ErrCodePasswordExpired, Key: "password_expired", Msg: "password expired"}
+)
+
+func init() {
+	RegisterRpcErrCode(http.StatusBadRequest, "bad_request", "Bad request or bind form fail", 0)
+	RegisterRpcErrCode(http.StatusUnauthorized, "auth_required", "Auth required", http.StatusUnauthorized)
+	RegisterRpcErrCode(http.StatusForbidden, "forbidden", "Staff required, webauthn required, organization required or invalid csrf token", http.StatusForbidden)
+
+	RegisterRpcErrCode(ErrCodeUsernameExists, "username_exists", "Username exists", 0)
+	RegisterRpcErrCode(ErrCodeEmailExists, "email_exists", "Email exists", 0)
+	RegisterRpcErrCode(ErrCodeBadPassword, "bad_password", "Bad username or password", 0)
+	RegisterRpcErrCode(ErrCodeInvalidParams, "invalid_params", "Invalid params", 0)
+	RegisterRpcErrCode(ErrCodeNotAllowed, "not_allowed", "User not allowed to login", 0)
+	RegisterRpcErrCode(ErrCodeActiveRequired, "active_required", "User need to be actived", 0)
+	RegisterRpcErrCode(ErrCodeBadVerifyCode, "bad_verify_code", "Bad verify code", 0)
+	RegisterRpcErrCode(ErrCodeServerError, "server_error", "Server error", 0)
+	RegisterRpcErrCode(ErrCodePhoneExists, "phone_exists", "Phone exists", 0)
+	RegisterRpcErrCode(ErrCodeWebAuthnFail, "webauthn_fail", "WebAuthn verify fail", 0)
+	RegisterRpcErrCode(ErrCodeWeakPassword, "weak_password", "Password violates the policy, the reasons in details", 0)
+	RegisterRpcErrCode(ErrCodePasswordExpired, "password_expired", "Password expired, renew the password", 0)
+	RegisterRpcErrCode(ErrCodeTooManyRequests, "too_many_requests", "Too many requests, retry after the seconds of details", http.StatusTooManyRequests)
+}
+
+// RpcErr the error of rpc, sent as the envelope:
+//
+//	{"code": 10000, "key": "username_exists", "msg": "username is exists", "details": ..., "fields": {"email": ["..."]}}
+//
+// The envelope is sent with http 200 unless the Status is set
+type RpcErr struct {
+	Code int    `json:"code"`
+	Key  string `json:"key,omitempty"`
+	Msg  string `json:"msg"`
+	// The extra info of error, e.g. the retry after seconds
+	Details interface{} `json:"details,omitempty"`
+	// The messages of form fields, by the json name
+	Fields map[string][]string `json:"fields,omitempty"`
+	Status int                 `json:"-"`
+	// The msg is from the caller, not translated by the key
+	explicitMsg bool
+}
+
+// RpcErrCode the registered fail code, listed in the api docs
+type RpcErrCode struct {
+	Code   int    `json:"code"`
+	Key    string `json:"key"`
+	Desc   string `json:"desc"`
+	Status int    `json:"status,omitempty"`
+}
+
+var rpcErrCodes = struct {
+	sync.RWMutex
+	m map[int]RpcErrCode
+}{m: map[int]RpcErrCode{}}
+
+// RegisterRpcErrCode register the fail code, the key is the default of RpcErr with the code,
+// the status is sent only with the RpcErrStatus of GinExt
+func RegisterRpcErrCode(code int, key, desc string, status int) {
+	rpcErrCodes.Lock()
+	defer rpcErrCodes.Unlock()
+	rpcErrCodes.m[code] = RpcErrCode{Code: code, Key: key, Desc: desc, Status: status}
+}
+
+// AddErrorCodeDoc describe the fail code of RpcFail, listed in the api docs
+func AddErrorCodeDoc(code int, desc string) {
+	rpcErrCodes.Lock()
+	defer rpcErrCodes.Unlock()
+	v := rpcErrCodes.m[code]
+	v.Code = code
+	v.Desc = desc
+	rpcErrCodes.m[code] = v
+}
+
+func LookupRpcErrCode(code int) (RpcErrCode, bool) {
+	rpcErrCodes.RLock()
+	defer rpcErrCodes.RUnlock()
+	v, ok := rpcErrCodes.m[code]
+	return v, ok
+}
+
+// RpcErrCodes the registered codes, sorted by code
+func RpcErrCodes() []RpcErrCode {
+	rpcErrCodes.RLock()
+	defer rpcErrCodes.RUnlock()
+	codes := make([]RpcErrCode, 0, len(rpcErrCodes.m))
+	for _, v := range rpcErrCodes.m {
+		codes = append(codes, v)
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		return codes[i].Code < codes[j].Code
+	})
+	return codes
+}
+
+// NewRpcErr the key is from the registered code, the msg is the desc of code when empty,
+// and translated by the key. The error is sent with http 200 unless WithStatus or RpcErrStatus
+func NewRpcErr(code int, msg string) *RpcErr {
+	e := &RpcErr{Code: code, Msg: msg, explicitMsg: len(msg) > 0}
+	if v, ok := LookupRpcErrCode(code); ok {
+		e.Key = v.Key
+		if len(msg) <= 0 {
+			e.Msg = v.Desc
+		}
+	}
+	return e
+}
+
+func (e *RpcErr) Error() string {
+	return e.Msg
+}
+
+// Is the errors with same code and key
+func (e *RpcErr) Is(target error) bool {
+	t, ok := target.(*RpcErr)
+	return ok && t.Code == e.Code && t.Key == e.Key
+}
+
+// WithMsg copy the error with msg, the msg is not translated
+func (e *RpcErr) WithMsg(msg string) *RpcErr {
+	v := *e
+	v.Msg = msg
+	v.explicitMsg = true
+	return &v
+}
+
+// WithDetails copy the error with details
+func (e *RpcErr) WithDetails(details interface{}) *RpcErr {
+	v := *e
+	v.Details = details
+	return &v
+}
+
+// WithFields copy the error with the messages of fields
+func (e *RpcErr) WithFields(fields map[string][]string) *RpcErr {
+	v := *e
+	v.Fields = fields
+	return &v
+}
+
+// WithStatus copy the error, sent with the http status
+func (e *RpcErr) WithStatus(status int) *RpcErr {
+	v := *e
+	v.Status = status
+	return &v
+}
+
+// httpStatus the Status, the registered status with RpcErrStatus, or the code in ReduceDataField mode
+func (e *RpcErr) httpStatus(c *gin.Context) int {
+	if e.Status > 0 {
+		return e.Status
+	}
+	if obj, ok := c.Get(ConfigField); ok && obj != nil && obj.(*GinExt).RpcErrStatus {
+		if v, ok := LookupRpcErrCode(e.Code); ok && v.Status > 0 {
+			return v.Status
+		}
+	}
+	if _, ok := c.Get(RpcReduceDataField); ok {
+		if e.Code >= 100 && e.Code < 600 {
+			return e.Code
+		}
+		return http.StatusBadRequest
+	}
+	return http.StatusOK
+}
+
+// RpcAbort send the error in the envelope, the error not RpcErr is sent as 400.
+// The default msg is translated by the key in the locale of request, the explicit msg
+// of NewRpcErr and WithMsg is sent as is, e.g. "only owner can invite admin"
+func RpcAbort(c *gin.Context, err error) {
+	var rpcErr *RpcErr
+	if !errors.As(err, &rpcErr) {
+		CurrentLogger(c).Error("rpc error", "ip", c.ClientIP(), "path", c.Request.URL.Path, "error", err.Error())
+		rpcErr = NewRpcErr(http.StatusBadRequest, err.Error())
+	}
+	if msg := Translate(c, rpcErr.Key, ""); len(msg) > 0 && !rpcErr.explicitMsg {
+		rpcErr = rpcErr.WithMsg(msg)
+	}
+	metricsOf(c).observeRpcFail(rpcPath(c), rpcErr.Code)
+	setRpcSpanFail(c, rpcErr.Code, rpcErr.Msg)
+	c.AbortWithStatusJSON(rpcErr.httpStatus(c), rpcErr)
+}
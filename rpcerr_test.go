@@ -0,0 +1,113 @@
+package ginext
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRpcErr(t *testing.T) {
+	RegisterRpcErrCode(20001, "pet_not_found", "Pet not found", http.StatusNotFound)
+	AddErrorCodeDoc(20002, "Pet is sold")
+
+	v, ok := LookupRpcErrCode(20001)
+	assert.True(t, ok)
+	assert.Equal(t, "pet_not_found", v.Key)
+
+	err := NewRpcErr(20001, "")
+	assert.Equal(t, "Pet not found", err.Msg)
+	assert.Equal(t, 0, err.Status)
+	assert.True(t, errors.Is(err.WithMsg("no kitty"), NewRpcErr(20001, "")))
+	assert.False(t, errors.Is(err, ErrBindForm))
+
+	r := gin.New()
+	r.POST("/notfound", func(c *gin.Context) {
+		RpcFail(c, 20001, "no kitty")
+	})
+	r.POST("/notfound/status", func(c *gin.Context) {
+		RpcAbort(c, NewRpcErr(20001, "no kitty").WithStatus(http.StatusNotFound))
+	})
+	r.POST("/sold", func(c *gin.Context) {
+		RpcAbort(c, NewRpcErr(20002, "sold").WithDetails(gin.H{"soldAt": 1}).WithFields(map[string][]string{"name": {"is sold"}}))
+	})
+	r.POST("/reduce", func(c *gin.Context) {
+		c.Set(RpcReduceDataField, true)
+		RpcFail(c, ErrCodeBadPassword, "bad password")
+	})
+	r.POST("/error", func(c *gin.Context) {
+		RpcError(c, errors.New("broken"))
+	})
+	RegistryOf(r).RegisterDocHandler(r, "/docs")
+
+	client := NewTestHTTPClient(r)
+	// the registered status is sent only when opted in
+	assert.Equal(t, http.StatusOK, client.Post("/notfound", nil).Code)
+	w := client.Post("/notfound/status", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	var body RpcErr
+	json.Unmarshal(w.Body.Bytes(), &body)
+	assert.Equal(t, 20001, body.Code)
+	assert.Equal(t, "pet_not_found", body.Key)
+	assert.Equal(t, "no kitty", body.Msg)
+
+	w = client.Post("/sold", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	resp := client.CheckResponse(t, w)
+	assert.Equal(t, "sold", resp["msg"])
+	assert.Equal(t, float64(1), resp["details"].(map[string]interface{})["soldAt"])
+	assert.Equal(t, []interface{}{"is sold"}, resp["fields"].(map[string]interface{})["name"])
+	assert.NotContains(t, resp, "key")
+
+	// the code is not http status
+	w = client.Post("/reduce", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &body)
+	assert.Equal(t, ErrCodeBadPassword, body.Code)
+	assert.Equal(t, "bad_password", body.Key)
+
+	resp = client.CheckResponse(t, client.Post("/error", nil))
+	assert.Equal(t, float64(http.StatusBadRequest), resp["code"])
+	assert.Equal(t, "bad_request", resp["key"])
+
+	w = client.Get("/docs/errors.json")
+	var codes []RpcErrCode
+	json.Unmarshal(w.Body.Bytes(), &codes)
+	assert.Contains(t, codes, RpcErrCode{Code: 20001, Key: "pet_not_found", Desc: "Pet not found", Status: http.StatusNotFound})
+	assert.Contains(t, codes, RpcErrCode{Code: 20002, Desc: "Pet is sold"})
+}
+
+func TestRpcErrStatus(t *testing.T) {
+	RegisterRpcErrCode(20003, "pet_gone", "Pet gone", http.StatusGone)
+	cfg := NewGinExt("..")
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set(ConfigField, cfg)
+	})
+	r.POST("/gone", func(c *gin.Context) {
+		RpcFail(c, 20003, "gone")
+	})
+	client := NewTestHTTPClient(r)
+	assert.Equal(t, http.StatusOK, client.Post("/gone", nil).Code)
+	cfg.RpcErrStatus = true
+	assert.Equal(t, http.StatusGone, client.Post("/gone", nil).Code)
+}
+
+func TestRpcErrAuth(t *testing.T) {
+	_, r := NewTestUserManager()
+	RpcDefine(r, &RpcContext{
+		AuthRequired: true,
+		RelativePath: "/mockapi/me",
+		Handler:      func(c *gin.Context) {},
+	})
+	client := NewTestHTTPClient(r)
+	w := client.Post("/mockapi/me", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	var body RpcErr
+	json.Unmarshal(w.Body.Bytes(), &body)
+	assert.Equal(t, http.StatusUnauthorized, body.Code)
+	assert.Equal(t, "auth_required", body.Key)
+}
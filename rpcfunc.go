@@ -0,0 +1,86 @@
+package ginext
+
+import (
+	"reflect"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RpcOption set the RpcContext of Rpc
+type RpcOption func(ctx *RpcContext)
+
+func WithAuthRequired() RpcOption {
+	return func(ctx *RpcContext) { ctx.AuthRequired = true }
+}
+
+func WithStaffRequired() RpcOption {
+	return func(ctx *RpcContext) { ctx.StaffRequired = true }
+}
+
+func WithOnlyPost() RpcOption {
+	return func(ctx *RpcContext) { ctx.OnlyPost = true }
+}
+
+func WithCsrfExempt() RpcOption {
+	return func(ctx *RpcContext) { ctx.CsrfExempt = true }
+}
+
+func WithReduceDataField() RpcOption {
+	return func(ctx *RpcContext) { ctx.ReduceDataField = true }
+}
+
+func WithMethods(methods ...string) RpcOption {
+	return func(ctx *RpcContext) { ctx.Methods = append(ctx.Methods, methods...) }
+}
+
+func WithMiddlewares(handlers ...gin.HandlerFunc) RpcOption {
+	return func(ctx *RpcContext) { ctx.Middlewares = append(ctx.Middlewares, handlers...) }
+}
+
+// WithDoc the markdown document of rpc
+func WithDoc(doc string) RpcOption {
+	return func(ctx *RpcContext) { ctx.Doc = doc }
+}
+
+// Rpc define the rpc with typed form and result, F is bound as RpcDefine, and the result
+// is sent by RpcOk. The error is sent by RpcAbort, use struct{} as F for the rpc without form.
+//
+//	Rpc(r, "/api/pet", func(c *gin.Context, form *PetForm) (PetResult, error) { ... }, WithAuthRequired())
+func Rpc[F, R any](r gin.IRoutes, relativePath string, handler func(c *gin.Context, form *F) (R, error), opts ...RpcOption) *RpcContext {
+	ctx := NewRpcContext(relativePath, handler, opts...)
+	RpcDefine(r, ctx)
+	return ctx
+}
+
+// NewRpcContext the RpcContext of typed handler, the Form and Result are the zero value of F and R
+func NewRpcContext[F, R any](relativePath string, handler func(c *gin.Context, form *F) (R, error), opts ...RpcOption) *RpcContext {
+	var form F
+	var result R
+	ctx := &RpcContext{
+		RelativePath: relativePath,
+		Result:       result,
+		Handler: func(c *gin.Context) {
+			obj, _ := c.Get(RpcFormField)
+			f, ok := obj.(*F)
+			if !ok {
+				f = new(F)
+			}
+			r, err := handler(c, f)
+			if err != nil {
+				RpcAbort(c, err)
+				return
+			}
+			if c.Writer.Written() {
+				return
+			}
+			RpcOk(c, r)
+		},
+	}
+	if rt := reflect.TypeOf(form); rt != nil && (rt.Kind() != reflect.Struct || rt.NumField() > 0) {
+		ctx.Form = form
+	}
+	for _, opt := range opts {
+		opt(ctx)
+	}
+	return ctx
+}
@@ -0,0 +1,81 @@
+package ginext
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type testPetForm struct {
+	Name string `json:"name" binding:"required"`
+}
+
+type testPetResult struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestRpcTyped(t *testing.T) {
+	r := gin.New()
+	ctx := Rpc(r, "/mockapi/pet", func(c *gin.Context, form *testPetForm) (*testPetResult, error) {
+		switch form.Name {
+		case "taken":
+			return nil, NewRpcErr(http.StatusConflict, "name taken")
+		case "broken":
+			return nil, errors.New("broken")
+		}
+		return &testPetResult{ID: 1, Name: form.Name}, nil
+	}, WithOnlyPost(), WithDoc("Create a pet"))
+	assert.True(t, ctx.OnlyPost)
+	assert.Equal(t, testPetForm{}, ctx.Form)
+
+	Rpc(r, "/mockapi/ping", func(c *gin.Context, form *struct{}) (string, error) {
+		return "pong", nil
+	})
+
+	client := NewTestHTTPClient(r)
+	var result testPetResult
+	err := client.Call("/mockapi/pet", testPetForm{Name: "kitty"}, &result)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1), result.ID)
+	assert.Equal(t, "kitty", result.Name)
+
+	resp := client.CheckResponse(t, client.Post("/mockapi/pet", map[string]interface{}{"name": "taken"}))
+	assert.Equal(t, float64(http.StatusConflict), resp["code"])
+	assert.Equal(t, "name taken", resp["msg"])
+
+	resp = client.CheckResponse(t, client.Post("/mockapi/pet", map[string]interface{}{"name": "broken"}))
+	assert.Equal(t, float64(http.StatusBadRequest), resp["code"])
+	assert.Equal(t, "broken", resp["msg"])
+
+	w := client.Post("/mockapi/pet", map[string]interface{}{})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var pong string
+	err = client.Call("/mockapi/ping", nil, &pong)
+	assert.Nil(t, err)
+	assert.Equal(t, "pong", pong)
+
+	// docs from the type parameters
+	doc, ok := RegistryOf(r).Lookup("/mockapi/pet")
+	assert.True(t, ok)
+	assert.Equal(t, "Create a pet", doc.DocString)
+	assert.Equal(t, "name", doc.Fields[0].Name)
+	assert.Equal(t, "object", doc.ResultType.Type)
+	doc, _ = RegistryOf(r).Lookup("/mockapi/ping")
+	assert.Nil(t, doc.Fields)
+	assert.Equal(t, "string", doc.ResultType.Type)
+}
+
+func TestCORSMethods(t *testing.T) {
+	r := gin.New()
+	r.Use(CORSMiddleware())
+	req, _ := http.NewRequest("OPTIONS", "/pets/1", nil)
+	w := NewTestHTTPClient(r).SendReq("/pets/1", req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Contains(t, w.Header().Get("Access-Control-Allow-Methods"), "PATCH")
+	assert.Contains(t, w.Header().Get("Access-Control-Allow-Methods"), "DELETE")
+}
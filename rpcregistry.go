@@ -0,0 +1,210 @@
+package ginext
+
+import (
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RpcRegistry hold the docs of rpc defined on one engine, and the groups bound to it
+type RpcRegistry struct {
+	mu     sync.RWMutex
+	labels []string
+	docs   []RpcDoc
+	index  map[string]int
+	label  string
+}
+
+var defaultRpcRegistry = NewRpcRegistry()
+
+func NewRpcRegistry() *RpcRegistry {
+	return &RpcRegistry{
+		index: map[string]int{},
+	}
+}
+
+// DefaultRpcRegistry hold the rpc defined on the routers without registry, and the package level AddDoc.
+// The docs are served by every doc site, see RegisterDocHandler
+func DefaultRpcRegistry() *RpcRegistry {
+	return defaultRpcRegistry
+}
+
+// RpcRouter the router bound with the registry, the rpc defined on it are added to Registry
+type RpcRouter struct {
+	gin.IRouter
+	Registry *RpcRegistry
+}
+
+// BasePath the path of the router, for the full path of rpc
+func (r *RpcRouter) BasePath() string {
+	if g, ok := r.IRouter.(interface{ BasePath() string }); ok {
+		return g.BasePath()
+	}
+	return "/"
+}
+
+// Router bind the router with the registry, e.g. the engine of GinExt: cfg.Registry.Router(r)
+func (reg *RpcRegistry) Router(r gin.IRouter) *RpcRouter {
+	if v, ok := r.(*RpcRouter); ok {
+		r = v.IRouter
+	}
+	return &RpcRouter{IRouter: r, Registry: reg}
+}
+
+// Group create the router group, the rpc defined on it are added to reg
+func (reg *RpcRegistry) Group(r gin.IRouter, relativePath string, handlers ...gin.HandlerFunc) *RpcRouter {
+	return reg.Router(r.Group(relativePath, handlers...))
+}
+
+// RegistryOf return the registry bound by RpcRegistry.Router, DefaultRpcRegistry for other routers
+func RegistryOf(r gin.IRoutes) *RpcRegistry {
+	if v, ok := r.(*RpcRouter); ok && v.Registry != nil {
+		return v.Registry
+	}
+	return defaultRpcRegistry
+}
+
+// AddAppLabel the docs added later are grouped by the label
+func (reg *RpcRegistry) AddAppLabel(label string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.addLabel(label)
+}
+
+func (reg *RpcRegistry) addLabel(label string) {
+	reg.label = label
+	for _, v := range reg.labels {
+		if v == label {
+			return
+		}
+	}
+	reg.labels = append(reg.labels, label)
+}
+
+// Add the doc, the doc of same path is replaced
+func (reg *RpcRegistry) Add(doc RpcDoc) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if len(doc.appLabel) <= 0 {
+		doc.appLabel = reg.label
+	}
+	reg.add(doc)
+}
+
+func (reg *RpcRegistry) add(doc RpcDoc) {
+	if len(doc.appLabel) > 0 {
+		label := reg.label
+		reg.addLabel(doc.appLabel)
+		reg.label = label
+	}
+	if idx, ok := reg.index[doc.RelativePath]; ok {
+		reg.docs[idx] = doc
+		return
+	}
+	reg.index[doc.RelativePath] = len(reg.docs)
+	reg.docs = append(reg.docs, doc)
+}
+
+// Merge the docs of other, with the app labels
+func (reg *RpcRegistry) Merge(other *RpcRegistry) {
+	if other == reg {
+		return
+	}
+	other.mu.RLock()
+	docs := append([]RpcDoc{}, other.docs...)
+	other.mu.RUnlock()
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	for _, doc := range docs {
+		reg.add(doc)
+	}
+}
+
+// Lookup the doc by path
+func (reg *RpcRegistry) Lookup(relativePath string) (RpcDoc, bool) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	idx, ok := reg.index[relativePath]
+	if !ok {
+		return RpcDoc{}, false
+	}
+	return reg.docs[idx], true
+}
+
+// Docs return the docs grouped by app labels, the label is the doc with IsGroup
+func (reg *RpcRegistry) Docs() []RpcDoc {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	docs := make([]RpcDoc, 0, len(reg.docs)+len(reg.labels))
+	for _, doc := range reg.docs {
+		if len(doc.appLabel) <= 0 {
+			docs = append(docs, doc)
+		}
+	}
+	for _, label := range reg.labels {
+		docs = append(docs, RpcDoc{IsGroup: true, RelativePath: label})
+		for _, doc := range reg.docs {
+			if doc.appLabel == label {
+				docs = append(docs, doc)
+			}
+		}
+	}
+	return docs
+}
+
+// RegisterDocHandler serve the docs site under prefix, e.g. /docs:
+//
+//	/docs/api.json, /docs/api, /docs/openapi.json, /docs/openapi.yaml, /docs/errors.json
+func (reg *RpcRegistry) RegisterDocHandler(r gin.IRoutes, prefix string) {
+	prefix = strings.TrimRight(prefix, "/")
+	jsonUri := prefix + "/api.json"
+	fullJSONUri := jsonUri
+	if g, ok := r.(interface{ BasePath() string }); ok {
+		fullJSONUri = path.Join(g.BasePath(), jsonUri)
+	}
+	html := strings.ReplaceAll(rpcDocHtml, ApiDocsJSONUri, fullJSONUri)
+
+	r.GET(jsonUri, func(c *gin.Context) {
+		c.JSON(http.StatusOK, reg.served().Docs())
+	})
+	r.GET(prefix+"/openapi.json", func(c *gin.Context) {
+		c.JSON(http.StatusOK, reg.served().OpenAPISpec(configOf(c)))
+	})
+	r.GET(prefix+"/openapi.yaml", func(c *gin.Context) {
+		data, err := reg.served().OpenAPIYAML(configOf(c))
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.Data(http.StatusOK, "application/yaml; charset=utf-8", data)
+	})
+	r.GET(prefix+"/errors.json", func(c *gin.Context) {
+		c.JSON(http.StatusOK, RpcErrCodes())
+	})
+	r.GET(prefix+"/api", func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(html))
+	})
+}
+
+// served the docs of reg with the docs of DefaultRpcRegistry
+func (reg *RpcRegistry) served() *RpcRegistry {
+	if reg == defaultRpcRegistry {
+		return reg
+	}
+	merged := NewRpcRegistry()
+	merged.Merge(defaultRpcRegistry)
+	merged.Merge(reg)
+	return merged
+}
+
+func configOf(c *gin.Context) *GinExt {
+	if obj, ok := c.Get(ConfigField); ok && obj != nil {
+		return obj.(*GinExt)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package ginext
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRpcRegistry(t *testing.T) {
+	cfg := NewGinExt("..")
+	cfg.Init()
+	adminCfg := NewGinExt("..")
+	adminCfg.Init()
+
+	publicEngine, adminEngine := gin.New(), gin.New()
+	public := cfg.Registry.Router(publicEngine)
+	admin := adminCfg.Registry.Router(adminEngine)
+
+	// defined before WithGinExt
+	RpcDefine(public, &RpcContext{
+		RelativePath: "/mockapi/early",
+		Handler:      func(c *gin.Context) {},
+	})
+	cfg.WithGinExt(publicEngine)
+	adminCfg.WithGinExt(adminEngine)
+
+	RegistryOf(public).AddAppLabel("Public")
+	RpcDefine(public, &RpcContext{
+		Form:         LoginForm{},
+		RelativePath: "/mockapi/login",
+		Handler:      func(c *gin.Context) {},
+	})
+	RegistryOf(admin).AddAppLabel("Admin")
+	RpcDefine(admin, &RpcContext{
+		StaffRequired: true,
+		RelativePath:  "/mockapi/users",
+		Handler:       func(c *gin.Context) {},
+	})
+
+	assert.Equal(t, cfg.Registry, RegistryOf(public))
+	assert.NotEqual(t, RegistryOf(public), RegistryOf(admin))
+	publicDocs := RegistryOf(public).Docs()
+	assert.Equal(t, 3, len(publicDocs))
+	assert.Equal(t, "/mockapi/early", publicDocs[0].RelativePath)
+	assert.True(t, publicDocs[1].IsGroup)
+	assert.Equal(t, "Public", publicDocs[1].RelativePath)
+	assert.Equal(t, "/mockapi/login", publicDocs[2].RelativePath)
+
+	_, ok := RegistryOf(public).Lookup("/mockapi/users")
+	assert.False(t, ok)
+	doc, ok := RegistryOf(admin).Lookup("/mockapi/users")
+	assert.True(t, ok)
+	assert.True(t, doc.StaffRequired)
+
+	// re-registered path is replaced
+	RegistryOf(public).Add(NewRpcDoc(&RpcContext{
+		OnlyPost:     true,
+		RelativePath: "/mockapi/login",
+	}))
+	assert.Equal(t, 3, len(RegistryOf(public).Docs()))
+	doc, _ = RegistryOf(public).Lookup("/mockapi/login")
+	assert.True(t, doc.OnlyPost)
+
+	// the label added again joins the same group
+	RegistryOf(public).AddAppLabel("Other")
+	RegistryOf(public).AddAppLabel("Public")
+	RegistryOf(public).Add(NewRpcDoc(&RpcContext{RelativePath: "/mockapi/profile"}))
+	publicDocs = RegistryOf(public).Docs()
+	assert.Equal(t, 5, len(publicDocs))
+	assert.Equal(t, "/mockapi/profile", publicDocs[3].RelativePath)
+	assert.Equal(t, "Other", publicDocs[4].RelativePath)
+
+	// the deprecated AddDoc is served by the doc sites
+	AddDoc(&RpcContext{RelativePath: "/mockapi/legacy"})
+
+	client := NewTestHTTPClient(adminEngine)
+	w := client.Get(ApiDocsJSONUri)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var docs []RpcDoc
+	json.Unmarshal(w.Body.Bytes(), &docs)
+	paths := map[string]bool{}
+	for _, v := range docs {
+		paths[v.RelativePath] = true
+	}
+	assert.True(t, paths["/mockapi/users"])
+	assert.True(t, paths["/mockapi/legacy"])
+	assert.False(t, paths["/mockapi/login"])
+}
+
+func TestRpcRegistryGroup(t *testing.T) {
+	r := gin.New()
+	reg := NewRpcRegistry()
+	assert.Equal(t, DefaultRpcRegistry(), RegistryOf(r))
+
+	g := reg.Group(r, "/admin")
+	assert.Equal(t, reg, RegistryOf(g))
+	assert.Equal(t, "/admin", g.BasePath())
+	reg.RegisterDocHandler(g, "/docs")
+
+	RpcDefine(g, &RpcContext{RelativePath: "/ping", Handler: func(c *gin.Context) {}})
+	_, ok := reg.Lookup("/admin/ping")
+	assert.True(t, ok)
+	// the nested group keeps the registry
+	sub := reg.Group(g, "/sub")
+	assert.Equal(t, "/admin/sub", sub.BasePath())
+	RpcDefine(sub, &RpcContext{RelativePath: "/pong", Handler: func(c *gin.Context) {}})
+	_, ok = reg.Lookup("/admin/sub/pong")
+	assert.True(t, ok)
+
+	// the registry of other is merged
+	other := NewRpcRegistry()
+	other.Add(NewRpcDoc(&RpcContext{RelativePath: "/other/ping"}))
+	reg.Merge(other)
+	_, ok = reg.Lookup("/other/ping")
+	assert.True(t, ok)
+
+	client := NewTestHTTPClient(r)
+	w := client.Get("/admin/docs/api")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "/admin/docs/api.json")
+	assert.NotContains(t, w.Body.String(), `"`+ApiDocsJSONUri+`"`)
+
+	w = client.Get("/admin/docs/api.json")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = client.Get("/admin/docs/openapi.json")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "/other/ping")
+}
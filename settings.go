@@ -0,0 +1,314 @@
+package ginext
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/gin-gonic/gin"
+	"github.com/go-sql-driver/mysql"
+	"gopkg.in/yaml.v2"
+)
+
+// Prefix of the environment variables, e.g. GINEXT_DB_DSN => db_dsn
+const SettingsEnvPrefix = "GINEXT_"
+
+// Select the settings.<env>.json layer, default is gin mode
+const SettingsEnvName = "GINEXT_ENV"
+
+const defaultSessionSecret = "ginext-session-secret"
+
+var settingsExts = []string{".json", ".yaml", ".yml", ".toml"}
+var settingsVarPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// The secret settings are masked in the flag defaults of -h
+var settingsSecrets = map[string]bool{"db_dsn": true, "session_secret": true, "password_salt": true, "smtp_password": true}
+
+const settingsSecretMask = "******"
+
+// LoadSettings load the settings with layers, the later overrides the former:
+//
+//	defaults < settings.json < settings.<env>.json < GINEXT_* env vars < flags
+func (c *GinExt) LoadSettings(isMigrate bool) error {
+	log.Default().SetFlags(log.Lshortfile | log.Ltime | log.Ldate)
+
+	for _, fileName := range c.settingsFiles() {
+		err := c.LoadSettingsFile(fileName)
+		if err != nil {
+			return fmt.Errorf("load conf fail %s - %v", fileName, err)
+		}
+		log.Println("Load done ", fileName)
+	}
+
+	if err := c.loadSettingsEnv(os.Environ()); err != nil {
+		return err
+	}
+
+	if err := c.loadSettingsFlags(); err != nil {
+		return err
+	}
+
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	return c.initLogger(isMigrate)
+}
+
+// settingsFiles return the exists settings files, settings.json first and then settings.<env>.json
+func (c *GinExt) settingsFiles() (files []string) {
+	ext := filepath.Ext(c.ConfFile)
+	base := strings.TrimSuffix(c.ConfFile, ext)
+
+	env := c.SettingsEnv
+	if len(env) <= 0 {
+		env = gin.Mode()
+	}
+
+	for _, name := range []string{base, base + "." + env} {
+		if fileName := lookupSettingsFile(name, ext); len(fileName) > 0 {
+			files = append(files, fileName)
+		}
+	}
+	return files
+}
+
+func lookupSettingsFile(base, preferExt string) string {
+	exts := append([]string{preferExt}, settingsExts...)
+	for _, ext := range exts {
+		st, err := os.Stat(base + ext)
+		if err == nil && !st.IsDir() {
+			return base + ext
+		}
+	}
+	return ""
+}
+
+// LoadSettingsFile load json/yaml/toml file into GinExt, ${VAR} and ${VAR:-default} are expanded
+// in the string values after parsing, so the env values can't break the file format
+func (c *GinExt) LoadSettingsFile(fileName string) error {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+
+	vals := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &vals)
+	case ".toml":
+		err = toml.Unmarshal(data, &vals)
+	default:
+		err = json.Unmarshal(data, &vals)
+	}
+	if err != nil {
+		return err
+	}
+
+	normalizeSettingsValue(vals)
+	// the expanded string of a number or bool field, e.g. "smtp_port": "${SMTP_PORT:-25}"
+	fields := c.settingsFields()
+	for name, v := range vals {
+		field, ok := fields[name]
+		if str, isStr := v.(string); ok && isStr && field.Kind() != reflect.String {
+			if err := setSettingsField(field, str); err != nil {
+				return fmt.Errorf("bad %s - %v", name, err)
+			}
+			delete(vals, name)
+		}
+	}
+
+	data, err = json.Marshal(vals)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, c)
+}
+
+// ExpandSettingsVars replace ${VAR} and ${VAR:-default} with the environment variables
+func ExpandSettingsVars(text string) string {
+	return settingsVarPattern.ReplaceAllStringFunc(text, func(s string) string {
+		m := settingsVarPattern.FindStringSubmatch(s)
+		if val, ok := os.LookupEnv(m[1]); ok {
+			return val
+		}
+		return m[3]
+	})
+}
+
+// yaml.v2 decode the nested map as map[interface{}]interface{}, which json can't marshal,
+// and the env vars in the string values are expanded
+func normalizeSettingsValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case string:
+		return ExpandSettingsVars(val)
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for k, item := range val {
+			m[fmt.Sprint(k)] = normalizeSettingsValue(item)
+		}
+		return m
+	case map[string]interface{}:
+		for k, item := range val {
+			val[k] = normalizeSettingsValue(item)
+		}
+		return val
+	case []interface{}:
+		for i, item := range val {
+			val[i] = normalizeSettingsValue(item)
+		}
+		return val
+	}
+	return v
+}
+
+// settingsFields return the json name => field of the settings
+func (c *GinExt) settingsFields() map[string]reflect.Value {
+	fields := map[string]reflect.Value{}
+	rv := reflect.ValueOf(c).Elem()
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if len(name) <= 0 || name == "-" || f.PkgPath != "" {
+			continue
+		}
+		fields[name] = rv.Field(i)
+	}
+	return fields
+}
+
+func setSettingsField(field reflect.Value, val string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(val)
+	case reflect.Bool:
+		v, err := strconv.ParseBool(val)
+		if err != nil {
+			return err
+		}
+		field.SetBool(v)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(v)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v, err := strconv.ParseUint(val, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetUint(v)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+func (c *GinExt) loadSettingsEnv(environ []string) error {
+	fields := c.settingsFields()
+	for _, kv := range environ {
+		vals := strings.SplitN(kv, "=", 2)
+		if len(vals) != 2 || !strings.HasPrefix(vals[0], SettingsEnvPrefix) {
+			continue
+		}
+		name := strings.ToLower(strings.TrimPrefix(vals[0], SettingsEnvPrefix))
+		field, ok := fields[name]
+		if !ok {
+			continue
+		}
+		if err := setSettingsField(field, vals[1]); err != nil {
+			return fmt.Errorf("bad env %s - %v", vals[0], err)
+		}
+	}
+	return nil
+}
+
+// RegisterFlags define the settings flags, e.g. -db-dsn, -serve-addr.
+// The flags explicitly set override all other layers when LoadSettings
+func (c *GinExt) RegisterFlags(fs *flag.FlagSet) {
+	for name, field := range c.settingsFields() {
+		flagName := strings.ReplaceAll(name, "_", "-")
+		if fs.Lookup(flagName) != nil {
+			continue
+		}
+		defValue := fmt.Sprint(field.Interface())
+		if settingsSecrets[name] && len(defValue) > 0 {
+			defValue = settingsSecretMask
+		}
+		fs.String(flagName, defValue, "settings "+name)
+	}
+	c.flagSet = fs
+}
+
+func (c *GinExt) loadSettingsFlags() (err error) {
+	if c.flagSet == nil || !c.flagSet.Parsed() {
+		return nil
+	}
+	fields := c.settingsFields()
+	c.flagSet.Visit(func(f *flag.Flag) {
+		field, ok := fields[strings.ReplaceAll(f.Name, "-", "_")]
+		if !ok || err != nil {
+			return
+		}
+		if e := setSettingsField(field, f.Value.String()); e != nil {
+			err = fmt.Errorf("bad flag -%s - %v", f.Name, e)
+		}
+	})
+	return err
+}
+
+// Validate check the settings, fail fast before the server starts
+func (c *GinExt) Validate() error {
+	switch c.DbDriver {
+	case "sqlite":
+	case "mysql":
+		if len(c.DbDSN) > 0 {
+			if _, err := mysql.ParseDSN(c.DbDSN); err != nil {
+				return fmt.Errorf("bad db_dsn - %v", err)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown db_driver %s", c.DbDriver)
+	}
+
+	if len(c.SessionStore) > 0 {
+		if len(c.SessionSecret) <= 0 {
+			return errors.New("session_secret is required")
+		}
+		if gin.Mode() == gin.ReleaseMode && c.SessionSecret == defaultSessionSecret {
+			return errors.New("session_secret must be changed in release mode")
+		}
+	}
+
+	if c.CsrfMode != CsrfModeSession && c.CsrfMode != CsrfModeCookie {
+		return fmt.Errorf("unknown csrf_mode %s", c.CsrfMode)
+	}
+
+	switch c.MailDriver {
+	case "", MailDriverFile, MailDriverMemory:
+	case MailDriverSMTP:
+		if len(c.SmtpHost) <= 0 {
+			return errors.New("smtp_host is required")
+		}
+		switch c.SmtpTLS {
+		case "", SmtpTLSStartTLS, SmtpTLSImplicit, SmtpTLSNone:
+		default:
+			return fmt.Errorf("unknown smtp_tls %s", c.SmtpTLS)
+		}
+	default:
+		return fmt.Errorf("unknown mail_driver %s", c.MailDriver)
+	}
+	return nil
+}
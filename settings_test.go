@@ -0,0 +1,142 @@
+package ginext
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSettingsExt(t *testing.T, files map[string]string) *GinExt {
+	dir, err := ioutil.TempDir("", "ginext_settings")
+	assert.Nil(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	os.MkdirAll(filepath.Join(dir, "conf"), 0755)
+	for name, content := range files {
+		err = ioutil.WriteFile(filepath.Join(dir, "conf", name), []byte(content), 0644)
+		assert.Nil(t, err)
+	}
+	ext := NewGinExt(dir)
+	ext.SettingsEnv = "unittest"
+	return ext
+}
+
+func TestSettingsLayers(t *testing.T) {
+	os.Setenv("GINEXT_UNITTEST_DSN", "file:expand.db")
+	os.Setenv("GINEXT_SERVE_ADDR", ":9090")
+	defer os.Unsetenv("GINEXT_UNITTEST_DSN")
+	defer os.Unsetenv("GINEXT_SERVE_ADDR")
+
+	ext := newTestSettingsExt(t, map[string]string{
+		"settings.json":          `{"db_dsn":"${GINEXT_UNITTEST_DSN}","session_name":"base","serve_addr":":8081","password_salt":"${GINEXT_UNITTEST_MISSING:-salt}"}`,
+		"settings.unittest.json": `{"session_name":"env"}`,
+	})
+	fs := flag.NewFlagSet("unittest", flag.ContinueOnError)
+	ext.RegisterFlags(fs)
+	assert.Nil(t, fs.Parse([]string{"-csrf-enabled=true"}))
+
+	err := ext.LoadSettings(true)
+	assert.Nil(t, err)
+	assert.Equal(t, "file:expand.db", ext.DbDSN)
+	assert.Equal(t, "salt", ext.PasswordSalt)
+	assert.Equal(t, "env", ext.SessionName)
+	assert.Equal(t, ":9090", ext.ServeAddr)
+	assert.True(t, ext.CsrfEnabled)
+}
+
+func TestSettingsExpandValues(t *testing.T) {
+	// the env value with quote and newline can't inject keys
+	os.Setenv("GINEXT_UNITTEST_NAME", `x","csrf_enabled":true,"a":"`+"\n")
+	os.Setenv("GINEXT_UNITTEST_PORT", "2525")
+	defer os.Unsetenv("GINEXT_UNITTEST_NAME")
+	defer os.Unsetenv("GINEXT_UNITTEST_PORT")
+
+	ext := newTestSettingsExt(t, map[string]string{
+		"settings.json": `{"session_name":"${GINEXT_UNITTEST_NAME}","smtp_port":"${GINEXT_UNITTEST_PORT}"}`,
+	})
+	err := ext.LoadSettings(true)
+	assert.Nil(t, err)
+	assert.Equal(t, `x","csrf_enabled":true,"a":"`+"\n", ext.SessionName)
+	assert.False(t, ext.CsrfEnabled)
+	assert.Equal(t, 2525, ext.SmtpPort)
+}
+
+func TestSettingsFlagsMaskSecrets(t *testing.T) {
+	ext := newTestSettingsExt(t, map[string]string{})
+	ext.SessionSecret = "top-secret"
+	ext.DbDSN = "user:pass@tcp(db)/app"
+	fs := flag.NewFlagSet("unittest", flag.ContinueOnError)
+	ext.RegisterFlags(fs)
+	assert.Equal(t, settingsSecretMask, fs.Lookup("session-secret").DefValue)
+	assert.Equal(t, settingsSecretMask, fs.Lookup("db-dsn").DefValue)
+
+	assert.Nil(t, fs.Parse(nil))
+	assert.Nil(t, ext.LoadSettings(true))
+	assert.Equal(t, "top-secret", ext.SessionSecret)
+}
+
+func TestSettingsYamlToml(t *testing.T) {
+	{
+		ext := newTestSettingsExt(t, map[string]string{
+			"settings.yaml": "session_name: yaml\ncsrf_enabled: true\n",
+		})
+		err := ext.LoadSettings(true)
+		assert.Nil(t, err)
+		assert.Equal(t, "yaml", ext.SessionName)
+		assert.True(t, ext.CsrfEnabled)
+	}
+	{
+		ext := newTestSettingsExt(t, map[string]string{
+			"settings.toml":          "session_name = \"toml\"\n",
+			"settings.unittest.yaml": "serve_addr: \":7070\"\n",
+		})
+		err := ext.LoadSettings(true)
+		assert.Nil(t, err)
+		assert.Equal(t, "toml", ext.SessionName)
+		assert.Equal(t, ":7070", ext.ServeAddr)
+	}
+}
+
+func TestSettingsValidate(t *testing.T) {
+	{
+		ext := newTestSettingsExt(t, map[string]string{
+			"settings.json": `{"db_driver":"mysql","db_dsn":"bad dsn"}`,
+		})
+		err := ext.LoadSettings(true)
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "db_dsn")
+	}
+	{
+		ext := newTestSettingsExt(t, map[string]string{
+			"settings.json": `{"db_driver":"oracle"}`,
+		})
+		assert.NotNil(t, ext.LoadSettings(true))
+	}
+	{
+		ext := newTestSettingsExt(t, map[string]string{
+			"settings.json": `{"session_secret":""}`,
+		})
+		assert.NotNil(t, ext.LoadSettings(true))
+	}
+	{
+		ext := newTestSettingsExt(t, map[string]string{
+			"settings.json": `{bad json`,
+		})
+		assert.NotNil(t, ext.LoadSettings(true))
+	}
+	{
+		gin.SetMode(gin.ReleaseMode)
+		defer gin.SetMode(gin.DebugMode)
+		ext := newTestSettingsExt(t, nil)
+		assert.NotNil(t, ext.LoadSettings(true))
+		assert.NotNil(t, ext.Init())
+
+		ext.SessionSecret = "unittest-secret"
+		assert.Nil(t, ext.Validate())
+	}
+}
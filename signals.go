@@ -116,6 +116,10 @@ func (s *Signals) Emit(event string, sender interface{}, params ...interface{})
 	if !ok {
 		return
 	}
+
+	span := startSignalSpan(event, params)
+	defer span.End()
+
 	for _, sig := range sigs {
 		sig.Handler(sender, params...)
 	}
@@ -0,0 +1,78 @@
+package ginext
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+)
+
+var ErrInvalidPhone = errors.New("invalid phone number")
+
+// SMSSender send the text message to the E.164 phone number
+type SMSSender interface {
+	Send(ctx context.Context, phone, text string) error
+}
+
+type SMS struct {
+	Phone string `json:"phone"`
+	Text  string `json:"text"`
+}
+
+// MemorySMSSender capture the messages, for testing
+type MemorySMSSender struct {
+	mu       sync.Mutex
+	messages []SMS
+}
+
+func (s *MemorySMSSender) Send(ctx context.Context, phone, text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, SMS{Phone: phone, Text: text})
+	return nil
+}
+
+// Messages return the captured messages
+func (s *MemorySMSSender) Messages() []SMS {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]SMS{}, s.messages...)
+}
+
+func (s *MemorySMSSender) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = nil
+}
+
+// NormalizePhone the E.164 format of phone, e.g. "(0) 138-0013-8000" with countryCode 86 => +8613800138000.
+// The 00 prefix is the international call prefix, the number without + or 00 requires the countryCode
+func NormalizePhone(phone, countryCode string) (string, error) {
+	var sb strings.Builder
+	for i, r := range strings.TrimSpace(phone) {
+		switch {
+		case r >= '0' && r <= '9':
+			sb.WriteRune(r)
+		case r == '+' && i == 0:
+			sb.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+		default:
+			return "", ErrInvalidPhone
+		}
+	}
+
+	v := sb.String()
+	switch {
+	case strings.HasPrefix(v, "+"):
+	case strings.HasPrefix(v, "00"):
+		v = "+" + v[2:]
+	case len(countryCode) > 0:
+		v = "+" + strings.TrimPrefix(countryCode, "+") + strings.TrimLeft(v, "0")
+	default:
+		return "", ErrInvalidPhone
+	}
+	if !phonePattern.MatchString(v) {
+		return "", ErrInvalidPhone
+	}
+	return v, nil
+}
@@ -0,0 +1,27 @@
+package ginext
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizePhone(t *testing.T) {
+	for _, v := range []struct {
+		phone, countryCode, expected string
+	}{
+		{"+86 138-0013-8000", "", "+8613800138000"},
+		{"0086 13800138000", "", "+8613800138000"},
+		{"(0) 138.0013.8000", "86", "+8613800138000"},
+		{"(415) 555-2671", "+1", "+14155552671"},
+	} {
+		phone, err := NormalizePhone(v.phone, v.countryCode)
+		assert.Nil(t, err, v.phone)
+		assert.Equal(t, v.expected, phone)
+	}
+
+	for _, v := range []string{"13800138000", "+86 138#0013", "+0123456789", "+86", "1+8613800138000"} {
+		_, err := NormalizePhone(v, "")
+		assert.Equal(t, ErrInvalidPhone, err, v)
+	}
+}
@@ -6,16 +6,32 @@ import (
 )
 
 const (
-	//SigUserLogin: user *GinExtUser, c *gin.Context
+	//SigUserLogin: user *GinExtUser, c *gin.Context, the user is nil on logout for the old listeners
 	SigUserLogin = "user.login"
 	//SigUserLogout: user *GinExtUser, c *gin.Context
 	SigUserLogout = "user.logout"
 	//SigUserCreate: user *GinExtUser, c *gin.Context
 	SigUserCreate = "user.create"
-	//SigUserVerifyEmail: user *GinExtUser, email string , code, locale string
+	//SigUserVerifyEmail: user *GinExtUser, email string , code, locale string, c *gin.Context
 	SigUserVerifyEmail = "user.verifyemail"
-	//SigUserResetpassword: user *GinExtUser, email string , code, locale string
+	//SigUserVerifyPhone: user *GinExtUser, phone string , code, locale string, c *gin.Context
+	SigUserVerifyPhone = "user.verifyphone"
+	//SigUserMagicLink: user *GinExtUser, email string , link, locale string, c *gin.Context
+	SigUserMagicLink = "user.magiclink"
+	//SigUserResetpassword: user *GinExtUser, email string , code, locale string, c *gin.Context
 	SigUserResetpassword = "user.resetpassword"
+	//SigUserPasswordChanged: user *GinExtUser, c *gin.Context, the tokens and other sessions are revoked
+	SigUserPasswordChanged = "user.passwordchanged"
+	//SigUserDelete: user *GinExtUser, tx *gorm.DB, delete the data of user in tx
+	SigUserDelete = "user.delete"
+	//SigUserExport: user *GinExtUser, archive map[string]interface{}, add the data of user into archive
+	SigUserExport = "user.export"
+	//SigUserImpersonate: staff *GinExtUser, user *GinExtUser, c *gin.Context
+	SigUserImpersonate = "user.impersonate"
+	//SigOrgInvite: inviter *GinExtUser, org *GinOrganization, email, token, locale string, c *gin.Context
+	SigOrgInvite = "org.invite"
+	//SigSettingChanged: sender nil, key, value string
+	SigSettingChanged = "setting.changed"
 )
 
 func Login(c *gin.Context, user *GinExtUser) {
@@ -23,7 +39,13 @@ func Login(c *gin.Context, user *GinExtUser) {
 	um.SetLastLogin(user, c.ClientIP())
 	session := sessions.Default(c)
 	session.Set(UserIdField, user.ID)
+	session.Set(SessionVersionField, user.SessionVersion)
+	session.Delete(WebAuthnUserField)
+	session.Delete(ImpersonatorField)
+	session.Delete(OrgIdField)
 	session.Save()
+	c.Set(OrgIdField, nil)
+	um.audit(c, user.ID, AuditLogin, auditID(user.ID), nil)
 	Sig().Emit(SigUserLogin, user, c)
 }
 
@@ -43,6 +65,10 @@ func CurrentUser(c *gin.Context) (user *GinExtUser) {
 	if result.Error != nil {
 		return nil
 	}
+	// logout by ForceLogout
+	if version, _ := session.Get(SessionVersionField).(int); version != user.SessionVersion {
+		return nil
+	}
 	c.Set(UserIdField, user)
 	return user
 }
@@ -55,9 +81,19 @@ func CurrentToken(c *gin.Context) (token *GinToken) {
 }
 
 func Logout(c *gin.Context) {
+	user := CurrentUser(c)
 	c.Set(UserIdField, nil)
 	session := sessions.Default(c)
 	session.Delete(UserIdField)
+	session.Delete(WebAuthnUserField)
+	session.Delete(ImpersonatorField)
+	session.Delete(OrgIdField)
 	session.Save()
+	c.Set(OrgIdField, nil)
+	if user != nil {
+		um := c.MustGet(UserMangerField).(*UserManager)
+		um.audit(c, user.ID, AuditLogout, auditID(user.ID), nil)
+	}
 	Sig().Emit(SigUserLogin, nil, c)
+	Sig().Emit(SigUserLogout, user, c)
 }
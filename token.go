@@ -3,21 +3,28 @@ package ginext
 import (
 	"errors"
 	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 func (um *UserManager) MakeToken(user *GinExtUser) (obj GinToken, err error) {
 	tx := um.db.Model(&GinToken{})
 	token := GenUniqueKey(tx, "token", defaultTokenLength)
 	obj = GinToken{
-		CreatedAt: time.Now(),
-		OwnerID:   user.ID,
-		Token:     token,
-		ExpiredAt: time.Now().Add(um.TokenExpired),
+		CreatedAt:      time.Now(),
+		OwnerID:        user.ID,
+		Token:          token,
+		ExpiredAt:      time.Now().Add(um.TokenExpired),
+		SessionVersion: user.SessionVersion,
 	}
 	result := um.db.Create(&obj)
 	if result.Error != nil {
 		return obj, result.Error
 	}
+	um.ext.Metrics.incTokenIssued()
+	um.audit(nil, user.ID, AuditTokenIssue, auditID(user.ID), gin.H{"tokenId": obj.ID, "expiredAt": obj.ExpiredAt})
 	return obj, nil
 }
 
@@ -40,7 +47,50 @@ func (um *UserManager) TouchToken(tokenId uint) (expire time.Time, err error) {
 	return expire, result.Error
 }
 
+// RevokeTokens delete all the tokens of user
+func (um *UserManager) RevokeTokens(user *GinExtUser) (err error) {
+	result := um.db.Where("owner_id", user.ID).Delete(GinToken{})
+	if result.Error == nil && result.RowsAffected > 0 {
+		um.audit(nil, user.ID, AuditTokenRevoke, auditID(user.ID), gin.H{"count": result.RowsAffected})
+	}
+	return result.Error
+}
+
+// ForceLogout revoke the tokens and the sessions of user
+func (um *UserManager) ForceLogout(user *GinExtUser) (err error) {
+	result := um.db.Model(user).UpdateColumn("session_version", gorm.Expr("session_version + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	um.db.Select("session_version").Where("id", user.ID).Take(user)
+	return um.RevokeTokens(user)
+}
+
+// changePassword set the password and force logout, the session of c is kept when it is the user,
+// SigUserPasswordChanged is emitted
+func (um *UserManager) changePassword(c *gin.Context, user *GinExtUser, password string) error {
+	um.SetPassword(user, password)
+	if err := um.ForceLogout(user); err != nil {
+		return err
+	}
+	if current := CurrentUser(c); current != nil && current.ID == user.ID {
+		current.SessionVersion = user.SessionVersion
+		session := sessions.Default(c)
+		session.Set(SessionVersionField, user.SessionVersion)
+		session.Save()
+	}
+	Sig().Emit(SigUserPasswordChanged, user, c)
+	return nil
+}
+
 func (um *UserManager) DeleteToken(token string) (err error) {
-	result := um.db.Where("token", token).Delete(GinToken{})
+	var obj GinToken
+	if um.db.Where("token", token).Take(&obj).Error != nil {
+		return nil
+	}
+	result := um.db.Delete(&obj)
+	if result.Error == nil && result.RowsAffected > 0 {
+		um.audit(nil, obj.OwnerID, AuditTokenRevoke, auditID(obj.OwnerID), gin.H{"tokenId": obj.ID})
+	}
 	return result.Error
 }
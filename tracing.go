@@ -0,0 +1,224 @@
+package ginext
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const TracerName = "github.com/restsend/ginext"
+
+const tracingSpanKey = "ginext:span"
+const rpcSpanField = "ginext_rpc_span"
+
+func tracer() trace.Tracer {
+	return otel.Tracer(TracerName)
+}
+
+// NewTracerProvider create the provider with exporter, and set it as the global provider.
+// Without it, all the spans are no-op.
+func NewTracerProvider(serviceName string, exporter sdktrace.SpanExporter) *sdktrace.TracerProvider {
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return tp
+}
+
+// InitTracing export the spans to the OTLP/HTTP endpoint of settings
+func (c *GinExt) InitTracing(ctx context.Context) (*sdktrace.TracerProvider, error) {
+	opts := []otlptracehttp.Option{}
+	if len(c.TraceEndpoint) > 0 {
+		opts = append(opts, otlptracehttp.WithEndpoint(c.TraceEndpoint))
+	}
+	if c.TraceInsecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	serviceName := c.TraceServiceName
+	if len(serviceName) <= 0 {
+		serviceName = "ginext"
+	}
+	return NewTracerProvider(serviceName, exporter), nil
+}
+
+func startRpcSpan(c *gin.Context, relativePath string) trace.Span {
+	parent := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+	ctx, span := tracer().Start(parent, "rpc "+relativePath,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.method", c.Request.Method),
+			attribute.String("http.route", relativePath),
+		))
+	setSpanContext(c, ctx)
+	c.Set(rpcSpanField, span)
+	return span
+}
+
+// startChildSpan start the span under current span, the request context is updated
+// when inherit, so the db queries of handler are the children of it.
+func startChildSpan(c *gin.Context, name string, inherit bool) trace.Span {
+	ctx, span := tracer().Start(c.Request.Context(), name)
+	if inherit {
+		setSpanContext(c, ctx)
+	}
+	return span
+}
+
+func setSpanContext(c *gin.Context, ctx context.Context) {
+	c.Request = c.Request.WithContext(ctx)
+	if obj, ok := c.Get(DBField); ok && obj != nil {
+		c.Set(DBField, obj.(*gorm.DB).WithContext(ctx))
+	}
+}
+
+func setRpcSpanFail(c *gin.Context, code int, msg string) {
+	obj, ok := c.Get(rpcSpanField)
+	if !ok {
+		return
+	}
+	span := obj.(trace.Span)
+	if !span.IsRecording() {
+		return
+	}
+	span.SetAttributes(attribute.Int("rpc.code", code))
+	span.SetStatus(codes.Error, msg)
+}
+
+// requestDB bind the request context to db, the queries are traced under the rpc span
+func requestDB(c *gin.Context, db *gorm.DB) *gorm.DB {
+	if c == nil || c.Request == nil {
+		return db
+	}
+	return db.WithContext(c.Request.Context())
+}
+
+// signalContext find the context in params, *gin.Context or context.Context
+func signalContext(params []interface{}) context.Context {
+	for _, p := range params {
+		switch v := p.(type) {
+		case *gin.Context:
+			if v != nil && v.Request != nil {
+				return v.Request.Context()
+			}
+		case context.Context:
+			if v != nil {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+func startSignalSpan(event string, params []interface{}) trace.Span {
+	ctx := signalContext(params)
+	if ctx == nil || !trace.SpanContextFromContext(ctx).IsValid() {
+		return trace.SpanFromContext(context.Background())
+	}
+	_, span := tracer().Start(ctx, "signal "+event, trace.WithAttributes(attribute.String("signal.name", event)))
+	return span
+}
+
+// injectTraceContext serialize the trace context, so the worker can continue the trace
+func injectTraceContext(ctx context.Context) string {
+	if ctx == nil || !trace.SpanContextFromContext(ctx).IsValid() {
+		return ""
+	}
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	data, _ := json.Marshal(carrier)
+	return string(data)
+}
+
+func extractTraceContext(val string) context.Context {
+	ctx := context.Background()
+	if len(val) <= 0 {
+		return ctx
+	}
+	carrier := propagation.MapCarrier{}
+	if err := json.Unmarshal([]byte(val), &carrier); err != nil {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
+
+// TracingPlugin create the child spans of gorm queries, only when the statement context has a span
+type TracingPlugin struct{}
+
+func (p *TracingPlugin) Name() string {
+	return "ginext:tracing"
+}
+
+func (p *TracingPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	errs := []error{
+		cb.Create().Before("gorm:create").Register("ginext:trace_before_create", beforeGormTrace("create")),
+		cb.Create().After("gorm:create").Register("ginext:trace_after_create", afterGormTrace),
+		cb.Query().Before("gorm:query").Register("ginext:trace_before_query", beforeGormTrace("query")),
+		cb.Query().After("gorm:query").Register("ginext:trace_after_query", afterGormTrace),
+		cb.Update().Before("gorm:update").Register("ginext:trace_before_update", beforeGormTrace("update")),
+		cb.Update().After("gorm:update").Register("ginext:trace_after_update", afterGormTrace),
+		cb.Delete().Before("gorm:delete").Register("ginext:trace_before_delete", beforeGormTrace("delete")),
+		cb.Delete().After("gorm:delete").Register("ginext:trace_after_delete", afterGormTrace),
+		cb.Row().Before("gorm:row").Register("ginext:trace_before_row", beforeGormTrace("row")),
+		cb.Row().After("gorm:row").Register("ginext:trace_after_row", afterGormTrace),
+		cb.Raw().Before("gorm:raw").Register("ginext:trace_before_raw", beforeGormTrace("raw")),
+		cb.Raw().After("gorm:raw").Register("ginext:trace_after_raw", afterGormTrace),
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func beforeGormTrace(op string) func(*gorm.DB) {
+	return func(tx *gorm.DB) {
+		ctx := tx.Statement.Context
+		if ctx == nil || !trace.SpanContextFromContext(ctx).IsValid() {
+			return
+		}
+		_, span := tracer().Start(ctx, "gorm."+op,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("db.system", tx.Dialector.Name()),
+				attribute.String("db.sql.table", tx.Statement.Table),
+			))
+		tx.InstanceSet(tracingSpanKey, span)
+	}
+}
+
+func afterGormTrace(tx *gorm.DB) {
+	obj, ok := tx.InstanceGet(tracingSpanKey)
+	if !ok {
+		return
+	}
+	span := obj.(trace.Span)
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("db.statement", tx.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", tx.Statement.RowsAffected),
+	)
+	if tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		span.RecordError(tx.Error)
+		span.SetStatus(codes.Error, tx.Error.Error())
+	}
+}
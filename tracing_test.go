@@ -0,0 +1,133 @@
+package ginext
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gorm.io/gorm"
+)
+
+func newTestTracer(t *testing.T) func() tracetest.SpanStubs {
+	prevProvider := otel.GetTracerProvider()
+	prevPropagator := otel.GetTextMapPropagator()
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := NewTracerProvider("unittest", exporter)
+	t.Cleanup(func() {
+		tp.Shutdown(context.Background())
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	})
+	return func() tracetest.SpanStubs {
+		tp.ForceFlush(context.Background())
+		return exporter.GetSpans()
+	}
+}
+
+func findSpan(spans tracetest.SpanStubs, name string) *tracetest.SpanStub {
+	for i := range spans {
+		if spans[i].Name == name {
+			return &spans[i]
+		}
+	}
+	return nil
+}
+
+func TestRpcTracing(t *testing.T) {
+	flush := newTestTracer(t)
+	um, r := NewTestUserManager()
+	um.RegisterHandler("/auth", r)
+	RpcDefine(r, &RpcContext{
+		Form:         SettingListForm{},
+		Result:       SettingListResult{},
+		RelativePath: "/unittest/settings",
+		Handler: func(c *gin.Context) {
+			form := c.MustGet(RpcFormField).(*SettingListForm)
+			var r SettingListResult
+			ListObject(c, c.MustGet(DBField).(*gorm.DB).Model(&GinExtConfig{}), &r, &form.PaginationForm, "key", "")
+		},
+	})
+
+	sigID := Sig().Connect(SigUserCreate, func(sender interface{}, params ...interface{}) {})
+	defer Sig().Disconnect(SigUserCreate, sigID)
+
+	client := NewTestHTTPClient(r)
+	addUser(t, client, r, "bob", "bob@example.org", "hello123")
+	err := client.Call("/auth/login", &LoginForm{UserName: "bob", Password: "bad"}, nil)
+	assert.NotNil(t, err)
+	err = client.Call("/unittest/settings", &SettingListForm{}, nil)
+	assert.Nil(t, err)
+
+	spans := flush()
+	rpcSpan := findSpan(spans, "rpc /auth/register")
+	assert.NotNil(t, rpcSpan)
+
+	bindSpan := findSpan(spans, "rpc.bind")
+	assert.NotNil(t, bindSpan)
+	assert.Equal(t, rpcSpan.SpanContext.SpanID(), bindSpan.Parent.SpanID())
+
+	handlerSpan := findSpan(spans, "rpc.handler")
+	assert.NotNil(t, handlerSpan)
+	assert.Equal(t, rpcSpan.SpanContext.SpanID(), handlerSpan.Parent.SpanID())
+
+	listSpan := findSpan(spans, "rpc /unittest/settings")
+	assert.NotNil(t, listSpan)
+	querySpan := findSpan(spans, "gorm.query")
+	assert.NotNil(t, querySpan)
+	assert.Equal(t, listSpan.SpanContext.TraceID(), querySpan.SpanContext.TraceID())
+	assert.Contains(t, querySpan.Attributes, attribute.String("db.sql.table", "gin_ext_configs"))
+
+	sigSpan := findSpan(spans, "signal "+SigUserCreate)
+	assert.NotNil(t, sigSpan)
+	assert.Equal(t, rpcSpan.SpanContext.TraceID(), sigSpan.SpanContext.TraceID())
+
+	loginSpan := findSpan(spans, "rpc /auth/login")
+	assert.NotNil(t, loginSpan)
+	assert.Equal(t, "Error", loginSpan.Status.Code.String())
+}
+
+func TestWorkerTracing(t *testing.T) {
+	defer Tidyup()
+	flush := newTestTracer(t)
+	wm := NewTestWorkerManager()
+
+	ctx, reqSpan := tracer().Start(context.Background(), "request")
+	err := wm.AddContext(ctx, 1, "traced", "{}", 0)
+	assert.Nil(t, err)
+	reqSpan.End()
+
+	var task GinTask
+	result := wm.db.Where("task_type", "traced").Take(&task)
+	assert.Nil(t, result.Error)
+	assert.Contains(t, task.TraceContext, "traceparent")
+
+	w := NewWorker(wm.db, "traced worker")
+	w.AddHandle("traced", func(t *GinTask) (string, error) {
+		var count int64
+		wm.db.WithContext(t.Ctx()).Model(&GinTask{}).Count(&count)
+		return "", nil
+	})
+	assert.Nil(t, w.pullTasks())
+
+	spans := flush()
+	taskSpan := findSpan(spans, "task traced")
+	assert.NotNil(t, taskSpan)
+	assert.Equal(t, reqSpan.SpanContext().TraceID(), taskSpan.SpanContext.TraceID())
+	assert.Equal(t, reqSpan.SpanContext().SpanID(), taskSpan.Parent.SpanID())
+
+	querySpan := findSpan(spans, "gorm.row")
+	if querySpan == nil {
+		querySpan = findSpan(spans, "gorm.query")
+	}
+	assert.NotNil(t, querySpan)
+	assert.Equal(t, taskSpan.SpanContext.SpanID(), querySpan.Parent.SpanID())
+
+	// no trace context, the task is still executed
+	assert.Nil(t, wm.Add(1, "traced", "{}", 0))
+	assert.Nil(t, w.pullTasks())
+}
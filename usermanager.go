@@ -7,23 +7,13 @@ import (
 	"log"
 	"reflect"
 	"strings"
+	"sync"
 	"time"
 
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
-const (
-	errUsernameExists = 10000 + iota
-	errEmailExists
-	errBadPassword
-	errInvalidParams
-	errNotAllowed
-	errActiveRequired
-	errBadVerifyCode
-	errServerError
-)
-
 const defaultTokenExpired = 7 * 86400 * time.Second
 const defaultTokenLength = 24
 const key_ACTIVE_REQUIRED = "GINEXT_ACTIVE_REQUIRED"
@@ -32,6 +22,19 @@ const defaultVerifyKeyLength = 24
 const defaultVerifyCodeExpired = 180 * time.Second
 const defaultVerifyMaxFailCount = 5
 const defaultVerifyCodeLength = 6
+const defaultPhoneSendInterval = 60 * time.Second
+const defaultPhoneMaxSendPerDay = 10
+const defaultPhoneMaxSendPerIP = 30
+const defaultEmailSendInterval = 60 * time.Second
+const defaultEmailMaxSendPerDay = 10
+const defaultEmailMaxSendPerIP = 30
+
+// The codes out of the rate limit window are deleted when expired, at most once per interval
+const verifyCodeRetention = 24 * time.Hour
+const verifyCodePruneInterval = time.Hour
+const defaultMagicLinkExpired = 15 * time.Minute
+const defaultMagicLinkSendInterval = 60 * time.Second
+const defaultAccountDeleteGrace = 30 * 24 * time.Hour
 
 type UserManager struct {
 	ext          *GinExt
@@ -45,6 +48,57 @@ type UserManager struct {
 	VerifyCodeLength          int
 	VerifyCodeMaxFailCount    int
 	EnabledTokenAuthorization bool
+
+	// Send the verify codes of phone, only SigUserVerifyPhone is emitted when nil
+	SMSSender SMSSender
+	// The country code of the phone without + or 00 prefix, e.g. 86
+	PhoneCountryCode string
+	// The rate limits of sending the verify codes per phone
+	PhoneSendInterval  time.Duration
+	PhoneMaxSendPerDay int
+	// The codes sent to any phones by the ip per day, unlimited when 0
+	PhoneMaxSendPerIP int
+	// The rate limits of sending the verify codes per email, and to any emails by the ip per day
+	EmailSendInterval  time.Duration
+	EmailMaxSendPerDay int
+	EmailMaxSendPerIP  int
+
+	// The magic link login, see magiclink.go
+	MagicLinkExpired      time.Duration
+	MagicLinkSendInterval time.Duration
+	// The allowed redirect urls after login, matched by the url or its prefix, the relative paths are always allowed
+	MagicLinkRedirects []string
+	// The link is only valid for the ip or the User-Agent which requested it
+	MagicLinkBindIP     bool
+	MagicLinkBindDevice bool
+
+	// The WebAuthn relying party, the id and origins default by SITE_LINK, the name by SITE_NAME
+	WebAuthnRPID    string
+	WebAuthnRPName  string
+	WebAuthnOrigins []string
+	WebAuthnTimeout time.Duration
+	// The user verification of authenticator: required, preferred or discouraged
+	WebAuthnUserVerification string
+
+	// The account is restorable in the grace period after deleted, see account.go
+	AccountDeleteGrace time.Duration
+	// Anonymise the user instead of deleting the row, e.g. the orders refer the user
+	AccountAnonymize bool
+	// Queue the deletion of accounts and the audit prune, DefaultWorkerManager when nil
+	WorkerManager *WorkerManager
+
+	// The rules of new passwords, DefaultPasswordPolicy when nil, see password.go
+	PasswordPolicy *PasswordPolicy
+
+	// The audit logs older than AuditRetention are pruned by the worker, see audit.go
+	AuditRetention     time.Duration
+	AuditPruneInterval time.Duration
+
+	// The invitation of organization is expired after OrgInviteExpired, see organization.go
+	OrgInviteExpired time.Duration
+
+	pruneMu           sync.Mutex
+	verifyCodePruneAt time.Time
 }
 
 func NewUserManager(ext *GinExt) *UserManager {
@@ -60,6 +114,20 @@ func NewUserManager(ext *GinExt) *UserManager {
 		VerifyCodeLength:          defaultVerifyCodeLength,
 		VerifyCodeMaxFailCount:    defaultVerifyMaxFailCount,
 		EnabledTokenAuthorization: true,
+		PhoneSendInterval:         defaultPhoneSendInterval,
+		PhoneMaxSendPerDay:        defaultPhoneMaxSendPerDay,
+		PhoneMaxSendPerIP:         defaultPhoneMaxSendPerIP,
+		EmailSendInterval:         defaultEmailSendInterval,
+		EmailMaxSendPerDay:        defaultEmailMaxSendPerDay,
+		EmailMaxSendPerIP:         defaultEmailMaxSendPerIP,
+		MagicLinkExpired:          defaultMagicLinkExpired,
+		MagicLinkSendInterval:     defaultMagicLinkSendInterval,
+		WebAuthnTimeout:           defaultWebAuthnTimeout,
+		WebAuthnUserVerification:  "preferred",
+		AccountDeleteGrace:        defaultAccountDeleteGrace,
+		AuditRetention:            defaultAuditRetention,
+		AuditPruneInterval:        defaultAuditPruneInterval,
+		OrgInviteExpired:          defaultOrgInviteExpired,
 	}
 }
 
@@ -69,6 +137,12 @@ func (um *UserManager) Init() (err error) {
 		&GinToken{},
 		&GinProfile{},
 		&GinVerifyCode{},
+		&GinCredential{},
+		&GinAuditLog{},
+		&GinPasswordHistory{},
+		&GinOrganization{},
+		&GinMembership{},
+		&GinInvitation{},
 	}
 	for _, t := range tables {
 		err = um.db.AutoMigrate(t)
@@ -77,7 +151,7 @@ func (um *UserManager) Init() (err error) {
 			return err
 		}
 	}
-	um.ext.CheckValue(key_ACTIVE_REQUIRED, "false")
+	um.ext.DefineValue(key_ACTIVE_REQUIRED, "false", "Require the user actived before login")
 	return nil
 }
 
@@ -122,6 +196,14 @@ func (um *UserManager) GetByEmail(email string) (user *GinExtUser, err error) {
 	return user, nil
 }
 
+func (um *UserManager) GetByPhone(phone string) (user *GinExtUser, err error) {
+	result := um.db.Where("phone", phone).Take(&user)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return user, nil
+}
+
 func (um *UserManager) Auth(usernameOrEmail, rawPassword string) (user *GinExtUser, err error) {
 	lowerVal := strings.ToLower(usernameOrEmail)
 	//var userObj User
@@ -155,9 +237,14 @@ func (um *UserManager) IsExistsByEmail(email string) bool {
 	return err == nil
 }
 
+// SetPassword without checking the PasswordPolicy, the old password is kept in the history
 func (um *UserManager) SetPassword(user *GinExtUser, password string) {
-	hashVal := um.hashPassword(password)
-	um.db.Model(user).Update("password", hashVal)
+	um.savePasswordHistory(user)
+	vals := map[string]interface{}{
+		"Password":          um.hashPassword(password),
+		"PasswordChangedAt": time.Now(),
+	}
+	um.db.Model(user).Updates(vals)
 }
 
 func (um *UserManager) hashPassword(password string) string {
@@ -235,25 +322,65 @@ func (um *UserManager) hasVerifyCode(email string) bool {
 	return result.Error == nil
 }
 
-func (um *UserManager) genVerifyCode(user *GinExtUser, email string) (string, string) {
-	key := RandText(um.VerifyKeyLength)
-	if user != nil {
-		key += fmt.Sprintf("-%d", user.ID)
+// checkSendRate the duration to wait before sending the verify code to source again,
+// column is source or ip, e.g. the codes sent to any phones by the ip
+func (um *UserManager) checkSendRate(column, val string, interval time.Duration, maxPerDay int) time.Duration {
+	var codes []GinVerifyCode
+	since := time.Now().Add(-verifyCodeRetention)
+	um.db.Where(column, val).Where("created_at > ?", since).Order("created_at desc").Find(&codes)
+	if len(codes) <= 0 {
+		return 0
+	}
+	if wait := interval - time.Since(codes[0].CreatedAt); wait > 0 {
+		return wait
+	}
+	if maxPerDay > 0 && len(codes) >= maxPerDay {
+		return codes[maxPerDay-1].CreatedAt.Add(24 * time.Hour).Sub(time.Now())
 	}
-	code := RandNumberText(um.VerifyCodeLength)
-	val := GinVerifyCode{
-		Key:       key,
+	return 0
+}
+
+func (um *UserManager) genVerifyCode(user *GinExtUser, email string) (string, string) {
+	return um.newVerifyCode(user, email, RandNumberText(um.VerifyCodeLength), um.VerifyCodeExpired)
+}
+
+func (um *UserManager) newVerifyCode(user *GinExtUser, email, code string, expired time.Duration) (string, string) {
+	return um.createVerifyCode(user, &GinVerifyCode{
 		Source:    email,
 		Code:      code,
-		FailCount: 0,
-		Verified:  false,
-		ExpiredAt: time.Now().Add(um.VerifyCodeExpired),
+		ExpiredAt: time.Now().Add(expired),
+	})
+}
+
+// createVerifyCode generate the key of val, e.g. the val with IP for the rate limits
+func (um *UserManager) createVerifyCode(user *GinExtUser, val *GinVerifyCode) (string, string) {
+	um.pruneVerifyCodes()
+	val.Key = RandText(um.VerifyKeyLength)
+	if user != nil {
+		val.Key += fmt.Sprintf("-%d", user.ID)
 	}
-	result := um.db.Create(&val)
+	result := um.db.Create(val)
 	if result.Error != nil {
 		return "", ""
 	}
-	return key, code
+	return val.Key, val.Code
+}
+
+// pruneVerifyCodes delete the expired codes out of the rate limit window, at most once per verifyCodePruneInterval
+func (um *UserManager) pruneVerifyCodes() {
+	um.pruneMu.Lock()
+	if time.Since(um.verifyCodePruneAt) < verifyCodePruneInterval {
+		um.pruneMu.Unlock()
+		return
+	}
+	um.verifyCodePruneAt = time.Now()
+	um.pruneMu.Unlock()
+
+	now := time.Now()
+	result := um.db.Where("created_at < ?", now.Add(-verifyCodeRetention)).Where("expired_at < ?", now).Delete(&GinVerifyCode{})
+	if result.Error != nil {
+		um.ext.Logger.Error("prune verify codes fail", "error", result.Error)
+	}
 }
 
 func (um *UserManager) verifyCode(key, email, code string) bool {
@@ -272,11 +399,13 @@ func (um *UserManager) verifyCode(key, email, code string) bool {
 		return false
 	}
 	if val.Code != code || val.Source != email {
-		um.db.Model(&val).UpdateColumn("fail_count", val.FailCount+1)
+		um.db.Model(&val).UpdateColumn("fail_count", gorm.Expr("fail_count + 1"))
 		return false
 	}
-	um.db.Delete(&val)
-	return true
+	// keep the verified code for the rate limits of sending, the code is consumed
+	// only by one of the concurrent requests
+	result = um.db.Model(&GinVerifyCode{}).Where("id", val.ID).Where("verified", false).UpdateColumn("verified", true)
+	return result.Error == nil && result.RowsAffected == 1
 }
 
 // Profile
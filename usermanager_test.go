@@ -6,6 +6,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
 )
 
 func NewTestUserManager() (um *UserManager, r *gin.Engine) {
@@ -169,4 +170,14 @@ func TestVerifyCode(t *testing.T) {
 		r := um.verifyCode(key, "bob@example.org", code)
 		assert.True(t, r)
 	}
+	{
+		// the concurrent request consumes the code after it is read
+		key, code := um.genVerifyCode(nil, "bob@example.org")
+		um.db.Callback().Query().After("gorm:query").Register("test:consume", func(db *gorm.DB) {
+			db.Session(&gorm.Session{NewDB: true}).Exec("UPDATE gin_verify_codes SET verified = ? WHERE `key` = ?", true, key)
+		})
+		r := um.verifyCode(key, "bob@example.org", code)
+		um.db.Callback().Query().Remove("test:consume")
+		assert.False(t, r)
+	}
 }
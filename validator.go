@@ -0,0 +1,214 @@
+package ginext
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.\-]{2,31}$`)
+var phonePattern = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// The messages of validation tags, the template same as the mails, {{.Param}} is the param of tag
+var validationMessages = struct {
+	sync.RWMutex
+	m map[string]string
+}{m: map[string]string{
+	"required":       "is required",
+	"min":            "must be at least {{.Param}}",
+	"max":            "must be at most {{.Param}}",
+	"len":            "length must be {{.Param}}",
+	"gte":            "must be at least {{.Param}}",
+	"lte":            "must be at most {{.Param}}",
+	"oneof":          "must be one of [{{.Param}}]",
+	"email":          "must be a valid email",
+	"strongpassword": "must be at least 8 characters with letters and digits",
+	"username":       "must be 3-32 letters, digits or _.-",
+	"phone":          "must be a E.164 phone number, e.g. +8613800138000",
+	"tzname":         "must be a timezone name, e.g. Asia/Shanghai",
+}}
+
+var registerValidatorsOnce sync.Once
+
+// registerValidators register the tags of forms into the validator of gin, by GinExt.Init and WithGinExt
+// instead of importing the package, the field errors are named by the json tag
+func registerValidators() {
+	registerValidatorsOnce.Do(func() {
+		v, ok := binding.Validator.Engine().(*validator.Validate)
+		if !ok {
+			return
+		}
+		registerBuiltinValidators(v)
+	})
+}
+
+func registerBuiltinValidators(v *validator.Validate) {
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+		if len(name) <= 0 || name == "-" {
+			return f.Name
+		}
+		return name
+	})
+	v.RegisterValidation("strongpassword", func(fl validator.FieldLevel) bool {
+		return IsStrongPassword(fl.Field().String())
+	})
+	v.RegisterValidation("username", func(fl validator.FieldLevel) bool {
+		return usernamePattern.MatchString(fl.Field().String())
+	})
+	v.RegisterValidation("phone", func(fl validator.FieldLevel) bool {
+		return phonePattern.MatchString(fl.Field().String())
+	})
+	v.RegisterValidation("tzname", func(fl validator.FieldLevel) bool {
+		name := fl.Field().String()
+		if len(name) <= 0 {
+			return false
+		}
+		_, err := time.LoadLocation(name)
+		return err == nil
+	})
+}
+
+// IsStrongPassword at least 8 characters, with letters and digits
+func IsStrongPassword(password string) bool {
+	if len(password) < 8 {
+		return false
+	}
+	var hasLetter, hasDigit bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			hasLetter = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		}
+	}
+	return hasLetter && hasDigit
+}
+
+// formValidators the validator of rpc forms in one GinExt, with the builtin and the registered tags
+type formValidators struct {
+	sync.RWMutex
+	v        *validator.Validate
+	messages map[string]string
+}
+
+func newFormValidators() *formValidators {
+	v := validator.New()
+	v.SetTagName("binding")
+	registerBuiltinValidators(v)
+	return &formValidators{v: v, messages: map[string]string{}}
+}
+
+// RegisterValidator register the validation tag of the rpc forms in this GinExt, msg is the field message when fail,
+// e.g. `binding:"required,nickname"`, translated by the key validation.<tag>
+func (cfg *GinExt) RegisterValidator(tag string, fn validator.Func, msg string) error {
+	if cfg.validators == nil {
+		return errors.New("GinExt is not created by NewGinExt")
+	}
+	cfg.validators.Lock()
+	defer cfg.validators.Unlock()
+	if err := cfg.validators.v.RegisterValidation(tag, fn); err != nil {
+		return err
+	}
+	cfg.validators.messages[tag] = msg
+	// the gin binding validate the forms too, the unknown tag panic there
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok && !hasValidation(v, tag) {
+		v.RegisterValidation(tag, func(fl validator.FieldLevel) bool { return true })
+	}
+	return nil
+}
+
+// hasValidation the validator panic with the undefined tag
+func hasValidation(v *validator.Validate, tag string) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	v.Var("", tag)
+	return true
+}
+
+// validateForm with the validators of GinExt, or the validator of gin without GinExt
+func validateForm(c *gin.Context, form interface{}) error {
+	if obj, ok := c.Get(ConfigField); ok && obj != nil && obj.(*GinExt).validators != nil {
+		rv := reflect.ValueOf(form)
+		for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+			rv = rv.Elem()
+		}
+		if rv.Kind() != reflect.Struct {
+			return nil
+		}
+		validators := obj.(*GinExt).validators
+		validators.RLock()
+		defer validators.RUnlock()
+		return validators.v.Struct(form)
+	}
+	if binding.Validator != nil {
+		return binding.Validator.ValidateStruct(form)
+	}
+	return nil
+}
+
+// validationMessage translated by the key validation.<tag>
+func validationMessage(c *gin.Context, fe validator.FieldError) string {
+	var msg string
+	var ok bool
+	if obj, exists := c.Get(ConfigField); exists && obj != nil && obj.(*GinExt).validators != nil {
+		validators := obj.(*GinExt).validators
+		validators.RLock()
+		msg, ok = validators.messages[fe.Tag()]
+		validators.RUnlock()
+	}
+	if !ok {
+		validationMessages.RLock()
+		msg, ok = validationMessages.m[fe.Tag()]
+		validationMessages.RUnlock()
+	}
+	if !ok {
+		msg = fmt.Sprintf("failed on the '%s' rule", fe.Tag())
+	}
+	return FormatData(Translate(c, "validation."+fe.Tag(), msg), map[string]string{"Param": fe.Param()}, msg)
+}
+
+// fieldName the json path of field, without the form name, e.g. profile.name
+func fieldName(fe validator.FieldError) string {
+	ns := fe.Namespace()
+	if idx := strings.Index(ns, "."); idx >= 0 {
+		return ns[idx+1:]
+	}
+	return fe.Field()
+}
+
+// bindFormError the validation and json type errors are sent with the messages of fields
+func bindFormError(c *gin.Context, err error) *RpcErr {
+	var errs validator.ValidationErrors
+	if errors.As(err, &errs) && len(errs) > 0 {
+		fields := map[string][]string{}
+		for _, fe := range errs {
+			name := fieldName(fe)
+			fields[name] = append(fields[name], validationMessage(c, fe))
+		}
+		return ErrBindForm.WithMsg(fieldName(errs[0]) + " " + validationMessage(c, errs[0])).WithFields(fields)
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && len(typeErr.Field) > 0 {
+		msg := "must be " + typeErr.Type.String()
+		return ErrBindForm.WithMsg(typeErr.Field + " " + msg).WithFields(map[string][]string{
+			typeErr.Field: {msg},
+		})
+	}
+	return ErrBindForm.WithMsg(err.Error())
+}
@@ -0,0 +1,127 @@
+package ginext
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+)
+
+type testProfileForm struct {
+	Phone    string `json:"phone" binding:"omitempty,phone"`
+	Timezone string `json:"timezone" binding:"omitempty,tzname"`
+}
+
+type testValidateForm struct {
+	Nickname string           `json:"nickname" binding:"required,nickname"`
+	Age      int              `json:"age" binding:"min=18"`
+	Profile  *testProfileForm `json:"profile" binding:"omitempty"`
+}
+
+func TestValidator(t *testing.T) {
+	assert.True(t, IsStrongPassword("hello123"))
+	assert.False(t, IsStrongPassword("12345678"))
+	assert.False(t, IsStrongPassword("hello12"))
+
+	// the tags are registered by Init, not by importing the package
+	cfg := NewGinExt("..")
+	assert.Nil(t, cfg.Init())
+	err := cfg.RegisterValidator("nickname", func(fl validator.FieldLevel) bool {
+		return !strings.Contains(fl.Field().String(), "admin")
+	}, "must not contain admin")
+	assert.Nil(t, err)
+
+	r := gin.New()
+	cfg.WithGinExt(r)
+	RpcDefine(r, &RpcContext{
+		Form:         testValidateForm{},
+		RelativePath: "/mockapi/validate",
+		Handler: func(c *gin.Context) {
+			RpcOk(c, true)
+		},
+	})
+
+	client := NewTestHTTPClient(r)
+	var ok bool
+	err = client.Call("/mockapi/validate", gin.H{
+		"nickname": "bob",
+		"age":      20,
+		"profile":  gin.H{"phone": "+8613800138000", "timezone": "Asia/Shanghai"},
+	}, &ok)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	w := client.Post("/mockapi/validate", map[string]interface{}{
+		"nickname": "superadmin",
+		"age":      10,
+		"profile":  gin.H{"phone": "13800138000", "timezone": "Mars/Base"},
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var body RpcErr
+	json.Unmarshal(w.Body.Bytes(), &body)
+	assert.Equal(t, "bind_error", body.Key)
+	assert.Equal(t, "nickname must not contain admin", body.Msg)
+	assert.Equal(t, map[string][]string{
+		"nickname":         {"must not contain admin"},
+		"age":              {"must be at least 18"},
+		"profile.phone":    {"must be a E.164 phone number, e.g. +8613800138000"},
+		"profile.timezone": {"must be a timezone name, e.g. Asia/Shanghai"},
+	}, body.Fields)
+
+	w = client.Post("/mockapi/validate", map[string]interface{}{"nickname": "bob", "age": "20"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var typeErr RpcErr
+	json.Unmarshal(w.Body.Bytes(), &typeErr)
+	assert.Equal(t, map[string][]string{"age": {"must be int"}}, typeErr.Fields)
+
+	// the tag is scoped to the GinExt registered
+	other := NewGinExt("..")
+	assert.False(t, hasValidation(other.validators.v, "nickname"))
+	assert.True(t, hasValidation(other.validators.v, "tzname"))
+}
+
+func TestValidateRegisterForm(t *testing.T) {
+	um, r := NewTestUserManager()
+	um.RegisterHandler("/auth", r)
+	client := NewTestHTTPClient(r)
+
+	w := client.Post("/auth/register", map[string]interface{}{
+		"email":    "bob@example.org",
+		"password": "123456",
+		"username": "b!",
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var body RpcErr
+	json.Unmarshal(w.Body.Bytes(), &body)
+	assert.Contains(t, body.Fields, "username")
+
+	// the password is checked by the PasswordPolicy, not the binding
+	w = client.Post("/auth/register", map[string]interface{}{
+		"email":    "bob@example.org",
+		"password": "123456",
+		"username": "bob",
+	})
+	json.Unmarshal(w.Body.Bytes(), &body)
+	assert.Equal(t, ErrCodeWeakPassword, body.Code)
+
+	w = client.Post("/auth/register", map[string]interface{}{
+		"email":    "bob@example.org",
+		"password": "hello123",
+		"username": "bob",
+		"timezone": "Mars/Base",
+	})
+	json.Unmarshal(w.Body.Bytes(), &body)
+	assert.Contains(t, body.Fields, "timezone")
+
+	um.PasswordPolicy = &PasswordPolicy{MinLength: 6}
+	var info UserInfoResult
+	assert.Nil(t, client.Call("/auth/register", map[string]interface{}{
+		"email":    "bob@example.org",
+		"password": "123456",
+		"username": "bob",
+	}, &info))
+}
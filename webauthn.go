@@ -0,0 +1,639 @@
+package ginext
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// The COSE algorithms of credential public key
+const (
+	COSEAlgES256 = -7
+	COSEAlgEdDSA = -8
+	COSEAlgRS256 = -257
+)
+
+const (
+	webAuthnFlagUP = 0x01
+	webAuthnFlagUV = 0x04
+	webAuthnFlagAT = 0x40
+
+	webAuthnSourceRegister = "webauthn.register:"
+	webAuthnSourceLogin    = "webauthn.login"
+)
+
+const defaultWebAuthnTimeout = 120 * time.Second
+
+// The RSA keys shorter than it are rejected
+const rsaMinKeyBits = 2048
+
+type WebAuthnRP struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+}
+
+type WebAuthnUser struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type WebAuthnCredParam struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type WebAuthnCredDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+type WebAuthnSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// WebAuthnCreationOptions the publicKey of navigator.credentials.create, the binaries are base64url encoded
+type WebAuthnCreationOptions struct {
+	Challenge              string                   `json:"challenge"`
+	RP                     WebAuthnRP               `json:"rp"`
+	User                   WebAuthnUser             `json:"user"`
+	PubKeyCredParams       []WebAuthnCredParam      `json:"pubKeyCredParams"`
+	Timeout                int64                    `json:"timeout"`
+	ExcludeCredentials     []WebAuthnCredDescriptor `json:"excludeCredentials,omitempty"`
+	AuthenticatorSelection WebAuthnSelection        `json:"authenticatorSelection"`
+	Attestation            string                   `json:"attestation"`
+}
+
+// WebAuthnRequestOptions the publicKey of navigator.credentials.get
+type WebAuthnRequestOptions struct {
+	Challenge        string                   `json:"challenge"`
+	RPID             string                   `json:"rpId"`
+	Timeout          int64                    `json:"timeout"`
+	AllowCredentials []WebAuthnCredDescriptor `json:"allowCredentials,omitempty"`
+	UserVerification string                   `json:"userVerification"`
+}
+
+type WebAuthnResponse struct {
+	ClientDataJSON    string   `json:"clientDataJSON" binding:"required"`
+	AttestationObject string   `json:"attestationObject"`
+	Transports        []string `json:"transports"`
+	AuthenticatorData string   `json:"authenticatorData"`
+	Signature         string   `json:"signature"`
+	UserHandle        string   `json:"userHandle"`
+}
+
+// WebAuthnCredentialForm the PublicKeyCredential of browser, the binaries are base64url encoded
+type WebAuthnCredentialForm struct {
+	ID       string           `json:"id" binding:"required"`
+	Type     string           `json:"type"`
+	Name     string           `json:"name"`
+	Response WebAuthnResponse `json:"response"`
+}
+
+type WebAuthnLoginForm struct {
+	UserName string `json:"username"`
+	Email    string `json:"email"`
+}
+
+type WebAuthnCredentialDeleteForm struct {
+	ID uint `json:"id" binding:"required"`
+}
+
+type WebAuthnCredentialResult struct {
+	ID           uint       `json:"id"`
+	CredentialID string     `json:"credentialId"`
+	Name         string     `json:"name"`
+	Transports   []string   `json:"transports"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	LastUsed     *time.Time `json:"lastUsed,omitempty"`
+}
+
+type webAuthnClientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+type webAuthnAuthData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+// decodeBase64URL the base64url with or without padding
+func decodeBase64URL(v string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(v, "="))
+}
+
+func parseWebAuthnAuthData(data []byte) (*webAuthnAuthData, error) {
+	if len(data) < 37 {
+		return nil, errors.New("bad authenticator data")
+	}
+	ad := &webAuthnAuthData{
+		RPIDHash:  data[:32],
+		Flags:     data[32],
+		SignCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	if ad.Flags&webAuthnFlagAT == 0 {
+		return ad, nil
+	}
+	// aaguid(16) + credentialIdLength(2) + credentialId + publicKey
+	data = data[37:]
+	if len(data) < 18 {
+		return nil, errors.New("bad attested credential data")
+	}
+	idLen := int(binary.BigEndian.Uint16(data[16:18]))
+	data = data[18:]
+	if len(data) < idLen {
+		return nil, errors.New("bad attested credential data")
+	}
+	ad.CredentialID = data[:idLen]
+	_, rest, err := cborDecode(data[idLen:])
+	if err != nil {
+		return nil, err
+	}
+	ad.PublicKey = data[idLen : len(data)-len(rest)]
+	return ad, nil
+}
+
+func coseInt(m map[interface{}]interface{}, key int64) (int64, bool) {
+	v, ok := m[key].(int64)
+	return v, ok
+}
+
+func coseBytes(m map[interface{}]interface{}, key int64) []byte {
+	v, _ := m[key].([]byte)
+	return v
+}
+
+// parseCOSEKey the public key and algorithm, ES256, EdDSA and RS256 are supported
+func parseCOSEKey(data []byte) (crypto.PublicKey, int64, error) {
+	v, _, err := cborDecode(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, 0, errors.New("bad cose key")
+	}
+	kty, _ := coseInt(m, 1)
+	alg, _ := coseInt(m, 3)
+	switch {
+	case kty == 2 && alg == COSEAlgES256:
+		x, y := coseBytes(m, -2), coseBytes(m, -3)
+		if crv, _ := coseInt(m, -1); crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, 0, errors.New("bad ec2 key")
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, 0, errors.New("bad ec2 key")
+		}
+		return pub, alg, nil
+	case kty == 1 && alg == COSEAlgEdDSA:
+		x := coseBytes(m, -2)
+		if crv, _ := coseInt(m, -1); crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, 0, errors.New("bad okp key")
+		}
+		return ed25519.PublicKey(x), alg, nil
+	case kty == 3 && alg == COSEAlgRS256:
+		n, e := coseBytes(m, -1), coseBytes(m, -2)
+		if len(n) <= 0 || len(e) <= 0 || len(e) > 4 {
+			return nil, 0, errors.New("bad rsa key")
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		// the weak keys are rejected, the exponent is odd and at least 3, e.g. 65537
+		if pub.N.BitLen() < rsaMinKeyBits || pub.E < 3 || pub.E%2 == 0 {
+			return nil, 0, errors.New("weak rsa key")
+		}
+		return pub, alg, nil
+	}
+	return nil, 0, errors.New("unsupported cose key")
+}
+
+func verifyWebAuthnSignature(pub crypto.PublicKey, alg int64, data, sig []byte) bool {
+	digest := sha256.Sum256(data)
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		return alg == COSEAlgES256 && ecdsa.VerifyASN1(k, digest[:], sig)
+	case ed25519.PublicKey:
+		return alg == COSEAlgEdDSA && ed25519.Verify(k, data, sig)
+	case *rsa.PublicKey:
+		return alg == COSEAlgRS256 && rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil
+	}
+	return false
+}
+
+// webAuthnRP the id, name and origins of relying party, default by SITE_LINK and SITE_NAME
+func (um *UserManager) webAuthnRP() (id, name string, origins []string) {
+	id, name, origins = um.WebAuthnRPID, um.WebAuthnRPName, um.WebAuthnOrigins
+	siteLink := strings.TrimSuffix(um.ext.GetValue(Key_SITE_LINK), "/")
+	if len(origins) <= 0 && len(siteLink) > 0 {
+		origins = []string{siteLink}
+	}
+	if len(id) <= 0 {
+		if u, err := url.Parse(siteLink); err == nil {
+			id = u.Hostname()
+		}
+	}
+	if len(name) <= 0 {
+		name = um.ext.GetValue(Key_SITE_NAME)
+	}
+	if len(name) <= 0 {
+		name = id
+	}
+	return id, name, origins
+}
+
+func (um *UserManager) webAuthnTimeout() time.Duration {
+	if um.WebAuthnTimeout > 0 {
+		return um.WebAuthnTimeout
+	}
+	return defaultWebAuthnTimeout
+}
+
+func webAuthnUserHandle(user *GinExtUser) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(uint64(user.ID), 10)))
+}
+
+// GetCredentials the WebAuthn credentials of user
+func (um *UserManager) GetCredentials(user *GinExtUser) (creds []GinCredential, err error) {
+	result := um.db.Where("user_id", user.ID).Order("id").Find(&creds)
+	return creds, result.Error
+}
+
+func credentialDescriptors(creds []GinCredential) []WebAuthnCredDescriptor {
+	descs := make([]WebAuthnCredDescriptor, 0, len(creds))
+	for _, v := range creds {
+		descs = append(descs, WebAuthnCredDescriptor{Type: "public-key", ID: v.CredentialID, Transports: v.GetTransports()})
+	}
+	return descs
+}
+
+// checkClientData the type, challenge and origin of clientDataJSON, return the challenge
+func (um *UserManager) checkClientData(raw []byte, typ string) (string, error) {
+	var cd webAuthnClientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return "", errors.New("bad client data")
+	}
+	if cd.Type != typ {
+		return "", errors.New("bad client data type")
+	}
+	_, _, origins := um.webAuthnRP()
+	allowed := false
+	for _, v := range origins {
+		if cd.Origin == v {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return "", errors.New("origin is not allowed")
+	}
+	challenge, err := decodeBase64URL(cd.Challenge)
+	if err != nil {
+		return "", errors.New("bad challenge")
+	}
+	return string(challenge), nil
+}
+
+// checkAuthData the rp id hash and the user flags
+func (um *UserManager) checkAuthData(ad *webAuthnAuthData) error {
+	rpID, _, _ := um.webAuthnRP()
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	if !bytes.Equal(ad.RPIDHash, rpIDHash[:]) {
+		return errors.New("bad rp id")
+	}
+	if ad.Flags&webAuthnFlagUP == 0 {
+		return errors.New("user not present")
+	}
+	if um.WebAuthnUserVerification == "required" && ad.Flags&webAuthnFlagUV == 0 {
+		return errors.New("user not verified")
+	}
+	return nil
+}
+
+// verifyAttestation the none and packed attestations, the certificate chain of packed is not verified
+func verifyAttestation(format string, stmt map[interface{}]interface{}, authData []byte, clientDataHash []byte, pub crypto.PublicKey, alg int64) error {
+	switch format {
+	case "none":
+		return nil
+	case "packed":
+		sig, _ := stmt["sig"].([]byte)
+		stmtAlg, _ := stmt["alg"].(int64)
+		data := append(append([]byte{}, authData...), clientDataHash...)
+		if x5c, ok := stmt["x5c"].([]interface{}); ok && len(x5c) > 0 {
+			der, _ := x5c[0].([]byte)
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return err
+			}
+			if !verifyWebAuthnSignature(cert.PublicKey, stmtAlg, data, sig) {
+				return errors.New("bad attestation signature")
+			}
+			return nil
+		}
+		// self attestation
+		if stmtAlg != alg || !verifyWebAuthnSignature(pub, alg, data, sig) {
+			return errors.New("bad attestation signature")
+		}
+		return nil
+	}
+	return errors.New("unsupported attestation " + format)
+}
+
+// WebAuthnRequired the middleware require the user verified by WebAuthn in the session, as the second factor
+func (um *UserManager) WebAuthnRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := CurrentUser(c)
+		if user == nil {
+			RpcAbort(c, ErrAuthRequired)
+			return
+		}
+		if sessions.Default(c).Get(WebAuthnUserField) != user.ID {
+			RpcAbort(c, ErrWebAuthnRequired)
+			return
+		}
+		c.Next()
+	}
+}
+
+func (um *UserManager) handleWebAuthnRegisterBegin(c *gin.Context) {
+	user := CurrentUser(c)
+	rpID, rpName, _ := um.webAuthnRP()
+	timeout := um.webAuthnTimeout()
+	challenge, _ := um.newVerifyCode(user, webAuthnSourceRegister+strconv.FormatUint(uint64(user.ID), 10), "", timeout)
+	if len(challenge) <= 0 {
+		RpcFail(c, ErrCodeServerError, "create challenge fail")
+		return
+	}
+	creds, _ := um.GetCredentials(user)
+	RpcOk(c, WebAuthnCreationOptions{
+		Challenge: base64.RawURLEncoding.EncodeToString([]byte(challenge)),
+		RP:        WebAuthnRP{ID: rpID, Name: rpName},
+		User: WebAuthnUser{
+			ID:          webAuthnUserHandle(user),
+			Name:        user.UserName,
+			DisplayName: user.GetVisibleName(),
+		},
+		PubKeyCredParams: []WebAuthnCredParam{
+			{Type: "public-key", Alg: COSEAlgES256},
+			{Type: "public-key", Alg: COSEAlgEdDSA},
+			{Type: "public-key", Alg: COSEAlgRS256},
+		},
+		Timeout:            timeout.Milliseconds(),
+		ExcludeCredentials: credentialDescriptors(creds),
+		AuthenticatorSelection: WebAuthnSelection{
+			ResidentKey:      "preferred",
+			UserVerification: um.WebAuthnUserVerification,
+		},
+		Attestation: "none",
+	})
+}
+
+func (um *UserManager) handleWebAuthnRegisterFinish(c *gin.Context) {
+	user := CurrentUser(c)
+	form := c.MustGet(RpcFormField).(*WebAuthnCredentialForm)
+	cred, err := um.registerCredential(user, form)
+	if err != nil {
+		RpcFail(c, ErrCodeWebAuthnFail, err.Error())
+		return
+	}
+	RpcOk(c, cred.Result())
+}
+
+func (um *UserManager) registerCredential(user *GinExtUser, form *WebAuthnCredentialForm) (*GinCredential, error) {
+	clientDataJSON, err := decodeBase64URL(form.Response.ClientDataJSON)
+	if err != nil {
+		return nil, errors.New("bad client data")
+	}
+	challenge, err := um.checkClientData(clientDataJSON, "webauthn.create")
+	if err != nil {
+		return nil, err
+	}
+	if !um.verifyCode(challenge, webAuthnSourceRegister+strconv.FormatUint(uint64(user.ID), 10), "") {
+		return nil, errors.New("bad challenge")
+	}
+
+	attObject, err := decodeBase64URL(form.Response.AttestationObject)
+	if err != nil {
+		return nil, errors.New("bad attestation object")
+	}
+	v, _, err := cborDecode(attObject)
+	if err != nil {
+		return nil, errors.New("bad attestation object")
+	}
+	att, _ := v.(map[interface{}]interface{})
+	format, _ := att["fmt"].(string)
+	stmt, _ := att["attStmt"].(map[interface{}]interface{})
+	rawAuthData, _ := att["authData"].([]byte)
+
+	ad, err := parseWebAuthnAuthData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err = um.checkAuthData(ad); err != nil {
+		return nil, err
+	}
+	if len(ad.CredentialID) <= 0 {
+		return nil, errors.New("attested credential data required")
+	}
+	pub, alg, err := parseCOSEKey(ad.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	if err = verifyAttestation(format, stmt, rawAuthData, clientDataHash[:], pub, alg); err != nil {
+		return nil, err
+	}
+
+	name := form.Name
+	if len(name) <= 0 {
+		name = "Passkey"
+	}
+	cred := GinCredential{
+		UserID:       user.ID,
+		CredentialID: base64.RawURLEncoding.EncodeToString(ad.CredentialID),
+		PublicKey:    ad.PublicKey,
+		SignCount:    ad.SignCount,
+		Transports:   strings.Join(form.Response.Transports, ","),
+		Name:         name,
+	}
+	if result := um.db.Create(&cred); result.Error != nil {
+		return nil, errors.New("credential exists")
+	}
+	return &cred, nil
+}
+
+// handleWebAuthnLoginBegin the credentials of current user (second factor) or the username,
+// any discoverable credential when both are empty
+func (um *UserManager) handleWebAuthnLoginBegin(c *gin.Context) {
+	form := c.MustGet(RpcFormField).(*WebAuthnLoginForm)
+	user := CurrentUser(c)
+	if user == nil && (len(form.UserName) > 0 || len(form.Email) > 0) {
+		key := form.UserName
+		if len(key) <= 0 {
+			key = form.Email
+		}
+		lowerVal := strings.ToLower(key)
+		um.db.Where("user_name", lowerVal).Or("email", lowerVal).Take(&user)
+	}
+
+	var allows []WebAuthnCredDescriptor
+	if user != nil && user.ID > 0 {
+		creds, _ := um.GetCredentials(user)
+		allows = credentialDescriptors(creds)
+	}
+
+	rpID, _, _ := um.webAuthnRP()
+	timeout := um.webAuthnTimeout()
+	challenge, _ := um.newVerifyCode(nil, webAuthnSourceLogin, "", timeout)
+	if len(challenge) <= 0 {
+		RpcFail(c, ErrCodeServerError, "create challenge fail")
+		return
+	}
+	RpcOk(c, WebAuthnRequestOptions{
+		Challenge:        base64.RawURLEncoding.EncodeToString([]byte(challenge)),
+		RPID:             rpID,
+		Timeout:          timeout.Milliseconds(),
+		AllowCredentials: allows,
+		UserVerification: um.WebAuthnUserVerification,
+	})
+}
+
+// handleWebAuthnLoginFinish login with the credential, the session is marked as verified by WebAuthn
+func (um *UserManager) handleWebAuthnLoginFinish(c *gin.Context) {
+	form := c.MustGet(RpcFormField).(*WebAuthnCredentialForm)
+	current := CurrentUser(c)
+	cred, err := um.verifyAssertion(form)
+	if err == nil && current != nil && current.ID != cred.UserID {
+		err = errors.New("credential of other user")
+	}
+	if err != nil {
+		um.loginFailed(c, "", err.Error())
+		RpcFail(c, ErrCodeWebAuthnFail, err.Error())
+		return
+	}
+
+	user := &cred.User
+	if !user.Enabled {
+		um.loginFailed(c, user.UserName, "user is not allow login")
+		RpcAbort(c, ErrUserNotAllowed)
+		return
+	}
+	if current == nil {
+		if !um.CheckForceActived(user) {
+			um.loginFailed(c, user.UserName, "user need actived first")
+			RpcAbort(c, ErrActiveRequired)
+			return
+		}
+		um.ext.Metrics.incLogin(true)
+		Login(c, user)
+	}
+	session := sessions.Default(c)
+	session.Set(WebAuthnUserField, user.ID)
+	session.Save()
+
+	RpcOk(c, UserInfoResult{
+		UserName:  user.UserName,
+		Email:     user.Email,
+		LastLogin: user.LastLogin,
+	})
+}
+
+func (um *UserManager) verifyAssertion(form *WebAuthnCredentialForm) (*GinCredential, error) {
+	var cred GinCredential
+	if um.db.Where("credential_id", strings.TrimRight(form.ID, "=")).Preload("User").Take(&cred).Error != nil {
+		return nil, errors.New("unknown credential")
+	}
+	if len(form.Response.UserHandle) > 0 && strings.TrimRight(form.Response.UserHandle, "=") != webAuthnUserHandle(&cred.User) {
+		return nil, errors.New("bad user handle")
+	}
+
+	clientDataJSON, err := decodeBase64URL(form.Response.ClientDataJSON)
+	if err != nil {
+		return nil, errors.New("bad client data")
+	}
+	challenge, err := um.checkClientData(clientDataJSON, "webauthn.get")
+	if err != nil {
+		return nil, err
+	}
+	if !um.verifyCode(challenge, webAuthnSourceLogin, "") {
+		return nil, errors.New("bad challenge")
+	}
+
+	rawAuthData, err := decodeBase64URL(form.Response.AuthenticatorData)
+	if err != nil {
+		return nil, errors.New("bad authenticator data")
+	}
+	ad, err := parseWebAuthnAuthData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err = um.checkAuthData(ad); err != nil {
+		return nil, err
+	}
+
+	pub, alg, err := parseCOSEKey(cred.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := decodeBase64URL(form.Response.Signature)
+	if err != nil {
+		return nil, errors.New("bad signature")
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	if !verifyWebAuthnSignature(pub, alg, append(rawAuthData, clientDataHash[:]...), sig) {
+		return nil, errors.New("bad signature")
+	}
+	// the counter not increased means the authenticator may be cloned
+	if (ad.SignCount > 0 || cred.SignCount > 0) && ad.SignCount <= cred.SignCount {
+		return nil, errors.New("bad sign count")
+	}
+
+	now := time.Now()
+	cred.SignCount = ad.SignCount
+	cred.LastUsed = &now
+	um.db.Model(&cred).UpdateColumns(map[string]interface{}{"SignCount": ad.SignCount, "LastUsed": &now})
+	return &cred, nil
+}
+
+func (um *UserManager) handleWebAuthnCredentials(c *gin.Context) {
+	creds, err := um.GetCredentials(CurrentUser(c))
+	if err != nil {
+		RpcError(c, err)
+		return
+	}
+	r := make([]WebAuthnCredentialResult, 0, len(creds))
+	for _, v := range creds {
+		r = append(r, v.Result())
+	}
+	RpcOk(c, r)
+}
+
+func (um *UserManager) handleWebAuthnCredentialDelete(c *gin.Context) {
+	user := CurrentUser(c)
+	form := c.MustGet(RpcFormField).(*WebAuthnCredentialDeleteForm)
+	result := um.db.Where("user_id", user.ID).Delete(&GinCredential{}, form.ID)
+	RpcOk(c, result.RowsAffected > 0)
+}
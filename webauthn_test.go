@@ -0,0 +1,291 @@
+package ginext
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// cborEncode the items of software authenticator
+func cborEncode(v interface{}) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n < 1<<8:
+			return []byte{major<<5 | 24, byte(n)}
+		case n < 1<<16:
+			return []byte{major<<5 | 25, byte(n >> 8), byte(n)}
+		}
+		b := []byte{major<<5 | 26, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		return b
+	}
+	switch val := v.(type) {
+	case int:
+		if val < 0 {
+			return head(1, uint64(-1-val))
+		}
+		return head(0, uint64(val))
+	case []byte:
+		return append(head(2, uint64(len(val))), val...)
+	case string:
+		return append(head(3, uint64(len(val))), val...)
+	case []interface{}:
+		data := head(4, uint64(len(val)))
+		for _, item := range val {
+			data = append(data, cborEncode(item)...)
+		}
+		return data
+	case map[interface{}]interface{}:
+		var items [][]byte
+		for k, item := range val {
+			items = append(items, append(cborEncode(k), cborEncode(item)...))
+		}
+		sort.Slice(items, func(i, j int) bool { return string(items[i]) < string(items[j]) })
+		data := head(5, uint64(len(val)))
+		for _, item := range items {
+			data = append(data, item...)
+		}
+		return data
+	}
+	panic("unsupported")
+}
+
+// softAuthenticator the ES256 authenticator for testing
+type softAuthenticator struct {
+	origin    string
+	rpID      string
+	key       *ecdsa.PrivateKey
+	credID    []byte
+	signCount uint32
+}
+
+func newSoftAuthenticator(origin, rpID string) *softAuthenticator {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	credID := make([]byte, 16)
+	rand.Read(credID)
+	return &softAuthenticator{origin: origin, rpID: rpID, key: key, credID: credID}
+}
+
+func (a *softAuthenticator) clientData(typ, challenge string) []byte {
+	data, _ := json.Marshal(map[string]string{"type": typ, "challenge": challenge, "origin": a.origin})
+	return data
+}
+
+func (a *softAuthenticator) authData(attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	data := append([]byte{}, rpIDHash[:]...)
+	flags := byte(webAuthnFlagUP | webAuthnFlagUV)
+	if attested {
+		flags |= webAuthnFlagAT
+	}
+	a.signCount++
+	data = append(data, flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[33:], a.signCount)
+	if attested {
+		data = append(data, make([]byte, 16)...)
+		data = append(data, byte(len(a.credID)>>8), byte(len(a.credID)))
+		data = append(data, a.credID...)
+		x, y := make([]byte, 32), make([]byte, 32)
+		a.key.X.FillBytes(x)
+		a.key.Y.FillBytes(y)
+		data = append(data, cborEncode(map[interface{}]interface{}{1: 2, 3: COSEAlgES256, -1: 1, -2: x, -3: y})...)
+	}
+	return data
+}
+
+func (a *softAuthenticator) sign(authData, clientData []byte) []byte {
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	sig, _ := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	return sig
+}
+
+func (a *softAuthenticator) create(options WebAuthnCreationOptions) WebAuthnCredentialForm {
+	clientData := a.clientData("webauthn.create", options.Challenge)
+	authData := a.authData(true)
+	att := cborEncode(map[interface{}]interface{}{
+		"fmt":      "packed",
+		"attStmt":  map[interface{}]interface{}{"alg": COSEAlgES256, "sig": a.sign(authData, clientData)},
+		"authData": authData,
+	})
+	enc := base64.RawURLEncoding.EncodeToString
+	return WebAuthnCredentialForm{
+		ID:   enc(a.credID),
+		Type: "public-key",
+		Name: "soft key",
+		Response: WebAuthnResponse{
+			ClientDataJSON:    enc(clientData),
+			AttestationObject: enc(att),
+			Transports:        []string{"internal", "hybrid"},
+		},
+	}
+}
+
+func (a *softAuthenticator) get(options WebAuthnRequestOptions) WebAuthnCredentialForm {
+	clientData := a.clientData("webauthn.get", options.Challenge)
+	authData := a.authData(false)
+	enc := base64.RawURLEncoding.EncodeToString
+	return WebAuthnCredentialForm{
+		ID:   enc(a.credID),
+		Type: "public-key",
+		Response: WebAuthnResponse{
+			ClientDataJSON:    enc(clientData),
+			AuthenticatorData: enc(authData),
+			Signature:         enc(a.sign(authData, clientData)),
+		},
+	}
+}
+
+func TestCborDecode(t *testing.T) {
+	data := cborEncode(map[interface{}]interface{}{"a": []interface{}{1, -300, "x", []byte{1, 2}}, 3: 70000})
+	v, rest, err := cborDecode(append(data, 0xf5))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0xf5}, rest)
+	m := v.(map[interface{}]interface{})
+	assert.Equal(t, []interface{}{int64(1), int64(-300), "x", []byte{1, 2}}, m["a"])
+	assert.Equal(t, int64(70000), m[int64(3)])
+
+	_, _, err = cborDecode(data[:len(data)-1])
+	assert.NotNil(t, err)
+
+	// the nested arrays
+	nested := append(bytes.Repeat([]byte{0x81}, cborMaxDepth), 0x01)
+	_, _, err = cborDecode(nested)
+	assert.Nil(t, err)
+	_, _, err = cborDecode(append([]byte{0x81}, nested...))
+	assert.NotNil(t, err)
+}
+
+func TestParseCOSEKeyRSA(t *testing.T) {
+	rsaKey := func(bits int, e []byte) []byte {
+		n := append([]byte{0x80}, make([]byte, bits/8-1)...)
+		return cborEncode(map[interface{}]interface{}{1: 3, 3: COSEAlgRS256, -1: n, -2: e})
+	}
+	_, _, err := parseCOSEKey(rsaKey(2048, []byte{1, 0, 1}))
+	assert.Nil(t, err)
+	_, _, err = parseCOSEKey(rsaKey(1024, []byte{1, 0, 1}))
+	assert.NotNil(t, err)
+	_, _, err = parseCOSEKey(rsaKey(2048, []byte{1}))
+	assert.NotNil(t, err)
+	_, _, err = parseCOSEKey(rsaKey(2048, []byte{1, 0, 0}))
+	assert.NotNil(t, err)
+}
+
+func TestWebAuthn(t *testing.T) {
+	um, r := NewTestUserManager()
+	um.db.Delete(&GinCredential{}, "id > 0")
+	um.WebAuthnRPID = "example.org"
+	um.WebAuthnOrigins = []string{"https://example.org"}
+	um.RegisterHandler("/auth", r)
+	r.GET("/admin", um.WebAuthnRequired(), func(c *gin.Context) {
+		RpcOk(c, true)
+	})
+	client := NewTestHTTPClient(r)
+	addUser(t, client, r, "bob", "bob@example.org", "hello123")
+	var info UserInfoResult
+	err := client.Call("/auth/login", LoginForm{UserName: "bob", Password: "hello123"}, &info)
+	assert.Nil(t, err)
+
+	w := client.Get("/admin")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "webauthn_required")
+
+	authenticator := newSoftAuthenticator("https://example.org", "example.org")
+	{
+		var options WebAuthnCreationOptions
+		err = client.Call("/auth/webauthn/register/begin", nil, &options)
+		assert.Nil(t, err)
+		assert.Equal(t, "example.org", options.RP.ID)
+		assert.Equal(t, "bob", options.User.Name)
+
+		var cred WebAuthnCredentialResult
+		err = client.Call("/auth/webauthn/register/finish", authenticator.create(options), &cred)
+		assert.Nil(t, err)
+		assert.Equal(t, "soft key", cred.Name)
+		assert.Equal(t, []string{"internal", "hybrid"}, cred.Transports)
+
+		// the challenge is single-use
+		err = client.Call("/auth/webauthn/register/finish", authenticator.create(options), &cred)
+		assert.NotNil(t, err)
+	}
+	{
+		// as the second factor
+		var options WebAuthnRequestOptions
+		err = client.Call("/auth/webauthn/login/begin", WebAuthnLoginForm{}, &options)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(options.AllowCredentials))
+
+		form := authenticator.get(options)
+		err = client.Call("/auth/webauthn/login/finish", form, &info)
+		assert.Nil(t, err)
+		w = client.Get("/admin")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		err = client.Call("/auth/webauthn/login/finish", form, &info)
+		assert.NotNil(t, err)
+		assert.Equal(t, "bad challenge", err.Error())
+	}
+	{
+		// passwordless
+		err = client.Call("/auth/logout", nil, nil)
+		assert.Nil(t, err)
+		w = client.Get("/admin")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		var options WebAuthnRequestOptions
+		err = client.Call("/auth/webauthn/login/begin", WebAuthnLoginForm{}, &options)
+		assert.Nil(t, err)
+		assert.Empty(t, options.AllowCredentials)
+		err = client.Call("/auth/webauthn/login/finish", authenticator.get(options), &info)
+		assert.Nil(t, err)
+		assert.Equal(t, "bob", info.UserName)
+		w = client.Get("/admin")
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	{
+		// the password login requires the passkey again
+		err = client.Call("/auth/login", LoginForm{UserName: "bob", Password: "hello123"}, &info)
+		assert.Nil(t, err)
+		w = client.Get("/admin")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		var options WebAuthnRequestOptions
+		client.Call("/auth/webauthn/login/begin", WebAuthnLoginForm{}, &options)
+		authenticator.signCount = 0
+		err = client.Call("/auth/webauthn/login/finish", authenticator.get(options), &info)
+		assert.NotNil(t, err)
+		assert.Equal(t, "bad sign count", err.Error())
+
+		authenticator.signCount = 100
+		authenticator.origin = "https://evil.org"
+		client.Call("/auth/webauthn/login/begin", WebAuthnLoginForm{}, &options)
+		err = client.Call("/auth/webauthn/login/finish", authenticator.get(options), &info)
+		assert.NotNil(t, err)
+		assert.Equal(t, "origin is not allowed", err.Error())
+	}
+	{
+		var creds []WebAuthnCredentialResult
+		err = client.Call("/auth/webauthn/credentials", nil, &creds)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(creds))
+		assert.NotNil(t, creds[0].LastUsed)
+
+		ok := false
+		err = client.Call("/auth/webauthn/credentials/delete", WebAuthnCredentialDeleteForm{ID: creds[0].ID}, &ok)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+	}
+}
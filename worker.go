@@ -4,8 +4,13 @@ import (
 	"context"
 	"errors"
 	"log"
+	"log/slog"
+	"math"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 )
 
@@ -19,22 +24,30 @@ type Worker struct {
 	WorkerID uint
 	Name     string
 	Handlers map[string]WorkHandle
+	Logger   *slog.Logger
+	Metrics  *Metrics
 
-	PullInterval  time.Duration
-	PullTaskNum   int
-	TaskNum       int
+	PullInterval time.Duration
+	PullTaskNum  int
+	TaskNum      int
+	// The delay of first retry, doubled for the next, up to MaxRetryDelay
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
 	masterContext context.Context
 	pullCancel    context.CancelFunc
 }
 
 func NewWorker(db *gorm.DB, name string) *Worker {
 	w := &Worker{
-		db:           db.Session(&gorm.Session{}),
-		Name:         name,
-		PullInterval: 1 * time.Second,
-		PullTaskNum:  20,
-		TaskNum:      4,
-		Handlers:     make(map[string]WorkHandle),
+		db:            db.Session(&gorm.Session{}),
+		Name:          name,
+		PullInterval:  1 * time.Second,
+		PullTaskNum:   20,
+		TaskNum:       4,
+		RetryDelay:    30 * time.Second,
+		MaxRetryDelay: 6 * time.Hour,
+		Handlers:      make(map[string]WorkHandle),
+		Logger:        slog.Default().With("worker", name),
 	}
 	w.masterContext = context.Background()
 	return w
@@ -77,10 +90,16 @@ func (w *Worker) pullTasks() error {
 	result := tx.Order("start_time").Find(&ts)
 
 	if result.Error != nil {
-		log.Println("query tasks fail", result.Error)
+		w.Logger.Error("query tasks fail", "error", result.Error)
 		return result.Error
 	}
 
+	if w.Metrics != nil {
+		var depth int64
+		w.db.Model(&GinTask{}).Where("done", false).Count(&depth)
+		w.Metrics.setQueueDepth(w.Name, depth)
+	}
+
 	for _, t := range ts {
 		if t.StartTime != nil {
 			if time.Since(*t.StartTime) < 0 {
@@ -90,7 +109,7 @@ func (w *Worker) pullTasks() error {
 		w.execute(func() {
 			err := w.DoTask(&t)
 			if err != nil {
-				log.Printf("task fail taskid:%d type:%s err:%v", t.ID, t.TaskType, err)
+				w.Logger.Error("task fail", "task_id", t.ID, "task_type", t.TaskType, "error", err)
 			}
 		}, 60*time.Second)
 	}
@@ -106,9 +125,18 @@ func (w *Worker) DoTask(t *GinTask) error {
 	if !ok {
 		return errors.New("unknown task type")
 	}
-	now := time.Now()
+	ctx, span := tracer().Start(extractTraceContext(t.TraceContext), "task "+t.TaskType,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.Int64("task.id", int64(t.ID)),
+			attribute.Int64("task.object_id", t.ObjectID),
+		))
+	defer span.End()
+	t.ctx = ctx
+
+	execTime := time.Now()
 	vals := map[string]interface{}{
-		"ExecTime": &now,
+		"ExecTime": &execTime,
 	}
 
 	var handleResult string
@@ -121,17 +149,44 @@ func (w *Worker) DoTask(t *GinTask) error {
 		err = e
 	})
 
-	if err != nil {
-		vals["Failed"] = true
-	}
-	now = time.Now()
+	now := time.Now()
+	w.Metrics.observeTask(t.TaskType, now.Sub(execTime), err != nil)
 	vals["EndTime"] = &now
 	vals["Result"] = handleResult
 	vals["Done"] = true
-	w.db.Model(&t).UpdateColumns(vals)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		if t.Retries < t.MaxRetries {
+			startTime := now.Add(w.retryDelay(t.Retries))
+			vals["Retries"] = t.Retries + 1
+			vals["StartTime"] = &startTime
+			vals["Result"] = err.Error()
+			vals["Done"] = false
+		} else {
+			vals["Failed"] = true
+		}
+	}
+	w.db.WithContext(ctx).Model(&t).UpdateColumns(vals)
 	return err
 }
 
+// retryDelay doubled by the retries, the shift is capped so the delay never overflows
+func (w *Worker) retryDelay(retries int) time.Duration {
+	limit := w.MaxRetryDelay
+	if limit <= 0 {
+		limit = math.MaxInt64 / 2
+	}
+	delay := w.RetryDelay
+	for i := 0; i < retries && delay > 0 && delay < limit; i++ {
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+	return delay
+}
+
 type WorkerManager struct {
 	db  *gorm.DB
 	ext *GinExt
@@ -165,26 +220,36 @@ func (wm *WorkerManager) Init() (err error) {
 	return wm.Migrate()
 }
 
-func (wm *WorkerManager) Add(objectID int64, taskType, context string, delays time.Duration) error {
+func (wm *WorkerManager) Add(objectID int64, taskType, taskContext string, delays time.Duration) error {
+	return wm.AddContext(context.Background(), objectID, taskType, taskContext, delays)
+}
+
+// AddContext save the trace context of ctx into the task, the worker continues the trace
+func (wm *WorkerManager) AddContext(ctx context.Context, objectID int64, taskType, taskContext string, delays time.Duration) error {
 	o := GinTask{
-		CreatedAt: time.Now(),
-		TaskType:  taskType,
-		ObjectID:  objectID,
-		Done:      false,
-		Context:   context,
-		Result:    "",
+		TaskType: taskType,
+		ObjectID: objectID,
+		Context:  taskContext,
 	}
 	if delays.Seconds() > 0 {
 		now := time.Now().Add(delays)
 		o.StartTime = &now
 	}
+	return wm.AddTask(ctx, &o)
+}
+
+// AddTask queue the task, e.g. with MaxRetries
+func (wm *WorkerManager) AddTask(ctx context.Context, o *GinTask) error {
+	o.CreatedAt = time.Now()
+	o.Done = false
+	o.TraceContext = injectTraceContext(ctx)
 	if wm == nil {
 		log.Panic("wm is nil", wm)
 	}
 	if wm.db == nil {
 		log.Panic("wm.db is nil", wm)
 	}
-	result := wm.db.Create(&o)
+	result := wm.db.Create(o)
 	if result.Error != nil {
 		return result.Error
 	}
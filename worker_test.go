@@ -128,3 +128,14 @@ func TestWorkerFail(t *testing.T) {
 		assert.True(t, ts[0].Failed)
 	}
 }
+
+func TestWorkerRetryDelay(t *testing.T) {
+	w := &Worker{RetryDelay: 30 * time.Second, MaxRetryDelay: 6 * time.Hour}
+	assert.Equal(t, 30*time.Second, w.retryDelay(0))
+	assert.Equal(t, 2*time.Minute, w.retryDelay(2))
+	assert.Equal(t, w.MaxRetryDelay, w.retryDelay(100))
+
+	// never overflow without the max
+	w.MaxRetryDelay = 0
+	assert.True(t, w.retryDelay(100) > 0)
+}